@@ -0,0 +1,197 @@
+package sett
+
+import (
+	"context"
+	"math"
+)
+
+// FieldStats summarizes the numeric values of a field
+type FieldStats struct {
+	// Count is the number of values where the field is a number
+	Count int
+	Sum   float64
+	// Min and Max are 0 when Count is 0
+	Min float64
+	Max float64
+}
+
+// Mean returns the average of the values, 0 when there are none
+func (fs FieldStats) Mean() float64 {
+	if fs.Count == 0 {
+		return 0
+	}
+	return fs.Sum / float64(fs.Count)
+}
+
+func (fs *FieldStats) add(f float64) {
+	if fs.Count == 0 {
+		fs.Min, fs.Max = f, f
+	}
+	fs.Count++
+	fs.Sum += f
+	fs.Min = math.Min(fs.Min, f)
+	fs.Max = math.Max(fs.Max, f)
+}
+
+// numberField returns the field of the value as float64, if it is a number
+func numberField(v interface{}, field string) (float64, bool) {
+	fv, ok := fieldValue(v, field)
+	if !ok {
+		return 0, false
+	}
+	n, ok := normalize(fv)
+	if !ok {
+		return 0, false
+	}
+	f, ok := n.(float64)
+	return f, ok
+}
+
+// Reducer folds the values of a group of GroupBy into acc,
+// which is nil for the first value of the group
+type Reducer func(acc interface{}, k string, v interface{}) interface{}
+
+// CountReducer counts the values of each group, as int
+func CountReducer() Reducer {
+	return func(acc interface{}, k string, v interface{}) interface{} {
+		n, _ := acc.(int)
+		return n + 1
+	}
+}
+
+// SumReducer sums the numeric field of the values of each group, as float64
+func SumReducer(field string) Reducer {
+	return func(acc interface{}, k string, v interface{}) interface{} {
+		sum, _ := acc.(float64)
+		f, _ := numberField(v, field)
+		return sum + f
+	}
+}
+
+// StatsReducer summarizes the numeric field of the values of each group, as FieldStats
+func StatsReducer(field string) Reducer {
+	return func(acc interface{}, k string, v interface{}) interface{} {
+		fs, _ := acc.(FieldStats)
+		if f, ok := numberField(v, field); ok {
+			fs.add(f)
+		}
+		return fs
+	}
+}
+
+// Count returns the number of values of the table matching pred,
+// all of them if pred is nil
+func (s *Sett) Count(pred FilterFunc) (int, error) {
+	return s.CountCtx(context.Background(), pred)
+}
+
+// CountCtx is Count with a context. The scan stops when ctx is done
+func (s *Sett) CountCtx(ctx context.Context, pred FilterFunc) (n int, err error) {
+	err = s.aggregate(ctx, pred == nil, func(key string, v interface{}) {
+		if pred == nil || pred(key, v) {
+			n++
+		}
+	})
+	return n, err
+}
+
+// Sum returns the sum of the numeric field of the values of the table.
+// The values where the field is missing or not a number are skipped
+func (s *Sett) Sum(field string) (float64, error) {
+	fs, err := s.Stats(field)
+	return fs.Sum, err
+}
+
+// SumCtx is Sum with a context
+func (s *Sett) SumCtx(ctx context.Context, field string) (float64, error) {
+	fs, err := s.StatsCtx(ctx, field)
+	return fs.Sum, err
+}
+
+// Stats returns the count, sum, min and max of the numeric field of the values of the table.
+// The values where the field is missing or not a number are skipped
+func (s *Sett) Stats(field string) (FieldStats, error) {
+	return s.StatsCtx(context.Background(), field)
+}
+
+// StatsCtx is Stats with a context. The scan stops when ctx is done
+func (s *Sett) StatsCtx(ctx context.Context, field string) (fs FieldStats, err error) {
+	err = s.aggregate(ctx, false, func(key string, v interface{}) {
+		if f, ok := numberField(v, field); ok {
+			fs.add(f)
+		}
+	})
+	return fs, err
+}
+
+// GroupBy folds the values of the table with reducer, grouped by the
+// key keyFn returns for each of them. Values for which keyFn returns
+// false are skipped. Returns the result of the reducer for each group
+//
+//	totals, err := orders.GroupBy(func(k string, v interface{}) (string, bool) {
+//		return v.(*Order).Status, true
+//	}, sett.SumReducer("Amount"))
+func (s *Sett) GroupBy(keyFn func(k string, v interface{}) (string, bool), reducer Reducer) (map[string]interface{}, error) {
+	return s.GroupByCtx(context.Background(), keyFn, reducer)
+}
+
+// GroupByCtx is GroupBy with a context. The scan stops when ctx is done
+func (s *Sett) GroupByCtx(ctx context.Context, keyFn func(k string, v interface{}) (string, bool), reducer Reducer) (map[string]interface{}, error) {
+	groups := make(map[string]interface{})
+	err := s.aggregate(ctx, false, func(key string, v interface{}) {
+		if g, ok := keyFn(key, v); ok {
+			groups[g] = reducer(groups[g], key, v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// aggregate streams the values of the table to fn in a single read
+// transaction. With keysOnly, the values are neither read nor decoded
+// and fn gets nil
+func (s *Sett) aggregate(ctx context.Context, keysOnly bool, fn func(key string, v interface{})) error {
+	return s.do(ctx, OpAggregate, "", nil, func(c *opCall) error {
+		return s.db.View(func(txn Txn) error {
+			return s.scanTable(txn, "", keysOnly, func(key string, item Item) (bool, error) {
+				if err := c.canceled(); err != nil {
+					return false, err
+				}
+				var v interface{}
+				if !keysOnly {
+					var err error
+					si := NewSettItem(s, txn, key)
+					v, err = si.value(item)
+					if err != nil {
+						return false, err
+					}
+				}
+				fn(key, v)
+				return true, nil
+			})
+		})
+	})
+}
+
+// scanTable calls visit with the items of the table whose keys start with
+// prefix, in key order, until it returns false. With keysOnly the values
+// are not fetched, otherwise the backend prefetches them
+func (s *Sett) scanTable(txn Txn, prefix string, keysOnly bool, visit func(key string, item Item) (bool, error)) error {
+	tablePrefix := s.makeKey("")
+	p := []byte(tablePrefix + prefix)
+	it := txn.NewIterator(IteratorOptions{Prefix: p, KeysOnly: keysOnly})
+	defer it.Close()
+	for it.Seek(p); it.Valid(); it.Next() {
+		item := it.Item()
+		if isSystemKey(item.Key()) {
+			continue
+		}
+		more, err := visit(string(item.Key()[len(tablePrefix):]), item)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testAggregate(t, b.open(t))
+		})
+	}
+}
+
+func testAggregate(t *testing.T, s *sett.Sett) {
+	gob.Register(&OrderObj{})
+	orders := s.Table("orders")
+	statuses := []string{"open", "shipped", "closed"}
+	for i := 1; i <= 12; i++ {
+		orders.SetStruct(fmt.Sprintf("o%02d", i), &OrderObj{Status: statuses[i%3], Amount: float64(i), Qty: i})
+	}
+	orders.SetStr("note", "not an order")
+	s.Table("other").SetStruct("o01", &OrderObj{Amount: 1000})
+
+	n, err := orders.Count(nil)
+	if err != nil || n != 13 {
+		t.Errorf("Expected 13 items, got %d %v", n, err)
+	}
+	n, _ = orders.Count(func(k string, v interface{}) bool {
+		o, ok := v.(*OrderObj)
+		return ok && o.Status == "open"
+	})
+	if n != 4 {
+		t.Errorf("Expected 4 open orders, got %d", n)
+	}
+
+	sum, err := orders.Sum("Amount")
+	if err != nil || sum != 78 {
+		t.Errorf("Expected a sum of 78, got %v %v", sum, err)
+	}
+	fs, _ := orders.Stats("Qty")
+	if fs.Count != 12 || fs.Min != 1 || fs.Max != 12 || fs.Mean() != 6.5 {
+		t.Errorf("Unexpected stats %+v", fs)
+	}
+
+	byStatus := func(k string, v interface{}) (string, bool) {
+		o, ok := v.(*OrderObj)
+		if !ok {
+			return "", false
+		}
+		return o.Status, true
+	}
+	totals, err := orders.GroupBy(byStatus, sett.SumReducer("Amount"))
+	if err != nil {
+		t.Fatalf("GroupBy failed %v", err)
+	}
+	// open: 3+6+9+12, shipped: 1+4+7+10, closed: 2+5+8+11
+	if len(totals) != 3 || totals["open"] != 30.0 || totals["shipped"] != 22.0 || totals["closed"] != 26.0 {
+		t.Errorf("Unexpected totals %v", totals)
+	}
+	counts, _ := orders.GroupBy(byStatus, sett.CountReducer())
+	if counts["open"] != 4 {
+		t.Errorf("Unexpected counts %v", counts)
+	}
+	stats, _ := orders.GroupBy(byStatus, sett.StatsReducer("Amount"))
+	if st := stats["closed"].(sett.FieldStats); st.Min != 2 || st.Max != 11 {
+		t.Errorf("Unexpected group stats %+v", st)
+	}
+}
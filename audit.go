@@ -0,0 +1,270 @@
+package sett
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// AuditOptions configures the audit trail of a table
+type AuditOptions struct {
+	// Retention is how long the records are kept, 0 keeps them forever
+	Retention time.Duration
+	// StoreValues keeps the old and new values in the records, not only
+	// their hashes. Values of encrypted tables are kept encrypted
+	StoreValues bool
+	// Actor returns who makes the change. Defaults to ActorFrom
+	Actor func(ctx context.Context) string
+}
+
+// AuditRecord is a change made to a table
+type AuditRecord struct {
+	Time  time.Time
+	Actor string `json:",omitempty"`
+	Table string
+	Key   string
+	Op    OpKind
+	// OldHash and NewHash are the hex SHA-256 of the plain values,
+	// empty when there is no value
+	OldHash  string `json:",omitempty"`
+	NewHash  string `json:",omitempty"`
+	OldValue []byte `json:",omitempty"`
+	NewValue []byte `json:",omitempty"`
+	// OldMeta and NewMeta are the value types of OldValue and NewValue
+	OldMeta byte `json:",omitempty"`
+	NewMeta byte `json:",omitempty"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context telling the audit trail who makes the changes
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set by WithActor
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// WithAudit records every Set, Insert, Update, Cut, Delete, Lock and Drop
+// made to the table, in the same transaction as the change. Writes made by
+// Sett itself, like imports, TTL changes and key rotation, are not recorded.
+// As with WithEncryption, the setting applies to every handle of the table
+func (s *Sett) WithAudit(opts AuditOptions) *Sett {
+	if opts.Actor == nil {
+		opts.Actor = ActorFrom
+	}
+	s.shared.tables.update(s.table, func(o *tableOptions) {
+		o.audit = &opts
+	})
+	return s
+}
+
+var (
+	auditByTime = []byte("aud:t:")
+	auditByKey  = []byte("aud:k:")
+)
+
+// auditSeq tells apart the records made in the same nanosecond
+var auditSeq uint64
+
+// The records are kept twice, ordered by time under
+// auditByTime | len(table) (2 bytes) | table | time (8 bytes) | seq (8 bytes)
+// and by key under
+// auditByKey | len(table) (2 bytes) | table | len(key) (2 bytes) | key | time | seq
+func lengthPrefixed(b []byte) []byte {
+	lb := make([]byte, 2, 2+len(b))
+	binary.BigEndian.PutUint16(lb, uint16(len(b)))
+	return append(lb, b...)
+}
+
+func timeBytes(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func (s *Sett) audit(c *opCall, txn Txn, fullKey []byte, old Item, e *Entry) error {
+	opts := s.options().audit
+	now := time.Now()
+	rec := AuditRecord{
+		Time:  now.UTC(),
+		Actor: opts.Actor(c.ctx),
+		Table: s.table,
+		Key:   string(fullKey[len(s.makeKey("")):]),
+		Op:    c.op,
+	}
+	if old != nil {
+		val, err := old.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		rec.OldHash, err = s.valueHash(rec.Key, old.UserMeta(), val)
+		if err != nil {
+			return err
+		}
+		if opts.StoreValues {
+			rec.OldValue, rec.OldMeta = val, old.UserMeta()
+		}
+	}
+	if e != nil {
+		var err error
+		rec.NewHash, err = s.valueHash(rec.Key, e.UserMeta, e.Value)
+		if err != nil {
+			return err
+		}
+		if opts.StoreValues {
+			rec.NewValue, rec.NewMeta = e.Value, e.UserMeta
+		}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var expiresAt uint64
+	if opts.Retention > 0 {
+		expiresAt = uint64(now.Add(opts.Retention).Unix())
+	}
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, atomic.AddUint64(&auditSeq, 1))
+	table := lengthPrefixed([]byte(s.table))
+	ts := timeBytes(now)
+	err = txn.Set(&Entry{Key: systemKey(auditByTime, table, ts, seq), Value: data, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	key := systemKey(auditByKey, table, lengthPrefixed([]byte(rec.Key)), ts, seq)
+	return txn.Set(&Entry{Key: key, Value: data, ExpiresAt: expiresAt})
+}
+
+// valueHash returns the hash of the plain value
+func (s *Sett) valueHash(key string, meta byte, val []byte) (string, error) {
+	plain, err := NewSettItem(s, nil, key).plainValue(meta, val)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(plain)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// AuditByKey returns the changes made to the key, oldest first.
+// A limit of 0 returns all of them
+func (s *Sett) AuditByKey(key string, limit int) ([]AuditRecord, error) {
+	return s.AuditByKeyCtx(context.Background(), key, limit)
+}
+
+// AuditByKeyCtx is AuditByKey with a context
+func (s *Sett) AuditByKeyCtx(ctx context.Context, key string, limit int) (records []AuditRecord, err error) {
+	err = s.do(ctx, OpAudit, key, nil, func(c *opCall) error {
+		prefix := systemKey(auditByKey, lengthPrefixed([]byte(s.table)), lengthPrefixed([]byte(c.key)))
+		records, err = s.auditRecords(c, prefix, prefix, nil, limit)
+		c.result = records
+		return err
+	})
+	return records, err
+}
+
+// AuditByTime returns the changes made to the table from from until to, oldest first.
+// A zero from or to leaves the range open. A limit of 0 returns all of them
+func (s *Sett) AuditByTime(from time.Time, to time.Time, limit int) ([]AuditRecord, error) {
+	return s.AuditByTimeCtx(context.Background(), from, to, limit)
+}
+
+// AuditByTimeCtx is AuditByTime with a context
+func (s *Sett) AuditByTimeCtx(ctx context.Context, from time.Time, to time.Time, limit int) (records []AuditRecord, err error) {
+	err = s.do(ctx, OpAudit, "", nil, func(c *opCall) error {
+		prefix := systemKey(auditByTime, lengthPrefixed([]byte(s.table)))
+		seek := prefix
+		if !from.IsZero() {
+			seek = append(append([]byte{}, prefix...), timeBytes(from)...)
+		}
+		var end []byte
+		if !to.IsZero() {
+			end = append(append([]byte{}, prefix...), timeBytes(to)...)
+		}
+		records, err = s.auditRecords(c, prefix, seek, end, limit)
+		c.result = records
+		return err
+	})
+	return records, err
+}
+
+func (s *Sett) auditRecords(c *opCall, prefix []byte, seek []byte, end []byte, limit int) ([]AuditRecord, error) {
+	var records []AuditRecord
+	err := s.db.View(func(txn Txn) error {
+		it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Seek(seek); it.Valid(); it.Next() {
+			if err := c.canceled(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if end != nil && bytes.Compare(item.Key(), end) >= 0 {
+				break
+			}
+			var rec AuditRecord
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &rec)
+			})
+			if err != nil {
+				return err
+			}
+			err = s.openAuditValues(&rec)
+			if err != nil {
+				return err
+			}
+			records = append(records, rec)
+			if limit > 0 && len(records) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// openAuditValues decrypts the values kept in the record
+func (s *Sett) openAuditValues(rec *AuditRecord) error {
+	si := NewSettItem(s.Table(rec.Table), nil, rec.Key)
+	var err error
+	if rec.OldValue != nil {
+		rec.OldValue, err = si.plainValue(rec.OldMeta, rec.OldValue)
+		if err != nil {
+			return err
+		}
+		rec.OldMeta &^= ENCRYPTED_FLAG
+	}
+	if rec.NewValue != nil {
+		rec.NewValue, err = si.plainValue(rec.NewMeta, rec.NewValue)
+		if err != nil {
+			return err
+		}
+		rec.NewMeta &^= ENCRYPTED_FLAG
+	}
+	return nil
+}
+
+// DecodeValues returns the old and new values kept in the record, as Get
+// would return them. Requires AuditOptions.StoreValues
+func (rec *AuditRecord) DecodeValues() (oldValue interface{}, newValue interface{}, err error) {
+	if rec.OldValue != nil {
+		oldValue, err = decodeValue(rec.OldMeta, rec.OldValue)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if rec.NewValue != nil {
+		newValue, err = decodeValue(rec.NewMeta, rec.NewValue)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return oldValue, newValue, nil
+}
@@ -0,0 +1,95 @@
+package sett_test
+
+import (
+	"context"
+	"encoding/gob"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testAudit(t, b.open(t))
+		})
+	}
+}
+
+func testAudit(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	tbl := s.Table("accounts").WithAudit(sett.AuditOptions{StoreValues: true})
+	ctx := sett.WithActor(context.Background(), "alice")
+
+	start := time.Now()
+	tbl.SetStructCtx(ctx, "a1", &TaskObj{ID: 1, Status: "open"})
+	tbl.LockCtx(ctx, "a1")
+	tbl.UpdateCtx(ctx, "a1", func(v interface{}) error {
+		v.(*TaskObj).Status = "closed"
+		return nil
+	}, true)
+	mid := time.Now()
+	tbl.SetStr("a2", "v")
+	tbl.Delete("a2")
+	tbl.Cut("a1")
+	s.Table("other").SetStr("a1", "not audited")
+
+	recs, err := tbl.AuditByKey("a1", 0)
+	if err != nil {
+		t.Fatalf("AuditByKey failed %v", err)
+	}
+	want := []sett.OpKind{sett.OpSet, sett.OpLock, sett.OpUpdate, sett.OpCut}
+	if len(recs) != len(want) {
+		t.Fatalf("Expected %d records, got %+v", len(want), recs)
+	}
+	for i, op := range want {
+		if recs[i].Op != op || recs[i].Table != "accounts" || recs[i].Key != "a1" {
+			t.Errorf("Expected %s of a1, got %+v", op, recs[i])
+		}
+	}
+	if recs[0].Actor != "alice" || recs[0].OldHash != "" || recs[0].NewHash == "" {
+		t.Errorf("Unexpected first record %+v", recs[0])
+	}
+	if recs[1].OldHash != recs[1].NewHash {
+		t.Errorf("Lock changed the value hash")
+	}
+	if recs[2].OldHash == recs[2].NewHash || recs[3].NewHash != "" {
+		t.Errorf("Hashes don't follow the changes %+v", recs[2:])
+	}
+	oldV, newV, err := recs[2].DecodeValues()
+	if err != nil || oldV.(*TaskObj).Status != "open" || newV.(*TaskObj).Status != "closed" {
+		t.Errorf("Unexpected values of the update %v %v %v", oldV, newV, err)
+	}
+
+	recs, _ = tbl.AuditByTime(start, mid, 0)
+	if len(recs) != 3 {
+		t.Errorf("Expected 3 records in the time range, got %d", len(recs))
+	}
+	recs, _ = tbl.AuditByTime(mid, time.Time{}, 2)
+	if len(recs) != 2 || recs[0].Key != "a2" || recs[1].Op != sett.OpDelete {
+		t.Errorf("Unexpected records after mid %+v", recs)
+	}
+	keys, _ := s.Keys()
+	for _, k := range keys {
+		if k != "" && k[0] == 0xff {
+			t.Errorf("Audit records listed as keys")
+		}
+	}
+}
+
+func TestAuditRetention(t *testing.T) {
+	s := setttest.New(t)
+	tbl := s.Table("t").WithAudit(sett.AuditOptions{Retention: time.Second})
+	tbl.SetStr("k", "v")
+	recs, _ := tbl.AuditByKey("k", 0)
+	if len(recs) != 1 || recs[0].NewValue != nil {
+		t.Fatalf("Expected one record without values, got %+v", recs)
+	}
+	time.Sleep(2 * time.Second)
+	recs, _ = tbl.AuditByKey("k", 0)
+	if len(recs) != 0 {
+		t.Errorf("Records kept beyond the retention %+v", recs)
+	}
+}
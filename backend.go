@@ -0,0 +1,93 @@
+package sett
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Backend is the embedded key/value engine a store runs on.
+// Tables, locks, TTL and value types are all implemented by Sett
+// on top of these few operations. Badger is the default backend,
+// see OpenBolt for the alternative
+type Backend interface {
+	// View runs fn in a read only transaction
+	View(fn func(txn Txn) error) error
+	// Update runs fn in a read write transaction, committed if fn returns nil
+	Update(fn func(txn Txn) error) error
+	Close() error
+}
+
+// Txn is a transaction of a Backend
+type Txn interface {
+	// Get returns ErrKeyNotFound when the key is missing or has expired
+	Get(key []byte) (Item, error)
+	Set(e *Entry) error
+	Delete(key []byte) error
+	// NewIterator returns an iterator over the keys in key order.
+	// Call Seek before using it and Close when done
+	NewIterator(opts IteratorOptions) Iterator
+}
+
+// Item is a key and its value read in a transaction. The slices
+// returned by Key and Value are valid only until the transaction ends
+// or the iterator moves on; use KeyCopy and ValueCopy to keep them
+type Item interface {
+	Key() []byte
+	KeyCopy(dst []byte) []byte
+	Value(fn func(val []byte) error) error
+	ValueCopy(dst []byte) ([]byte, error)
+	UserMeta() byte
+	// ExpiresAt is the unix time the item expires at, 0 if it doesn't
+	ExpiresAt() uint64
+	// Version increases every time the key is written
+	Version() uint64
+}
+
+// Entry is a value to be written by Txn.Set
+type Entry struct {
+	Key   []byte
+	Value []byte
+	// UserMeta holds the value type and flags of the item
+	UserMeta byte
+	// ExpiresAt is the unix time the item expires at, 0 to keep it forever
+	ExpiresAt uint64
+}
+
+type IteratorOptions struct {
+	// Prefix limits the iteration to the keys with the prefix
+	Prefix []byte
+	// KeysOnly tells the backend the values won't be read
+	KeysOnly bool
+	// Reverse walks the keys in descending order,
+	// Seek moving to the last key <= key
+	Reverse bool
+}
+
+// Iterator walks over the keys of a transaction
+type Iterator interface {
+	// Seek moves to the first key >= key
+	Seek(key []byte)
+	// Valid is false once the iterator moves past the last key with the prefix
+	Valid() bool
+	Next()
+	Item() Item
+	Close()
+}
+
+// The errors are those of badger, so that callers checking
+// for badger's errors keep working with any backend
+var (
+	ErrKeyNotFound = badger.ErrKeyNotFound
+	ErrConflict    = badger.ErrConflict
+	ErrTxnTooBig   = badger.ErrTxnTooBig
+)
+
+// ErrNotSupported is returned for operations the backend of the store can't perform
+var ErrNotSupported = errors.New("operation not supported by the backend")
+
+// OpenBackend creates a store on the backend
+func OpenBackend(b Backend, options ...OpenOption) *Sett {
+	s := &Sett{db: b, shared: newShared()}
+	s.apply(options)
+	return s
+}
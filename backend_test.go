@@ -0,0 +1,300 @@
+package sett_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"path/filepath"
+	"sort"
+	"syreclabs.com/go/faker"
+	"testing"
+	"time"
+)
+
+// backends lists the stores the conformance suite runs against
+var backends = []struct {
+	name string
+	open func(t testing.TB) *sett.Sett
+}{
+	{"badger-memory", setttest.New},
+	{"badger-disk", setttest.NewOnDisk},
+	{"bolt", setttest.NewBolt},
+}
+
+var conformance = []struct {
+	name string
+	run  func(t *testing.T, s *sett.Sett)
+}{
+	{"SetGet", conformSetGet},
+	{"Struct", conformStruct},
+	{"Delete", conformDelete},
+	{"Keys", conformKeys},
+	{"TTL", conformTTL},
+	{"TTLControl", conformTTLControl},
+	{"Lock", conformLock},
+	{"Drop", conformDrop},
+	{"CutAndFilter", conformCutAndFilter},
+	{"Encryption", conformEncryption},
+	{"ExportImport", conformExportImport},
+}
+
+func TestBackendConformance(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			for _, c := range conformance {
+				c := c
+				t.Run(c.name, func(t *testing.T) {
+					c.run(t, b.open(t))
+				})
+			}
+		})
+	}
+}
+
+func conformSetGet(t *testing.T, s *sett.Sett) {
+	err := s.Table("t").SetStr("k", "v1")
+	if err != nil {
+		t.Fatalf("Set failed %v", err)
+	}
+	err = s.Table("t").SetStr("k", "v2")
+	if err != nil {
+		t.Fatalf("Set failed %v", err)
+	}
+	v, err := s.Table("t").GetStr("k")
+	if err != nil || v != "v2" {
+		t.Errorf("Expected v2 got %s %v", v, err)
+	}
+	_, err = s.GetStr("k")
+	if !errors.Is(err, sett.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound outside the table, got %v", err)
+	}
+}
+
+func conformStruct(t *testing.T, s *sett.Sett) {
+	gob.Register(&Signup{})
+	su := Signup{Name: faker.Name().Name(), Email: faker.Internet().SafeEmail(), Age: 20}
+	k, err := s.Table("signups").Insert(&su)
+	if err != nil {
+		t.Fatalf("Insert failed %v", err)
+	}
+	v, err := s.Table("signups").Get(k)
+	if err != nil {
+		t.Fatalf("Get failed %v", err)
+	}
+	if *v.(*Signup) != su {
+		t.Errorf("The value does not match")
+	}
+	_, err = s.Table("signups").GetStr(k)
+	if err == nil {
+		t.Errorf("Could get a struct as string")
+	}
+}
+
+func conformDelete(t *testing.T, s *sett.Sett) {
+	s.SetStr("k1", "v1")
+	s.SetStr("k2", "v2")
+	err := s.Delete("k1")
+	if err != nil {
+		t.Fatalf("Delete failed %v", err)
+	}
+	if s.HasKey("k1") {
+		t.Errorf("Deleted key still exists")
+	}
+	if !s.HasKey("k2") {
+		t.Errorf("Other key was deleted")
+	}
+}
+
+func conformKeys(t *testing.T, s *sett.Sett) {
+	want := []string{"a1", "a2", "b1"}
+	for _, k := range want {
+		s.Table("t").SetStr(k, k)
+	}
+	s.Table("t2").SetStr("a3", "x")
+	keys, err := s.Table("t").Keys()
+	if err != nil {
+		t.Fatalf("Keys failed %v", err)
+	}
+	if !sort.StringsAreSorted(keys) || len(keys) != 3 {
+		t.Errorf("Expected keys %v in order, got %v", want, keys)
+	}
+	keys, _ = s.Table("t").Keys("a")
+	if len(keys) != 2 {
+		t.Errorf("Expected 2 keys with prefix, got %v", keys)
+	}
+}
+
+func conformTTL(t *testing.T, s *sett.Sett) {
+	s.Table("t").SetStr("permanent", "v")
+	err := s.Table("t").WithTTL(100*time.Millisecond).SetStr("k", "v")
+	if err != nil {
+		t.Fatalf("Set failed %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if s.Table("t").HasKey("k") {
+		t.Errorf("Key is available after expiry")
+	}
+	keys, _ := s.Table("t").Keys()
+	if len(keys) != 1 || keys[0] != "permanent" {
+		t.Errorf("Expired key is listed in keys %v", keys)
+	}
+}
+
+func conformTTLControl(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	tbl := s.Table("t")
+	err := tbl.SetStr("k", "v", sett.ExpireIn(time.Hour))
+	if err != nil {
+		t.Fatalf("Set failed %v", err)
+	}
+	ttl, err := tbl.TTL("k")
+	if err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected TTL of about an hour, got %v %v", ttl, err)
+	}
+	err = tbl.Touch("k", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Touch failed %v", err)
+	}
+	ttl, _ = tbl.TTL("k")
+	if ttl <= time.Hour {
+		t.Errorf("Touch didn't extend the TTL, got %v", ttl)
+	}
+	err = tbl.Persist("k")
+	if err != nil {
+		t.Fatalf("Persist failed %v", err)
+	}
+	ttl, _ = tbl.TTL("k")
+	if ttl != 0 {
+		t.Errorf("Persist didn't remove the TTL, got %v", ttl)
+	}
+	v, _ := tbl.GetStr("k")
+	if v != "v" {
+		t.Errorf("Value changed by TTL updates")
+	}
+
+	// Per call options take precedence over the TTL of the handle
+	err = s.Table("t").WithTTL(time.Hour).SetStr("k2", "v", sett.ExpireAt(time.Now().Add(-time.Second)))
+	if err != nil {
+		t.Fatalf("Set failed %v", err)
+	}
+	if tbl.HasKey("k2") {
+		t.Errorf("Key set to expire in the past is available")
+	}
+
+	k, _ := tbl.Insert(&TaskObj{ID: 1}, sett.ExpireIn(time.Hour))
+	tbl.Lock(k)
+	_, err = tbl.Update(k, func(v interface{}) error { return nil }, true, sett.KeepTTL())
+	if err != nil {
+		t.Fatalf("Update failed %v", err)
+	}
+	ttl, _ = tbl.TTL(k)
+	if ttl == 0 {
+		t.Errorf("Lock or Update with KeepTTL removed the expiry")
+	}
+}
+
+func conformLock(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	k, _ := s.Table("t").Insert(&TaskObj{ID: 1})
+	err := s.Table("t").Lock(k)
+	if err != nil {
+		t.Fatalf("Lock failed %v", err)
+	}
+	if s.Table("t").Lock(k) == nil {
+		t.Errorf("Could lock a locked item")
+	}
+	if s.Table("t").Delete(k) == nil {
+		t.Errorf("Could delete a locked item")
+	}
+	v, err := s.Table("t").Update(k, func(v interface{}) error {
+		v.(*TaskObj).Status = "done"
+		return nil
+	}, true)
+	if err != nil || v.(*TaskObj).Status != "done" {
+		t.Errorf("Update with unlock failed %v", err)
+	}
+	if s.Table("t").Delete(k) != nil {
+		t.Errorf("Couldn't delete after the update unlocked the item")
+	}
+}
+
+func conformDrop(t *testing.T, s *sett.Sett) {
+	for i := 0; i < 10; i++ {
+		s.Table("t").SetStr(faker.RandomString(8), "v")
+	}
+	s.Table("other").SetStr("k", "v")
+	err := s.Table("t").Drop()
+	if err != nil {
+		t.Fatalf("Drop failed %v", err)
+	}
+	keys, _ := s.Table("t").Keys()
+	if len(keys) != 0 {
+		t.Errorf("Keys left after drop %v", keys)
+	}
+	if !s.Table("other").HasKey("k") {
+		t.Errorf("Drop removed keys of another table")
+	}
+}
+
+func conformCutAndFilter(t *testing.T, s *sett.Sett) {
+	gob.Register(&Item{})
+	s.Table("items").Insert(&Item{Color: "red", Name: "r"})
+	k, _ := s.Table("items").Insert(&Item{Color: "green", Name: "g"})
+	keys, err := s.Table("items").Filter(func(k string, v interface{}) bool {
+		return v.(*Item).Color == "green"
+	})
+	if err != nil || len(keys) != 1 || keys[0] != k {
+		t.Fatalf("Filter returned %v %v", keys, err)
+	}
+	v, err := s.Table("items").Cut(k)
+	if err != nil || v.(*Item).Name != "g" {
+		t.Fatalf("Cut failed %v", err)
+	}
+	if s.Table("items").HasKey(k) {
+		t.Errorf("Item exists after Cut")
+	}
+}
+
+func conformEncryption(t *testing.T, s *sett.Sett) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	writeKeyFile(t, keyFile, "k1 "+testKey1+"\n")
+	kp, err := sett.NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatalf("Couldn't load keys %v", err)
+	}
+	s.Table("pii").WithEncryption(kp).SetStr("k", "secret")
+	v, err := s.Table("pii").GetStr("k")
+	if err != nil || v != "secret" {
+		t.Errorf("Decrypted value does not match %v", err)
+	}
+}
+
+func conformExportImport(t *testing.T, s *sett.Sett) {
+	s.Table("t").SetStr("k1", "v1")
+	s.Table("t").WithTTL(time.Hour).SetStr("k2", "v2")
+	var buf bytes.Buffer
+	n, err := s.ExportTable(&buf, "t")
+	if err != nil || n != 2 {
+		t.Fatalf("Export failed %d %v", n, err)
+	}
+	s.Table("t").Drop()
+	n, err = s.ImportTable(&buf, sett.ImportFail)
+	if err != nil || n != 2 {
+		t.Fatalf("Import failed %d %v", n, err)
+	}
+	v, _ := s.Table("t").GetStr("k2")
+	if v != "v2" {
+		t.Errorf("Imported value does not match")
+	}
+}
+
+func TestBackupNotSupported(t *testing.T) {
+	s := setttest.NewBolt(t)
+	_, err := s.Backup(&bytes.Buffer{}, 0)
+	if !errors.Is(err, sett.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+package sett
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackupInfo describes a backup written by Backup
+type BackupInfo struct {
+	Table string
+	// Since is the version the backup was taken from. 0 for full backups
+	Since uint64
+	// Next is the version to pass as since for the next incremental backup
+	Next uint64
+	// Checksum is the hex encoded SHA-256 of the backup
+	Checksum string
+	Size     int64
+}
+
+// ErrChecksumMismatch is returned when a backup doesn't match its checksum
+var ErrChecksumMismatch = errors.New("backup checksum mismatch")
+
+// Backup writes a consistent snapshot of the table, or of the whole store when
+// called without a table, to w. Pass since = 0 for a full backup, or the Next
+// version of a previous backup to write only the changes made after it.
+// The backup of a table has the data Sett keeps for it along with its
+// values, like its indexes, history and audit trail
+func (s *Sett) Backup(w io.Writer, since uint64) (*BackupInfo, error) {
+	return s.BackupCtx(context.Background(), w, since)
+}
+
+// BackupCtx is Backup with a context. The backup fails once the context is done
+func (s *Sett) BackupCtx(ctx context.Context, w io.Writer, since uint64) (info *BackupInfo, err error) {
+	err = s.do(ctx, OpBackup, "", nil, func(c *opCall) error {
+		var err error
+		info, err = s.backup(c, w, since)
+		return err
+	})
+	return info, err
+}
+
+func (s *Sett) backup(c *opCall, w io.Writer, since uint64) (*BackupInfo, error) {
+	db, err := s.badger()
+	if err != nil {
+		return nil, err
+	}
+	stream := db.NewStream()
+	stream.LogPrefix = "Sett.Backup"
+	// The iterators of the stream skip the versions <= SinceTs, while
+	// since is documented as included, as in badger's own Backup
+	if since > 0 {
+		stream.SinceTs = since - 1
+	}
+	if len(s.table) > 0 {
+		stream.ChooseKey = func(item *badger.Item) bool {
+			return s.ownsKey(item.Key())
+		}
+	}
+	h := sha256.New()
+	cw := &countWriter{w: &opWriter{w: io.MultiWriter(w, h), c: c}}
+	version, err := stream.Backup(cw, since)
+	if err != nil {
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+	// The versions from since are written, since included, so the
+	// next backup starts after the version of the last entry.
+	// version is 0 when there was nothing to write
+	next := since
+	if version > 0 && version+1 > since {
+		next = version + 1
+	}
+	info := &BackupInfo{
+		Table:    s.table,
+		Since:    since,
+		Next:     next,
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+		Size:     cw.n,
+	}
+	return info, nil
+}
+
+// tableKinds are the system keys of a table which are
+// followed by the length prefixed table
+var tableKinds = [][]byte{
+	auditByTime, auditByKey, historyIndex, rollupProgressPrefix,
+	fieldIndexPrefix, fieldIndexMarker, geoIndexPrefix, geoIndexMarker,
+	textPostingsPrefix, textLengthsPrefix, textStatsPrefix, textIndexMarker,
+	vectorPrefix, vectorMarker, hnswNodePrefix, hnswEntryPrefix,
+}
+
+// ownsKey tells whether the key is one of the table, or one of its system keys
+func (s *Sett) ownsKey(key []byte) bool {
+	prefix := []byte(s.makeKey(""))
+	if !isSystemKey(key) {
+		return bytes.HasPrefix(key, prefix)
+	}
+	rest := key[len(systemPrefix):]
+	if bytes.HasPrefix(rest, expiryIndex) {
+		// the deadline, then the key
+		return len(rest) >= len(expiryIndex)+8 && bytes.HasPrefix(rest[len(expiryIndex)+8:], prefix)
+	}
+	for _, kind := range tableKinds {
+		if bytes.HasPrefix(rest, kind) {
+			return bytes.HasPrefix(rest[len(kind):], lengthPrefixed([]byte(s.table)))
+		}
+	}
+	return false
+}
+
+// Restore loads a backup written by Backup. Incremental backups are to be
+// restored in the order they were taken, after the full backup.
+// The items keep the versions they had in the backup, so restore into
+// an empty store: newer changes of the same keys would hide the restored
+// values. No other writes should be made to the store while restoring
+func (s *Sett) Restore(r io.Reader) error {
+	return s.RestoreCtx(context.Background(), r)
+}
+
+// RestoreCtx is Restore with a context. The restore fails once the
+// context is done, leaving the store partly restored
+func (s *Sett) RestoreCtx(ctx context.Context, r io.Reader) error {
+	return s.do(ctx, OpRestore, "", nil, func(c *opCall) error {
+		db, err := s.badger()
+		if err != nil {
+			return err
+		}
+		err = db.Load(&opReader{r: r, c: c}, 256)
+		if err != nil {
+			return fmt.Errorf("restore failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// VerifyBackup reads the backup and compares it with the checksum
+func VerifyBackup(r io.Reader, checksum string) error {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// BackupFile writes the backup to path along with a path.sha256 checksum file
+func (s *Sett) BackupFile(path string, since uint64) (*BackupInfo, error) {
+	return s.BackupFileCtx(context.Background(), path, since)
+}
+
+// BackupFileCtx is BackupFile with a context
+func (s *Sett) BackupFileCtx(ctx context.Context, path string, since uint64) (*BackupInfo, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(f)
+	info, err := s.BackupCtx(ctx, bw, since)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	sum := fmt.Sprintf("%s  %s\n", info.Checksum, filepath.Base(path))
+	err = os.WriteFile(path+".sha256", []byte(sum), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// RestoreFile verifies the backup at path against its checksum
+// file and then restores it
+func (s *Sett) RestoreFile(path string) error {
+	return s.RestoreFileCtx(context.Background(), path)
+}
+
+// RestoreFileCtx is RestoreFile with a context
+func (s *Sett) RestoreFileCtx(ctx context.Context, path string) error {
+	sum, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(sum))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum file for %s", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = VerifyBackup(bufio.NewReader(f), fields[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return s.RestoreCtx(ctx, bufio.NewReader(f))
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// opWriter fails the writes once the context of the operation is done,
+// which stops the backup stream
+type opWriter struct {
+	w io.Writer
+	c *opCall
+}
+
+func (ow *opWriter) Write(p []byte) (int, error) {
+	if err := ow.c.canceled(); err != nil {
+		return 0, err
+	}
+	return ow.w.Write(p)
+}
+
+// opReader fails the reads once the context of the operation is done
+type opReader struct {
+	r io.Reader
+	c *opCall
+}
+
+func (or *opReader) Read(p []byte) (int, error) {
+	if err := or.c.canceled(); err != nil {
+		return 0, err
+	}
+	return or.r.Read(p)
+}
+
+// BackupSchedule configures ScheduleBackups
+type BackupSchedule struct {
+	// Dir is the directory the backups are written to
+	Dir string
+	// Interval between the backups
+	Interval time.Duration
+	// Keep is the number of most recent backups to keep. 0 keeps all
+	Keep int
+	// OnError is called when a scheduled backup fails
+	OnError func(err error)
+}
+
+// BackupScheduler takes full backups periodically. Stop it before closing the store
+type BackupScheduler struct {
+	s      *Sett
+	opts   BackupSchedule
+	mu     sync.Mutex
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+const (
+	backupExt        = ".bak"
+	backupTimeLayout = "20060102T150405.000000000Z"
+)
+
+// ScheduleBackups starts taking a full backup of the table (or the whole store)
+// into opts.Dir every opts.Interval, removing the oldest backups beyond opts.Keep
+func (s *Sett) ScheduleBackups(opts BackupSchedule) (*BackupScheduler, error) {
+	if opts.Interval <= 0 {
+		return nil, errors.New("backup interval is required")
+	}
+	err := os.MkdirAll(opts.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	bs := &BackupScheduler{s: s, opts: opts, closed: make(chan struct{})}
+	bs.wg.Add(1)
+	go bs.run()
+	return bs, nil
+}
+
+func (bs *BackupScheduler) run() {
+	defer bs.wg.Done()
+	ticker := time.NewTicker(bs.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := bs.BackupNow()
+			if err != nil && bs.opts.OnError != nil {
+				bs.opts.OnError(err)
+			}
+		case <-bs.closed:
+			return
+		}
+	}
+}
+
+// BackupNow takes a backup immediately and rotates the old backups.
+// Returns the path of the new backup
+func (bs *BackupScheduler) BackupNow() (string, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	name := fmt.Sprintf("%s%s%s", bs.namePrefix(), time.Now().UTC().Format(backupTimeLayout), backupExt)
+	path := filepath.Join(bs.opts.Dir, name)
+	_, err := bs.s.BackupFile(path, 0)
+	if err != nil {
+		return "", err
+	}
+	return path, bs.rotate()
+}
+
+// namePrefix is the start of the names of the backups of the scheduler:
+// the table, escaped to be a single file name, or backup for the whole store
+func (bs *BackupScheduler) namePrefix() string {
+	if len(bs.s.table) == 0 {
+		return "backup-"
+	}
+	return url.PathEscape(bs.s.table) + "-"
+}
+
+// Backups returns the paths of the backups of the scheduler's table
+// in the directory, oldest first. The backups of other tables
+// written to the same directory are left out
+func (bs *BackupScheduler) Backups() ([]string, error) {
+	entries, err := os.ReadDir(bs.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := bs.namePrefix()
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		// the rest must be the timestamp alone, as the
+		// prefix of the table a matches the backups of a-b
+		_, err := time.Parse(backupTimeLayout, strings.TrimSuffix(name[len(prefix):], backupExt))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(bs.opts.Dir, name))
+	}
+	// The names end with the timestamp, so they sort by time
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (bs *BackupScheduler) rotate() error {
+	if bs.opts.Keep <= 0 {
+		return nil
+	}
+	backups, err := bs.Backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > bs.opts.Keep {
+		old := backups[0]
+		backups = backups[1:]
+		err = os.Remove(old)
+		if err != nil {
+			return err
+		}
+		os.Remove(old + ".sha256")
+	}
+	return nil
+}
+
+// Stop stops the scheduler, waiting for a running backup to complete
+func (bs *BackupScheduler) Stop() {
+	bs.mu.Lock()
+	select {
+	case <-bs.closed:
+	default:
+		close(bs.closed)
+	}
+	bs.mu.Unlock()
+	bs.wg.Wait()
+}
@@ -0,0 +1,173 @@
+package sett_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"syreclabs.com/go/faker"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	s := setttest.New(t)
+
+	table := faker.RandomString(8)
+	s.SetStr("outside", "x")
+	s.Table(table).SetStr("k1", "v1")
+
+	var full bytes.Buffer
+	info, err := s.Table(table).Backup(&full, 0)
+	if err != nil {
+		t.Fatalf("Backup failed %v", err)
+	}
+	err = sett.VerifyBackup(bytes.NewReader(full.Bytes()), info.Checksum)
+	if err != nil {
+		t.Errorf("Backup doesn't match its checksum %v", err)
+	}
+	err = sett.VerifyBackup(bytes.NewReader(full.Bytes()[1:]), info.Checksum)
+	if !errors.Is(err, sett.ErrChecksumMismatch) {
+		t.Errorf("Expected checksum mismatch, got %v", err)
+	}
+
+	// since is included, so Next starts after the last entry
+	var empty bytes.Buffer
+	noop, err := s.Table(table).Backup(&empty, info.Next)
+	if err != nil || noop.Size != 0 || noop.Next != info.Next {
+		t.Errorf("Expected an empty incremental backup, got %+v %v", noop, err)
+	}
+
+	s.Table(table).SetStr("k2", "v2")
+	var incr bytes.Buffer
+	_, err = s.Table(table).Backup(&incr, info.Next)
+	if err != nil {
+		t.Fatalf("Incremental backup failed %v", err)
+	}
+
+	r := setttest.New(t)
+
+	err = r.Restore(&full)
+	if err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	err = r.Restore(&incr)
+	if err != nil {
+		t.Fatalf("Restore of incremental backup failed %v", err)
+	}
+	for k, v := range map[string]string{"k1": "v1", "k2": "v2"} {
+		vr, err := r.Table(table).GetStr(k)
+		if err != nil || vr != v {
+			t.Errorf("Restored value of %s does not match %v", k, err)
+		}
+	}
+	if r.HasKey("outside") {
+		t.Errorf("Key outside the table was included in the table backup")
+	}
+}
+
+func TestScheduledBackups(t *testing.T) {
+	s := setttest.New(t)
+
+	s.SetStr("k", "v")
+	bs, err := s.ScheduleBackups(sett.BackupSchedule{Dir: t.TempDir(), Interval: time.Hour, Keep: 2})
+	if err != nil {
+		t.Fatalf("Couldn't schedule backups %v", err)
+	}
+	defer bs.Stop()
+
+	var last string
+	for i := 0; i < 3; i++ {
+		last, err = bs.BackupNow()
+		if err != nil {
+			t.Fatalf("Backup failed %v", err)
+		}
+	}
+	backups, err := bs.Backups()
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("Expected 2 backups to be kept, got %v %v", backups, err)
+	}
+	if backups[1] != last {
+		t.Errorf("The latest backup was not kept")
+	}
+
+	r := setttest.New(t)
+	err = r.RestoreFile(last)
+	if err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	v, err := r.GetStr("k")
+	if err != nil || v != "v" {
+		t.Errorf("Restored value does not match %v", err)
+	}
+}
+
+func TestScheduledBackupsSharingDir(t *testing.T) {
+	s := setttest.New(t)
+	dir := t.TempDir()
+	var schedulers []*sett.BackupScheduler
+	for _, table := range []string{"", "a", "a-b", "x/y"} {
+		s.Table(table).SetStr("k", "v")
+		bs, err := s.Table(table).ScheduleBackups(sett.BackupSchedule{Dir: dir, Interval: time.Hour, Keep: 2})
+		if err != nil {
+			t.Fatalf("Couldn't schedule backups %v", err)
+		}
+		defer bs.Stop()
+		schedulers = append(schedulers, bs)
+	}
+	for i := 0; i < 3; i++ {
+		for _, bs := range schedulers {
+			_, err := bs.BackupNow()
+			if err != nil {
+				t.Fatalf("Backup failed %v", err)
+			}
+		}
+	}
+	for i, bs := range schedulers {
+		backups, err := bs.Backups()
+		if err != nil || len(backups) != 2 {
+			t.Errorf("Expected 2 backups kept by scheduler %d, got %v %v", i, backups, err)
+		}
+	}
+}
+
+func TestBackupTableSystemKeys(t *testing.T) {
+	gob.Register(&OrderObj{})
+	s := setttest.New(t)
+	for _, name := range []string{"orders", "other"} {
+		tbl := s.Table(name).WithHistory(sett.HistoryOptions{})
+		err := tbl.CreateIndex("Status")
+		if err != nil {
+			t.Fatalf("CreateIndex failed %v", err)
+		}
+		tbl.SetStruct("o1", &OrderObj{Status: "new"})
+		tbl.SetStruct("o1", &OrderObj{Status: "open"})
+	}
+	var buf bytes.Buffer
+	_, err := s.Table("orders").Backup(&buf, 0)
+	if err != nil {
+		t.Fatalf("Backup failed %v", err)
+	}
+
+	// restored where the index is already created
+	r := setttest.New(t)
+	orders := r.Table("orders").WithHistory(sett.HistoryOptions{})
+	orders.CreateIndex("Status")
+	err = r.Restore(&buf)
+	if err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	found, err := orders.Query().Where("Status", "==", "open").Run()
+	if err != nil || len(found) != 1 || found[0].Key != "o1" {
+		t.Errorf("Expected the index in the backup, got %+v %v", found, err)
+	}
+	history, err := orders.History("o1", 0)
+	if err != nil || len(history) != 2 {
+		t.Errorf("Expected the history in the backup, got %+v %v", history, err)
+	}
+	history, err = r.Table("other").WithHistory(sett.HistoryOptions{}).History("o1", 0)
+	if err != nil || len(history) != 0 {
+		t.Errorf("Expected only the history of the table, got %+v %v", history, err)
+	}
+}
@@ -0,0 +1,96 @@
+package sett
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+)
+
+type badgerBackend struct {
+	db *badger.DB
+}
+
+// NewBadgerBackend returns the Backend running on the badger database
+func NewBadgerBackend(db *badger.DB) Backend {
+	return &badgerBackend{db: db}
+}
+
+func (b *badgerBackend) View(fn func(txn Txn) error) error {
+	return b.db.View(func(txn *badger.Txn) error {
+		return fn(&badgerTxn{txn: txn})
+	})
+}
+
+func (b *badgerBackend) Update(fn func(txn Txn) error) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return fn(&badgerTxn{txn: txn})
+	})
+}
+
+func (b *badgerBackend) Close() error {
+	return b.db.Close()
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t *badgerTxn) Get(key []byte) (Item, error) {
+	item, err := t.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (t *badgerTxn) Set(e *Entry) error {
+	be := badger.NewEntry(e.Key, e.Value).WithMeta(e.UserMeta)
+	be.ExpiresAt = e.ExpiresAt
+	return t.txn.SetEntry(be)
+}
+
+func (t *badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t *badgerTxn) NewIterator(opts IteratorOptions) Iterator {
+	bo := DefaultIteratorOptions
+	bo.Prefix = opts.Prefix
+	bo.PrefetchValues = !opts.KeysOnly
+	bo.Reverse = opts.Reverse
+	return &badgerIterator{it: t.txn.NewIterator(bo), prefix: opts.Prefix}
+}
+
+type badgerIterator struct {
+	it     *badger.Iterator
+	prefix []byte
+}
+
+func (i *badgerIterator) Seek(key []byte) {
+	i.it.Seek(key)
+}
+
+func (i *badgerIterator) Valid() bool {
+	return i.it.ValidForPrefix(i.prefix)
+}
+
+func (i *badgerIterator) Next() {
+	i.it.Next()
+}
+
+func (i *badgerIterator) Item() Item {
+	return i.it.Item()
+}
+
+func (i *badgerIterator) Close() {
+	i.it.Close()
+}
+
+// badger returns the badger database of the store, for
+// the operations that are specific to badger
+func (s *Sett) badger() (*badger.DB, error) {
+	b, ok := s.db.(*badgerBackend)
+	if !ok {
+		return nil, fmt.Errorf("%w: requires badger", ErrNotSupported)
+	}
+	return b.db, nil
+}
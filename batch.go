@@ -0,0 +1,275 @@
+package sett
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	"time"
+)
+
+// BatchError is the error of an item of a batch
+type BatchError struct {
+	Key string
+	Err error
+}
+
+func (e BatchError) Error() string {
+	return fmt.Sprintf("batch write of %s failed: %v", e.Key, e.Err)
+}
+
+func (e BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchStats tells what a batch wrote
+type BatchStats struct {
+	Written int
+	Deleted int
+	// Errors are the items which couldn't be written
+	Errors   []BatchError
+	Duration time.Duration
+}
+
+// Rate returns the number of items written or deleted per second
+func (st BatchStats) Rate() float64 {
+	if st.Duration <= 0 {
+		return 0
+	}
+	return float64(st.Written+st.Deleted) / st.Duration.Seconds()
+}
+
+// Batch writes many items to a table without a transaction for each.
+// The items are committed in transactions split automatically to fit
+// the size limits of the backend, so a failure of Flush can leave part
+// of the batch written. Writes of a batch are blind: they don't check
+// the lock bit of the items they replace. The middleware of the store
+// runs for each item as it is added, so it can refuse or change the
+// item, but the item is only written once its part of the batch is
+// committed. A Batch is not safe for concurrent use
+//
+//	b := s.Table("users").Batch()
+//	for _, u := range users {
+//		b.Set(u.ID, u)
+//	}
+//	stats, err := b.Flush()
+type Batch struct {
+	s     *Sett
+	ctx   context.Context
+	start time.Time
+	stats BatchStats
+
+	// wb is badger's WriteBatch, used when the table keeps no expiry
+	// index, audit trail, history or value index, which would need
+	// reading the replaced items
+	wb *badger.WriteBatch
+	// queued counts the items added to wb, which are
+	// only known to be written once Flush succeeds
+	queued BatchStats
+	// pending are the writes not committed yet, when wb is nil
+	pending []batchOp
+}
+
+type batchOp struct {
+	// ctx is the context of the item, as passed on by the middleware
+	ctx    context.Context
+	key    string
+	val    []byte
+	vtype  byte
+	delete bool
+}
+
+const batchCommitSize = 1000
+
+// Batch returns a writer to load many items in the table
+func (s *Sett) Batch() *Batch {
+	return s.BatchCtx(context.Background())
+}
+
+// BatchCtx is Batch with a context. The context is passed on to the audit
+// trail, and the batch stops writing once it is done
+func (s *Sett) BatchCtx(ctx context.Context) *Batch {
+	b := &Batch{s: s, ctx: ctx, start: time.Now()}
+	o := s.options()
+	if db, err := s.badger(); err == nil && o.expiry == nil && o.audit == nil && o.history == nil && len(o.indexes) == 0 {
+		b.wb = db.NewWriteBatch()
+	}
+	return b
+}
+
+// Set adds the string or struct value to the batch. The value is
+// encoded right away, an error here is also reported by Flush
+func (b *Batch) Set(key string, val interface{}) error {
+	err := b.s.do(b.ctx, OpSet, key, val, func(c *opCall) error {
+		if str, ok := c.value.(string); ok {
+			return b.add(batchOp{ctx: c.ctx, key: c.key, val: []byte(str), vtype: STRING_TYPE})
+		}
+		data, err := encodeStruct(c.value)
+		if err != nil {
+			return err
+		}
+		return b.add(batchOp{ctx: c.ctx, key: c.key, val: data, vtype: STRUCT_TYPE})
+	})
+	return b.fail(key, err)
+}
+
+// Insert adds the value to the batch with a generated key
+func (b *Batch) Insert(val interface{}) (string, error) {
+	key, err := b.s.newKey()
+	if err != nil {
+		return "", err
+	}
+	return key, b.Set(key, val)
+}
+
+// Delete adds the removal of the key to the batch
+func (b *Batch) Delete(key string) error {
+	err := b.s.do(b.ctx, OpDelete, key, nil, func(c *opCall) error {
+		return b.add(batchOp{ctx: c.ctx, key: c.key, delete: true})
+	})
+	return b.fail(key, err)
+}
+
+func (b *Batch) fail(key string, err error) error {
+	if err != nil {
+		b.stats.Errors = append(b.stats.Errors, BatchError{Key: key, Err: err})
+	}
+	return err
+}
+
+func (b *Batch) add(op batchOp) error {
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+	if b.wb == nil {
+		b.pending = append(b.pending, op)
+		if len(b.pending) >= batchCommitSize {
+			b.commit()
+		}
+		return nil
+	}
+	k := []byte(b.s.makeKey(op.key))
+	b.s.invalidate(k)
+	if op.delete {
+		err := b.wb.Delete(k)
+		if err == nil {
+			b.queued.Deleted++
+		}
+		return err
+	}
+	val, vtype, err := b.s.sealedValue(k, op.val, op.vtype)
+	if err != nil {
+		return err
+	}
+	e := badger.NewEntry(k, val).WithMeta(vtype)
+	if b.s.ttl > 0 {
+		e.ExpiresAt = uint64(time.Now().Add(b.s.ttl).Unix())
+	}
+	err = b.wb.SetEntry(e)
+	if err == nil {
+		b.queued.Written++
+	}
+	return err
+}
+
+// commit writes the pending items. The items after a transaction
+// which fails are retried one by one, to tell the failing items
+// from the others
+func (b *Batch) commit() error {
+	ops := b.pending
+	b.pending = nil
+	if err := b.ctx.Err(); err != nil {
+		for _, op := range ops {
+			b.fail(op.key, err)
+		}
+		return err
+	}
+	done, err := b.commitOps(ops)
+	b.count(ops[:done])
+	if err == nil {
+		return nil
+	}
+	for _, op := range ops[done:] {
+		_, err := b.commitOps([]batchOp{op})
+		if b.fail(op.key, err) == nil {
+			b.count([]batchOp{op})
+		}
+	}
+	return nil
+}
+
+func (b *Batch) count(ops []batchOp) {
+	for _, op := range ops {
+		if op.delete {
+			b.stats.Deleted++
+		} else {
+			b.stats.Written++
+		}
+	}
+}
+
+// commitOps writes the items, in as many transactions as needed.
+// Returns the number of items committed before an error
+func (b *Batch) commitOps(ops []batchOp) (int, error) {
+	if len(ops) == 0 {
+		return 0, nil
+	}
+	err := b.s.db.Update(func(txn Txn) error {
+		for _, op := range ops {
+			si := NewSettItem(b.s, txn, op.key)
+			if op.delete {
+				si.call = &opCall{ctx: op.ctx, op: OpDelete, key: op.key}
+				err := b.s.deleteEntry(si.call, txn, []byte(si.fullKey))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			si.call = &opCall{ctx: op.ctx, op: OpSet, key: op.key}
+			err := si.writeValue(op.val, op.vtype)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrTxnTooBig) && len(ops) > 1 {
+		h := len(ops) / 2
+		done, err := b.commitOps(ops[:h])
+		if err != nil {
+			return done, err
+		}
+		done, err = b.commitOps(ops[h:])
+		return h + done, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(ops), nil
+}
+
+// Flush commits the items added to the batch and returns what was written.
+// The error is that of the batch as a whole, the errors of single
+// items are in BatchStats.Errors. The batch can't be used after Flush
+func (b *Batch) Flush() (BatchStats, error) {
+	var err error
+	if b.wb != nil {
+		err = b.wb.Flush()
+		if err == nil {
+			b.stats.Written += b.queued.Written
+			b.stats.Deleted += b.queued.Deleted
+		}
+	} else {
+		err = b.commit()
+	}
+	b.stats.Duration = time.Since(b.start)
+	return b.stats, err
+}
+
+// Cancel discards the items not committed yet
+func (b *Batch) Cancel() {
+	if b.wb != nil {
+		b.wb.Cancel()
+	}
+	b.pending = nil
+}
@@ -0,0 +1,155 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			testBatch(t, s.Table("bulk").WithTTL(time.Hour))
+			testBatch(t, s.Table("audited").WithAudit(sett.AuditOptions{}))
+			recs, _ := s.Table("audited").AuditByKey("t5", 0)
+			if len(recs) != 1 || recs[0].Op != sett.OpSet {
+				t.Errorf("Expected the batch write audited, got %+v", recs)
+			}
+		})
+	}
+}
+
+func testBatch(t *testing.T, tbl *sett.Sett) {
+	gob.Register(&TaskObj{})
+	tbl.SetStr("gone", "x")
+
+	b := tbl.Batch()
+	for i := 0; i < 2500; i++ {
+		b.Set(fmt.Sprintf("t%d", i), &TaskObj{ID: uint64(i), Status: "loaded"})
+	}
+	b.Set("s1", "plain")
+	key, err := b.Insert(&TaskObj{ID: 99999})
+	if err != nil {
+		t.Fatalf("Insert failed %v", err)
+	}
+	err = b.Set("bad", make(chan int))
+	if err == nil {
+		t.Errorf("Expected an error encoding a channel")
+	}
+	b.Delete("gone")
+	stats, err := b.Flush()
+	if err != nil {
+		t.Fatalf("Flush failed %v", err)
+	}
+	if stats.Written != 2502 || stats.Deleted != 1 || len(stats.Errors) != 1 || stats.Errors[0].Key != "bad" {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.Rate() <= 0 {
+		t.Errorf("Expected a rate, got %v", stats.Rate())
+	}
+
+	v, err := tbl.GetStruct("t2499")
+	if err != nil || v.(*TaskObj).ID != 2499 {
+		t.Errorf("Batch item not written %v %v", v, err)
+	}
+	v, err = tbl.GetStruct(key)
+	if err != nil || v.(*TaskObj).ID != 99999 {
+		t.Errorf("Inserted item not written %v %v", v, err)
+	}
+	str, err := tbl.GetStr("s1")
+	if err != nil || str != "plain" {
+		t.Errorf("String item not written %v %v", str, err)
+	}
+	if tbl.HasKey("gone") {
+		t.Errorf("Deleted item is still there")
+	}
+	_, err = tbl.GetStruct("bad")
+	if err == nil {
+		t.Errorf("Failed item was written")
+	}
+}
+
+func TestBatchIndexed(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			gob.Register(&TaskObj{})
+			tbl := s.Table("indexed")
+			tbl.SetStr("old", "goodbye world")
+			err := tbl.CreateTextIndex(sett.TextIndexOptions{})
+			if err != nil {
+				t.Fatalf("CreateTextIndex failed %v", err)
+			}
+			err = tbl.CreateIndex("Status")
+			if err != nil {
+				t.Fatalf("CreateIndex failed %v", err)
+			}
+			batch := tbl.Batch()
+			batch.Set("a", "hello world")
+			batch.Set("t1", &TaskObj{ID: 1, Status: "loaded"})
+			batch.Delete("old")
+			_, err = batch.Flush()
+			if err != nil {
+				t.Fatalf("Flush failed %v", err)
+			}
+			results, err := tbl.Search("hello", 0)
+			if err != nil || len(results) != 1 || results[0].Key != "a" {
+				t.Errorf("Expected the batch write in the text index, got %+v %v", results, err)
+			}
+			results, _ = tbl.Search("goodbye", 0)
+			if len(results) != 0 {
+				t.Errorf("Expected the batch delete out of the text index, got %+v", results)
+			}
+			found, err := tbl.Query().Where("Status", "=", "loaded").Run()
+			if err != nil || len(found) != 1 || found[0].Key != "t1" {
+				t.Errorf("Expected the batch write in the field index, got %+v %v", found, err)
+			}
+		})
+	}
+}
+
+func TestBatchMiddleware(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			errReadOnly := errors.New("read only key")
+			s.Use(func(op sett.Op, next sett.Handler) error {
+				if strings.HasPrefix(op.Key, "ro_") {
+					return errReadOnly
+				}
+				if v, ok := op.Value.(string); ok {
+					op.Value = strings.ToUpper(v)
+				}
+				return next(op)
+			})
+			tbl := s.Table("bulk")
+			tbl.SetStr("gone", "x")
+			batch := tbl.Batch()
+			batch.Set("a", "value")
+			err := batch.Set("ro_b", "value")
+			if !errors.Is(err, errReadOnly) {
+				t.Errorf("Expected the middleware to refuse the item, got %v", err)
+			}
+			batch.Delete("gone")
+			stats, err := batch.Flush()
+			if err != nil || stats.Written != 1 || stats.Deleted != 1 || len(stats.Errors) != 1 {
+				t.Fatalf("Unexpected stats %+v %v", stats, err)
+			}
+			v, _ := tbl.GetStr("a")
+			if v != "VALUE" {
+				t.Errorf("Expected the value changed by the middleware, got %q", v)
+			}
+			if tbl.HasKey("ro_b") || tbl.HasKey("gone") {
+				t.Errorf("Unexpected keys after the batch")
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package sett
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// All keys are kept in one bucket. Each value is prefixed by a header of
+// user meta (1 byte) | expires at (8 bytes) | version (8 bytes)
+var boltBucket = []byte("sett")
+
+const boltHeaderLength = 17
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+// OpenBolt creates or opens a store in the bbolt database file at path.
+// bbolt allows only one writer at a time, so concurrent updates
+// are serialized instead of failing with ErrConflict
+func OpenBolt(path string, opts *bolt.Options, options ...OpenOption) (*Sett, error) {
+	if opts == nil {
+		opts = &bolt.Options{Timeout: 1 * time.Second}
+	}
+	db, err := bolt.Open(path, 0600, opts)
+	if err != nil {
+		return nil, fmt.Errorf("create or open db failed: %w", err)
+	}
+	b, err := NewBoltBackend(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return OpenBackend(b, options...), nil
+}
+
+// NewBoltBackend returns the Backend running on the bbolt database
+func NewBoltBackend(db *bolt.DB) (Backend, error) {
+	if !db.IsReadOnly() {
+		err := db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltBucket)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &boltBackend{db: db}, nil
+}
+
+func (b *boltBackend) View(fn func(txn Txn) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(newBoltTxn(tx))
+	})
+}
+
+func (b *boltBackend) Update(fn func(txn Txn) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(newBoltTxn(tx))
+	})
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
+
+const boltPurgeBatchSize = 1000
+
+// purgeExpired removes the expired keys, which bolt would keep forever,
+// with fn given the item of each of them. Returns the number of keys removed
+func (b *boltBackend) purgeExpired(fn func(txn Txn, key []byte, item Item) error) (int, error) {
+	var count int
+	var from []byte
+	for {
+		var expired [][]byte
+		err := b.db.View(func(tx *bolt.Tx) error {
+			now := uint64(time.Now().Unix())
+			c := tx.Bucket(boltBucket).Cursor()
+			k, v := c.First()
+			if from != nil {
+				k, v = c.Seek(from)
+			}
+			for ; k != nil; k, v = c.Next() {
+				if len(expired) >= boltPurgeBatchSize {
+					from = append([]byte{}, k...)
+					return nil
+				}
+				bi, err := decodeBoltItem(k, v)
+				if err != nil {
+					return err
+				}
+				if bi.expired(now) {
+					expired = append(expired, append([]byte{}, k...))
+				}
+			}
+			from = nil
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		err = b.db.Update(func(tx *bolt.Tx) error {
+			txn := newBoltTxn(tx)
+			for _, k := range expired {
+				raw := txn.bkt.Get(k)
+				if raw == nil {
+					continue
+				}
+				bi, err := decodeBoltItem(k, raw)
+				if err != nil {
+					return err
+				}
+				if !bi.expired(txn.now) {
+					// written again since
+					continue
+				}
+				err = fn(txn, k, bi)
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		if from == nil {
+			return count, nil
+		}
+	}
+}
+
+type boltTxn struct {
+	tx  *bolt.Tx
+	bkt *bolt.Bucket
+	now uint64
+}
+
+func newBoltTxn(tx *bolt.Tx) *boltTxn {
+	return &boltTxn{tx: tx, bkt: tx.Bucket(boltBucket), now: uint64(time.Now().Unix())}
+}
+
+func (t *boltTxn) Get(key []byte) (Item, error) {
+	if t.bkt == nil {
+		return nil, ErrKeyNotFound
+	}
+	raw := t.bkt.Get(key)
+	if raw == nil {
+		return nil, ErrKeyNotFound
+	}
+	item, err := decodeBoltItem(key, raw)
+	if err != nil {
+		return nil, err
+	}
+	if item.expired(t.now) {
+		return nil, ErrKeyNotFound
+	}
+	return item, nil
+}
+
+func (t *boltTxn) Set(e *Entry) error {
+	if t.bkt == nil {
+		return bolt.ErrTxNotWritable
+	}
+	version, err := t.bkt.NextSequence()
+	if err != nil {
+		return err
+	}
+	raw := make([]byte, boltHeaderLength+len(e.Value))
+	raw[0] = e.UserMeta
+	binary.BigEndian.PutUint64(raw[1:9], e.ExpiresAt)
+	binary.BigEndian.PutUint64(raw[9:17], version)
+	copy(raw[boltHeaderLength:], e.Value)
+	return t.bkt.Put(e.Key, raw)
+}
+
+func (t *boltTxn) Delete(key []byte) error {
+	if t.bkt == nil {
+		return bolt.ErrTxNotWritable
+	}
+	return t.bkt.Delete(key)
+}
+
+func (t *boltTxn) NewIterator(opts IteratorOptions) Iterator {
+	it := &boltIterator{prefix: opts.Prefix, now: t.now, reverse: opts.Reverse}
+	if t.bkt != nil {
+		it.c = t.bkt.Cursor()
+	}
+	return it
+}
+
+type boltIterator struct {
+	c       *bolt.Cursor
+	prefix  []byte
+	now     uint64
+	reverse bool
+	item    *boltItem
+}
+
+func (i *boltIterator) Seek(key []byte) {
+	if i.c == nil {
+		return
+	}
+	k, v := i.c.Seek(key)
+	if i.reverse {
+		// the last key <= key
+		if k == nil {
+			k, v = i.c.Last()
+		} else if !bytes.Equal(k, key) {
+			k, v = i.c.Prev()
+		}
+	}
+	i.settle(k, v)
+}
+
+func (i *boltIterator) Next() {
+	if i.c == nil || i.item == nil {
+		return
+	}
+	i.settle(i.step())
+}
+
+func (i *boltIterator) step() ([]byte, []byte) {
+	if i.reverse {
+		return i.c.Prev()
+	}
+	return i.c.Next()
+}
+
+// settle moves on to the first unexpired key from k
+func (i *boltIterator) settle(k []byte, v []byte) {
+	for ; k != nil; k, v = i.step() {
+		if !bytes.HasPrefix(k, i.prefix) {
+			break
+		}
+		item, err := decodeBoltItem(k, v)
+		if err != nil || item.expired(i.now) {
+			continue
+		}
+		i.item = item
+		return
+	}
+	i.item = nil
+}
+
+func (i *boltIterator) Valid() bool {
+	return i.item != nil
+}
+
+func (i *boltIterator) Item() Item {
+	return i.item
+}
+
+func (i *boltIterator) Close() {
+	i.item = nil
+}
+
+type boltItem struct {
+	key       []byte
+	value     []byte
+	meta      byte
+	expiresAt uint64
+	version   uint64
+}
+
+func decodeBoltItem(key []byte, raw []byte) (*boltItem, error) {
+	if len(raw) < boltHeaderLength {
+		return nil, errors.New("Invalid value in bolt bucket")
+	}
+	return &boltItem{
+		key:       key,
+		value:     raw[boltHeaderLength:],
+		meta:      raw[0],
+		expiresAt: binary.BigEndian.Uint64(raw[1:9]),
+		version:   binary.BigEndian.Uint64(raw[9:17]),
+	}, nil
+}
+
+func (bi *boltItem) expired(now uint64) bool {
+	return bi.expiresAt > 0 && bi.expiresAt <= now
+}
+
+func (bi *boltItem) Key() []byte {
+	return bi.key
+}
+
+func (bi *boltItem) KeyCopy(dst []byte) []byte {
+	return append(dst[:0], bi.key...)
+}
+
+func (bi *boltItem) Value(fn func(val []byte) error) error {
+	return fn(bi.value)
+}
+
+func (bi *boltItem) ValueCopy(dst []byte) ([]byte, error) {
+	return append(dst[:0], bi.value...), nil
+}
+
+func (bi *boltItem) UserMeta() byte {
+	return bi.meta
+}
+
+func (bi *boltItem) ExpiresAt() uint64 {
+	return bi.expiresAt
+}
+
+func (bi *boltItem) Version() uint64 {
+	return bi.version
+}
@@ -0,0 +1,193 @@
+package sett
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheOptions configures the cache of a table
+type CacheOptions struct {
+	// Size is the maximum number of values kept. Defaults to 1000
+	Size int
+	// MaxAge limits how long a value is kept, 0 keeps it until evicted
+	MaxAge time.Duration
+}
+
+// CacheStats are the counters of the cache of a table
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Len is the number of values in the cache
+	Len int
+}
+
+const defaultCacheSize = 1000
+
+// WithCache keeps the decoded values read by Get, GetStruct and GetStr
+// in a LRU cache, saving the decoding (and decryption) of frequently read
+// keys. Every read still checks the version of the key in the store,
+// so a cached value is never stale, even if the key was written by
+// another process. Cached values are invalidated by Set, Update, Delete
+// and Cut, and never outlive the expiry of the key.
+// As with WithEncryption, the setting applies to every handle of the table.
+//
+// The struct returned on a hit is a shallow copy of the cached one.
+// Don't modify the slices, maps or pointers it refers to.
+func (s *Sett) WithCache(opts CacheOptions) *Sett {
+	if opts.Size <= 0 {
+		opts.Size = defaultCacheSize
+	}
+	s.shared.tables.update(s.table, func(o *tableOptions) {
+		o.cache = newValueCache(opts)
+	})
+	return s
+}
+
+// CacheStats returns the counters of the cache of the table.
+// All zero if the table has no cache
+func (s *Sett) CacheStats() CacheStats {
+	c := s.options().cache
+	if c == nil {
+		return CacheStats{}
+	}
+	return c.stats()
+}
+
+type cacheEntry struct {
+	key       string
+	version   uint64
+	expiresAt uint64
+	added     time.Time
+	value     interface{}
+	// size is the size of the plain value
+	size int
+}
+
+type valueCache struct {
+	opts CacheOptions
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newValueCache(opts CacheOptions) *valueCache {
+	return &valueCache{opts: opts, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the cached value of the key and its size if it is of the version
+func (c *valueCache) get(key string, version uint64) (interface{}, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, 0, false
+	}
+	ce := el.Value.(*cacheEntry)
+	if ce.version != version || c.expired(ce) {
+		c.removeElement(el)
+		atomic.AddUint64(&c.misses, 1)
+		return nil, 0, false
+	}
+	c.ll.MoveToFront(el)
+	atomic.AddUint64(&c.hits, 1)
+	return ce.value, ce.size, true
+}
+
+func (c *valueCache) expired(ce *cacheEntry) bool {
+	if ce.expiresAt > 0 && uint64(time.Now().Unix()) >= ce.expiresAt {
+		return true
+	}
+	return c.opts.MaxAge > 0 && time.Since(ce.added) > c.opts.MaxAge
+}
+
+func (c *valueCache) put(key string, item Item, value interface{}, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ce := &cacheEntry{key: key, version: item.Version(), expiresAt: item.ExpiresAt(), added: time.Now(), value: value, size: size}
+	if el, ok := c.items[key]; ok {
+		el.Value = ce
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(ce)
+	for c.ll.Len() > c.opts.Size {
+		c.removeElement(c.ll.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+func (c *valueCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *valueCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
+
+func (c *valueCache) stats() CacheStats {
+	c.mu.Lock()
+	n := c.ll.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Len:       n,
+	}
+}
+
+// shallowCopy copies the struct a pointer refers to, so that setting
+// the fields of a value returned from the cache doesn't change the cache
+func shallowCopy(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return v
+	}
+	cp := reflect.New(rv.Elem().Type())
+	cp.Elem().Set(rv.Elem())
+	return cp.Interface()
+}
+
+// cachedStructValue is GetStructValue going through the cache of the table
+func (si *SettItem) cachedStructValue() (*SettValueItem, error) {
+	c := si.s.options().cache
+	if c == nil {
+		return si.GetStructValue()
+	}
+	item, err := si.txn.Get([]byte(si.fullKey))
+	if err != nil {
+		return nil, err
+	}
+	return si.cachedStruct(c, item)
+}
+
+// cachedStruct returns the struct value of the item through the cache
+func (si *SettItem) cachedStruct(c *valueCache, item Item) (*SettValueItem, error) {
+	if (item.UserMeta() & 0x0F) == STRUCT_TYPE {
+		if v, size, ok := c.get(si.fullKey, item.Version()); ok {
+			si.call.setSize(size)
+			return &SettValueItem{V: shallowCopy(v), Locked: (item.UserMeta() & 0x80) != 0}, nil
+		}
+	}
+	sv, size, err := si.decodeStruct(item)
+	if err != nil {
+		return nil, err
+	}
+	c.put(si.fullKey, item, shallowCopy(sv.V), size)
+	return sv, nil
+}
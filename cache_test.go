@@ -0,0 +1,78 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"github.com/prasanthmj/sett/v2"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testCache(t, b.open(t))
+		})
+	}
+}
+
+func testCache(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	tbl := s.Table("config").WithCache(sett.CacheOptions{Size: 2})
+	tbl.SetStruct("k1", &TaskObj{ID: 1, Status: "new"})
+
+	for i := 0; i < 3; i++ {
+		v, err := s.Table("config").GetStruct("k1")
+		if err != nil || v.(*TaskObj).ID != 1 {
+			t.Fatalf("Get failed %v", err)
+		}
+		// Changes to a returned value don't reach the cache
+		v.(*TaskObj).Status = "changed"
+	}
+	st := tbl.CacheStats()
+	if st.Misses != 1 || st.Hits != 2 || st.Len != 1 {
+		t.Errorf("Unexpected cache stats %+v", st)
+	}
+	v, _ := tbl.Get("k1")
+	if v.(*TaskObj).Status != "new" {
+		t.Errorf("Cached value was modified through a returned value")
+	}
+
+	tbl.SetStruct("k1", &TaskObj{ID: 1, Status: "set"})
+	v, _ = tbl.Get("k1")
+	if v.(*TaskObj).Status != "set" {
+		t.Errorf("Stale value after Set %v", v)
+	}
+	tbl.Update("k1", func(v interface{}) error {
+		v.(*TaskObj).Status = "updated"
+		return nil
+	}, false)
+	v, _ = tbl.Get("k1")
+	if v.(*TaskObj).Status != "updated" {
+		t.Errorf("Stale value after Update %v", v)
+	}
+	tbl.Delete("k1")
+	if tbl.HasKey("k1") {
+		t.Errorf("Deleted key found in cache")
+	}
+
+	tbl.SetStr("s1", "v1")
+	tbl.SetStr("s2", "v2")
+	tbl.SetStr("s3", "v3", sett.ExpireIn(time.Second))
+	for _, k := range []string{"s1", "s2", "s3", "s3"} {
+		tbl.GetStr(k)
+	}
+	st = tbl.CacheStats()
+	if st.Len != 2 || st.Evictions == 0 {
+		t.Errorf("Cache is not bounded %+v", st)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	_, err := tbl.GetStr("s3")
+	if err == nil {
+		t.Errorf("Expired key returned from the cache")
+	}
+
+	if (s.Table("other").CacheStats() != sett.CacheStats{}) {
+		t.Errorf("Table without cache has cache stats")
+	}
+}
@@ -0,0 +1,204 @@
+// settctl is a command line tool to work with sett stores
+//
+//	settctl -db ./data/mydb export -table client > client.jsonl
+//	settctl -db ./data/mydb import -conflict overwrite < client.jsonl
+//	settctl -db ./data/mydb rebuild -table orders -index Status
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"io"
+	"os"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: settctl -db <dir> <command> [flags]
+
+Commands:
+  export   write the items of a table as JSON Lines
+  import   read JSON Lines written by export
+  rebuild  have the indexes of a table built again from its values
+
+Global flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbPath := flag.String("db", "", "database directory")
+	flag.Usage = usage
+	flag.Parse()
+	if len(*dbPath) == 0 || flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch flag.Arg(0) {
+	case "export":
+		err = runExport(*dbPath, flag.Args()[1:])
+	case "import":
+		err = runImport(*dbPath, flag.Args()[1:])
+	case "rebuild":
+		err = runRebuild(*dbPath, flag.Args()[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "settctl:", err)
+		os.Exit(1)
+	}
+}
+
+// openStore opens the store, with the key file as
+// the key provider of the tables if it is given
+func openStore(path string, tables []string, keyFile string) (*sett.Sett, error) {
+	opts := sett.DefaultOptions(path)
+	opts.Logger = nil
+	s, err := sett.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyFile) > 0 {
+		kp, err := sett.NewFileKeyProvider(keyFile)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		for _, table := range tables {
+			s.Table(table).WithEncryption(kp)
+		}
+	}
+	return s, nil
+}
+
+func runExport(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	table := fs.String("table", "", "table to export. All keys if empty")
+	out := fs.String("out", "", "output file. Standard output if empty")
+	keyFile := fs.String("keys", "", "key file of the table, if it is encrypted")
+	encrypted := fs.String("encrypted", "", "comma separated tables the key file applies to, when exporting all keys")
+	fs.Parse(args)
+
+	tables := []string{*table}
+	if len(*table) == 0 && len(*encrypted) > 0 {
+		tables = strings.Split(*encrypted, ",")
+	}
+	s, err := openStore(dbPath, tables, *keyFile)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var w io.Writer = os.Stdout
+	if len(*out) > 0 {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := s.ExportTable(w, *table)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d items\n", n)
+	return nil
+}
+
+func runImport(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "input file. Standard input if empty")
+	conflict := fs.String("conflict", "skip", "what to do with existing keys: skip, overwrite or fail")
+	table := fs.String("table", "", "comma separated tables the key file applies to")
+	keyFile := fs.String("keys", "", "key file to encrypt the tables with")
+	fs.Parse(args)
+
+	var mode sett.ConflictMode
+	switch *conflict {
+	case "skip":
+		mode = sett.ImportSkip
+	case "overwrite":
+		mode = sett.ImportOverwrite
+	case "fail":
+		mode = sett.ImportFail
+	default:
+		return fmt.Errorf("unknown conflict mode %s", *conflict)
+	}
+
+	s, err := openStore(dbPath, strings.Split(*table, ","), *keyFile)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var r io.Reader = os.Stdin
+	if len(*in) > 0 {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := s.ImportTable(r, mode)
+	fmt.Fprintf(os.Stderr, "imported %d items\n", n)
+	return err
+}
+
+// runRebuild removes the indexes, with the markers telling they were
+// built. settctl knows neither the struct types registered with gob
+// nor the GeoFunc of the application, so it leaves building them to
+// the next CreateIndex, CreateTextIndex, CreateGeoIndex or VectorIndex
+func runRebuild(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	table := fs.String("table", "", "table of the indexes")
+	index := fs.String("index", "", "comma separated fields of the field indexes to rebuild")
+	text := fs.Bool("text", false, "rebuild the text index")
+	geo := fs.Bool("geo", false, "rebuild the geo index")
+	vector := fs.String("vector", "", "comma separated fields of the vector indexes to rebuild")
+	fs.Parse(args)
+
+	s, err := openStore(dbPath, nil, "")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	t := s.Table(*table)
+	if len(*index) > 0 {
+		for _, field := range strings.Split(*index, ",") {
+			err = t.DropIndex(field)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if *text {
+		err = t.DropTextIndex()
+		if err != nil {
+			return err
+		}
+	}
+	if *geo {
+		err = t.DropGeoIndex()
+		if err != nil {
+			return err
+		}
+	}
+	if len(*vector) > 0 {
+		for _, field := range strings.Split(*vector, ",") {
+			err = t.DropVectorIndex(field)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, "the indexes are built again the next time the application creates them")
+	return nil
+}
@@ -0,0 +1,173 @@
+package sett
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ComputeFunc computes the value of a key missing from the store.
+// It returns a string or a struct registered with gob, as for Set
+type ComputeFunc func() (interface{}, error)
+
+// ComputeOption changes how GetOrCompute works
+type ComputeOption func(o *computeOptions)
+
+type computeOptions struct {
+	stale time.Duration
+}
+
+// StaleWhileRefresh keeps the value for window after its TTL. When read
+// during that window, the stale value is returned while a new one is
+// computed in the background
+func StaleWhileRefresh(window time.Duration) ComputeOption {
+	return func(o *computeOptions) {
+		o.stale = window
+	}
+}
+
+// GetOrCompute returns the value of the key, or computes it with fn and
+// stores it with the ttl when the key is missing. Concurrent callers in
+// the process asking for the same missing key wait for a single call of fn.
+// If fn succeeds but the value can't be stored, the value is returned
+// along with the error
+func (s *Sett) GetOrCompute(key string, ttl time.Duration, fn ComputeFunc, opts ...ComputeOption) (interface{}, error) {
+	return s.GetOrComputeCtx(context.Background(), key, ttl, fn, opts...)
+}
+
+// GetOrComputeCtx is GetOrCompute with a context. The context is not
+// passed to the refresh of a stale value, which outlives the call
+func (s *Sett) GetOrComputeCtx(ctx context.Context, key string, ttl time.Duration, fn ComputeFunc, opts ...ComputeOption) (interface{}, error) {
+	var o computeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.stale > 0 && ttl <= 0 {
+		return nil, errors.New("StaleWhileRefresh needs a TTL")
+	}
+	v, expiresAt, err := s.getWithExpiry(ctx, key)
+	if err == nil {
+		// a value without expiry, persisted since, is never stale
+		if o.stale > 0 && expiresAt > 0 && remainingTTL(expiresAt) <= o.stale {
+			s.refresh(key, ttl, fn, o)
+		}
+		return v, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	fullKey := s.makeKey(key)
+	f, leader := s.shared.flights.begin(fullKey)
+	if !leader {
+		f.wg.Wait()
+		return f.val, f.err
+	}
+	var val interface{}
+	defer func() {
+		s.shared.flights.end(fullKey, f, val, err)
+	}()
+	// The value may have been stored by a flight that just ended
+	val, _, err = s.getWithExpiry(ctx, key)
+	if err == nil || !errors.Is(err, ErrKeyNotFound) {
+		return val, err
+	}
+	val, err = s.compute(ctx, key, ttl, fn, o)
+	return val, err
+}
+
+// refresh computes the value in the background, unless it is being computed already
+func (s *Sett) refresh(key string, ttl time.Duration, fn ComputeFunc, o computeOptions) {
+	fullKey := s.makeKey(key)
+	f, leader := s.shared.flights.begin(fullKey)
+	if !leader {
+		return
+	}
+	started := s.shared.goBackground(func() {
+		val, err := s.compute(context.Background(), key, ttl, fn, o)
+		s.shared.flights.end(fullKey, f, val, err)
+	})
+	if !started {
+		s.shared.flights.end(fullKey, f, nil, errors.New("The store is closed"))
+	}
+}
+
+func (s *Sett) compute(ctx context.Context, key string, ttl time.Duration, fn ComputeFunc, o computeOptions) (val interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			val, err = nil, fmt.Errorf("compute of %s panicked: %v", key, r)
+		}
+	}()
+	val, err = fn()
+	if err != nil {
+		return nil, err
+	}
+	return val, s.SetCtx(ctx, key, val, ExpireIn(ttl+o.stale))
+}
+
+// getWithExpiry returns the value of the key and the time it expires at.
+// It is a Get to the middleware
+func (s *Sett) getWithExpiry(ctx context.Context, key string) (val interface{}, expiresAt uint64, err error) {
+	err = s.do(ctx, OpGet, key, nil, func(c *opCall) error {
+		return s.db.View(func(txn Txn) error {
+			si := NewSettItem(s, txn, c.key)
+			si.call = c
+			item, err := txn.Get([]byte(si.fullKey))
+			if err != nil {
+				return err
+			}
+			expiresAt = item.ExpiresAt()
+			if (item.UserMeta() & 0x0F) == STRING_TYPE {
+				val, err = si.GetStringValue()
+			} else {
+				var sv *SettValueItem
+				sv, err = si.cachedStructValue()
+				if err == nil {
+					val = sv.V
+				}
+			}
+			c.result = val
+			return err
+		})
+	})
+	return val, expiresAt, err
+}
+
+type flight struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// flightGroup tracks the values being computed, by key
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// begin returns the flight of the key. leader is true
+// if the flight was started by the call, which must then end it
+func (g *flightGroup) begin(key string) (f *flight, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	f = &flight{}
+	f.wg.Add(1)
+	g.flights[key] = f
+	return f, true
+}
+
+func (g *flightGroup) end(key string, f *flight, val interface{}, err error) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	f.val, f.err = val, err
+	f.wg.Done()
+}
@@ -0,0 +1,113 @@
+package sett_test
+
+import (
+	"errors"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrCompute(t *testing.T) {
+	s := setttest.New(t)
+	tbl := s.Table("cache")
+
+	var calls int32
+	compute := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "computed", nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := s.Table("cache").GetOrCompute("k", time.Hour, compute)
+			if err != nil || v != "computed" {
+				t.Errorf("GetOrCompute returned %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Expected a single computation, got %d", calls)
+	}
+	v, err := tbl.GetStr("k")
+	if err != nil || v != "computed" {
+		t.Errorf("Computed value was not stored %v", err)
+	}
+	ttl, _ := tbl.TTL("k")
+	if ttl <= 59*time.Minute {
+		t.Errorf("Computed value stored without the TTL %v", ttl)
+	}
+
+	failure := errors.New("backend down")
+	_, err = tbl.GetOrCompute("failing", time.Hour, func() (interface{}, error) {
+		return nil, failure
+	})
+	if !errors.Is(err, failure) || tbl.HasKey("failing") {
+		t.Errorf("Expected the error of the computation, got %v", err)
+	}
+}
+
+func TestStaleWhileRefresh(t *testing.T) {
+	setttest.VerifyNoLeaks(t)
+	s := setttest.New(t)
+	tbl := s.Table("cache")
+
+	var calls int32
+	compute := func() (interface{}, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 1 {
+			return "v1", nil
+		}
+		return "v2", nil
+	}
+	window := sett.StaleWhileRefresh(time.Hour)
+	v, _ := tbl.GetOrCompute("k", time.Second, compute, window)
+	if v != "v1" {
+		t.Fatalf("Expected v1, got %v", v)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	v, err := tbl.GetOrCompute("k", time.Second, compute, window)
+	if err != nil || v != "v1" {
+		t.Errorf("Expected the stale value, got %v %v", v, err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := tbl.GetStr("k"); v == "v2" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("Value was not refreshed in the background")
+}
+
+func TestStaleWhileRefreshPersisted(t *testing.T) {
+	s := setttest.New(t)
+	tbl := s.Table("cache")
+	var calls int32
+	compute := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "v", nil
+	}
+	window := sett.StaleWhileRefresh(time.Hour)
+	tbl.GetOrCompute("k", time.Second, compute, window)
+	err := tbl.Persist("k")
+	if err != nil {
+		t.Fatalf("Persist failed %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		v, err := tbl.GetOrCompute("k", time.Second, compute, window)
+		if err != nil || v != "v" {
+			t.Fatalf("Expected the value, got %v %v", v, err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected the value computed once, got %d", n)
+	}
+}
@@ -0,0 +1,413 @@
+package sett
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyProvider supplies the key encryption keys of a table.
+// Every value is encrypted with its own data key which is then
+// wrapped with the current key of the provider. The id of that key
+// is stored along with the value, so older keys must remain
+// available until RotateKeys has moved all the values off them.
+type KeyProvider interface {
+	// CurrentKey returns the key that new values are to be wrapped with
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id
+	Key(id string) ([]byte, error)
+}
+
+// WithEncryption enables envelope encryption of the values in this table
+// using AES-GCM. Unlike TTL, the setting is kept for the table and applies
+// to every handle returned by Table() afterwards.
+// Values written before encryption was enabled are still readable.
+func (s *Sett) WithEncryption(keys KeyProvider) *Sett {
+	s.shared.tables.update(s.table, func(o *tableOptions) {
+		o.keys = keys
+	})
+	return s
+}
+
+// RotateKeys re-wraps the values in the table that are not encrypted with
+// the current key of the table's KeyProvider. The table is processed
+// in batches of batchSize items, each in its own transaction, so that
+// the table remains usable while the rotation is in progress.
+// The values of the root table are told apart from those of the
+// other tables by the tables with settings, like WithEncryption.
+// Returns the number of values rotated.
+func (s *Sett) RotateKeys(batchSize int) (int, error) {
+	return s.RotateKeysCtx(context.Background(), batchSize)
+}
+
+// RotateKeysCtx is RotateKeys with a context. When the context is done,
+// the rotation stops after the current batch, and can be run again
+func (s *Sett) RotateKeysCtx(ctx context.Context, batchSize int) (rotated int, err error) {
+	err = s.do(ctx, OpRotateKeys, "", nil, func(c *opCall) error {
+		var err error
+		rotated, err = s.rotateKeys(c, batchSize)
+		return err
+	})
+	return rotated, err
+}
+
+func (s *Sett) rotateKeys(c *opCall, batchSize int) (int, error) {
+	keys := s.options().keys
+	if keys == nil {
+		return 0, fmt.Errorf("Encryption is not enabled for the table %s", s.table)
+	}
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	var tables []string
+	if len(s.table) == 0 {
+		tables = s.shared.tables.names()
+	}
+	prefix := []byte(s.makeKey(""))
+	seek := prefix
+	rotated := 0
+	for {
+		if err := c.canceled(); err != nil {
+			return rotated, err
+		}
+		currentID, _, err := keys.CurrentKey()
+		if err != nil {
+			return rotated, err
+		}
+		var batch [][]byte
+		err = s.db.View(func(txn Txn) error {
+			it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+			defer it.Close()
+			for it.Seek(seek); it.Valid(); it.Next() {
+				item := it.Item()
+				if (item.UserMeta()&ENCRYPTED_FLAG) == 0 || isSystemKey(item.Key()) {
+					continue
+				}
+				if _, ok := tableOf(tables, string(item.Key())); ok {
+					continue
+				}
+				var id string
+				err := item.Value(func(val []byte) error {
+					var err error
+					id, _, _, err = parseEnvelope(val)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				if id == currentID {
+					continue
+				}
+				batch = append(batch, item.KeyCopy(nil))
+				if len(batch) >= batchSize {
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return rotated, err
+		}
+		if len(batch) == 0 {
+			return rotated, nil
+		}
+		n, err := s.rotateBatch(keys, batch)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+		if len(batch) < batchSize {
+			return rotated, nil
+		}
+		last := batch[len(batch)-1]
+		seek = append(last, 0)
+	}
+}
+
+func (s *Sett) rotateBatch(keys KeyProvider, batch [][]byte) (int, error) {
+	var n int
+	var err error
+	// Concurrent writers may touch the same items. The whole batch
+	// is re-checked and tried again in that case.
+	for t := 0; t < 10; t++ {
+		n = 0
+		err = s.db.Update(func(txn Txn) error {
+			for _, k := range batch {
+				item, err := txn.Get(k)
+				if errors.Is(err, ErrKeyNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if (item.UserMeta() & ENCRYPTED_FLAG) == 0 {
+					continue
+				}
+				val, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				val, changed, err := rewrapValue(keys, val)
+				if err != nil {
+					return err
+				}
+				if !changed {
+					continue
+				}
+				// The plain value is the same, so its history
+				// and index entries are left as they are
+				e := &Entry{Key: k, Value: val, UserMeta: item.UserMeta(), ExpiresAt: item.ExpiresAt()}
+				err = txn.Set(e)
+				if err != nil {
+					return err
+				}
+				if (e.UserMeta&TRACKED_FLAG) != 0 && e.ExpiresAt > 0 {
+					// the expiry index keeps a copy of the value for OnExpire
+					err = s.trackExpiry(txn, e)
+					if err != nil {
+						return err
+					}
+				}
+				n++
+			}
+			return nil
+		})
+		if !errors.Is(err, ErrConflict) {
+			break
+		}
+		s.count(EventConflictRetry)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+const envelopeVersion = 1
+
+// The sealed value is laid out as
+// version | len(key id) | key id | wrapped data key | encrypted value
+// where both the wrapped data key and the encrypted value are
+// a GCM nonce followed by the cipher text
+func sealValue(keys KeyProvider, fullKey []byte, val []byte) ([]byte, error) {
+	id, kek, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("The key id %s is too long", id)
+	}
+	dek := make([]byte, 32)
+	_, err = rand.Read(dek)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := gcmSeal(kek, dek, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	payload, err := gcmSeal(dek, val, fullKey)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]byte, 0, 2+len(id)+len(wrapped)+len(payload))
+	ret = append(ret, envelopeVersion, byte(len(id)))
+	ret = append(ret, id...)
+	ret = append(ret, wrapped...)
+	ret = append(ret, payload...)
+	return ret, nil
+}
+
+func openValue(keys KeyProvider, fullKey []byte, sealed []byte) ([]byte, error) {
+	dek, payload, err := unwrapDataKey(keys, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(dek, payload, fullKey)
+}
+
+// rewrapValue wraps the data key of the value with the current key.
+// The encrypted value itself is left untouched.
+func rewrapValue(keys KeyProvider, sealed []byte) ([]byte, bool, error) {
+	id, _, _, err := parseEnvelope(sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	currentID, kek, err := keys.CurrentKey()
+	if err != nil {
+		return nil, false, err
+	}
+	if id == currentID {
+		return sealed, false, nil
+	}
+	dek, payload, err := unwrapDataKey(keys, sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	wrapped, err := gcmSeal(kek, dek, []byte(currentID))
+	if err != nil {
+		return nil, false, err
+	}
+	ret := make([]byte, 0, 2+len(currentID)+len(wrapped)+len(payload))
+	ret = append(ret, envelopeVersion, byte(len(currentID)))
+	ret = append(ret, currentID...)
+	ret = append(ret, wrapped...)
+	ret = append(ret, payload...)
+	return ret, true, nil
+}
+
+func unwrapDataKey(keys KeyProvider, sealed []byte) ([]byte, []byte, error) {
+	id, wrapped, payload, err := parseEnvelope(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	kek, err := keys.Key(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	dek, err := gcmOpen(kek, wrapped, []byte(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return dek, payload, nil
+}
+
+// wrapped data key is nonce(12) + key(32) + tag(16)
+const wrappedKeyLength = 12 + 32 + 16
+
+func parseEnvelope(sealed []byte) (string, []byte, []byte, error) {
+	if len(sealed) < 2 || sealed[0] != envelopeVersion {
+		return "", nil, nil, errors.New("Unknown format of encrypted value")
+	}
+	idLen := int(sealed[1])
+	if len(sealed) < 2+idLen+wrappedKeyLength {
+		return "", nil, nil, errors.New("Encrypted value is truncated")
+	}
+	id := string(sealed[2 : 2+idLen])
+	wrapped := sealed[2+idLen : 2+idLen+wrappedKeyLength]
+	payload := sealed[2+idLen+wrappedKeyLength:]
+	return id, wrapped, payload, nil
+}
+
+func gcmSeal(key []byte, plain []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, ad), nil
+}
+
+func gcmOpen(key []byte, sealed []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Encrypted value is truncated")
+	}
+	nonce := sealed[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, sealed[gcm.NonceSize():], ad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// FileKeyProvider is a KeyProvider reading the keys from a local file.
+// Each line of the file has a key id and the hex encoded key
+// (16, 24 or 32 bytes) separated by space. Empty lines and lines
+// starting with # are ignored. The last key in the file is the current key.
+type FileKeyProvider struct {
+	path    string
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// NewFileKeyProvider loads the keys from the file at path
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	kp := &FileKeyProvider{path: path}
+	err := kp.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// Reload reads the key file again. Call it after
+// adding a new key to the file, before RotateKeys
+func (kp *FileKeyProvider) Reload() error {
+	f, err := os.Open(kp.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys := make(map[string][]byte)
+	var current string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		l := strings.TrimSpace(scanner.Text())
+		if len(l) == 0 || strings.HasPrefix(l, "#") {
+			continue
+		}
+		fields := strings.Fields(l)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected key id and key", kp.path, line)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", kp.path, line, err)
+		}
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return fmt.Errorf("%s:%d: invalid key length %d", kp.path, line, len(key))
+		}
+		keys[fields[0]] = key
+		current = fields[0]
+	}
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		return fmt.Errorf("No keys found in %s", kp.path)
+	}
+	kp.mu.Lock()
+	kp.keys = keys
+	kp.current = current
+	kp.mu.Unlock()
+	return nil
+}
+
+func (kp *FileKeyProvider) CurrentKey() (string, []byte, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	return kp.current, kp.keys[kp.current], nil
+}
+
+func (kp *FileKeyProvider) Key(id string) ([]byte, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	key, ok := kp.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("Key %s not found in %s", id, kp.path)
+	}
+	return key, nil
+}
@@ -0,0 +1,135 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"os"
+	"path/filepath"
+	"syreclabs.com/go/faker"
+	"testing"
+)
+
+const (
+	testKey1 = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testKey2 = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func writeKeyFile(t *testing.T, path string, content string) {
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("Couldn't write key file %v", err)
+	}
+}
+
+func TestEncryptedTable(t *testing.T) {
+	gob.Register(&Signup{})
+	s := setttest.New(t)
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	writeKeyFile(t, keyFile, "k1 "+testKey1+"\n")
+	kp, err := sett.NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatalf("Couldn't load keys %v", err)
+	}
+
+	table := faker.RandomString(8)
+	s.Table(table).WithEncryption(kp)
+
+	var su Signup
+	su.Name = faker.Name().Name()
+	su.Email = faker.Internet().SafeEmail()
+	k := faker.RandomString(8)
+	err = s.Table(table).SetStruct(k, &su)
+	if err != nil {
+		t.Fatalf("Error setting encrypted struct %v", err)
+	}
+	v := faker.RandomString(12)
+	err = s.Table(table).SetStr("str", v)
+	if err != nil {
+		t.Fatalf("Error setting encrypted string %v", err)
+	}
+
+	sur, err := s.Table(table).GetStruct(k)
+	if err != nil {
+		t.Fatalf("Error getting encrypted struct %v", err)
+	}
+	if sur.(*Signup).Email != su.Email {
+		t.Errorf("The decrypted value does not match")
+	}
+	vr, err := s.Table(table).GetStr("str")
+	if err != nil || vr != v {
+		t.Errorf("The decrypted string does not match %v", err)
+	}
+
+	// Rotate to a new key and drop the old one
+	writeKeyFile(t, keyFile, "k1 "+testKey1+"\nk2 "+testKey2+"\n")
+	err = kp.Reload()
+	if err != nil {
+		t.Fatalf("Couldn't reload keys %v", err)
+	}
+	n, err := s.Table(table).RotateKeys(1)
+	if err != nil {
+		t.Fatalf("Error rotating keys %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 values rotated, got %d", n)
+	}
+	writeKeyFile(t, keyFile, "k2 "+testKey2+"\n")
+	err = kp.Reload()
+	if err != nil {
+		t.Fatalf("Couldn't reload keys %v", err)
+	}
+
+	sur, err = s.Table(table).GetStruct(k)
+	if err != nil {
+		t.Fatalf("Error getting struct after rotation %v", err)
+	}
+	if sur.(*Signup).Email != su.Email {
+		t.Errorf("The value does not match after rotation")
+	}
+	n, err = s.Table(table).RotateKeys(10)
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing to rotate, got %d %v", n, err)
+	}
+}
+
+func TestRotateKeysRootTable(t *testing.T) {
+	s := setttest.New(t)
+	rootFile := filepath.Join(t.TempDir(), "root")
+	writeKeyFile(t, rootFile, "k1 "+testKey1+"\n")
+	rootKeys, err := sett.NewFileKeyProvider(rootFile)
+	if err != nil {
+		t.Fatalf("Couldn't load keys %v", err)
+	}
+	otherFile := filepath.Join(t.TempDir(), "other")
+	writeKeyFile(t, otherFile, "o1 "+testKey2+"\n")
+	otherKeys, err := sett.NewFileKeyProvider(otherFile)
+	if err != nil {
+		t.Fatalf("Couldn't load keys %v", err)
+	}
+	s.WithEncryption(rootKeys).WithHistory(sett.HistoryOptions{})
+	s.Table("other").WithEncryption(otherKeys)
+	s.SetStr("a", "root value")
+	s.Table("other").SetStr("b", "other value")
+
+	writeKeyFile(t, rootFile, "k1 "+testKey1+"\nk2 "+testKey2+"\n")
+	err = rootKeys.Reload()
+	if err != nil {
+		t.Fatalf("Couldn't reload keys %v", err)
+	}
+	// the values of the other table are left to its own provider
+	n, err := s.RotateKeys(10)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected only the root value rotated, got %d %v", n, err)
+	}
+	v, err := s.Table("other").GetStr("b")
+	if err != nil || v != "other value" {
+		t.Errorf("The value of the other table does not match %q %v", v, err)
+	}
+	// the plain value is unchanged, so no version is added to the history
+	history, err := s.History("a", 0)
+	if err != nil || len(history) != 1 {
+		t.Errorf("Expected a single version of a, got %+v %v", history, err)
+	}
+}
@@ -0,0 +1,252 @@
+package sett
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ExpireFunc is called with the key and the value of an item after
+// the item expired. The value is nil if it could not be decoded,
+// for example when the struct type is not registered with gob
+type ExpireFunc func(key string, value interface{})
+
+type expiryOptions struct {
+	hooks   []ExpireFunc
+	archive string
+}
+
+var expiryIndex = []byte("exp:")
+
+const (
+	expiryCheckInterval = 1 * time.Second
+	expiryBatchSize     = 100
+)
+
+// OnExpire registers fn to be called shortly after an item of the table
+// expires. It is called from a background goroutine which runs until the
+// store is closed. Only the items written with a TTL after OnExpire or
+// ArchiveOnExpire was called for the table are tracked. Tracked values
+// are kept in an index until they expire, taking up extra space.
+func (s *Sett) OnExpire(table string, fn ExpireFunc) {
+	s.shared.tables.update(table, func(o *tableOptions) {
+		eo := expiryOptions{}
+		if o.expiry != nil {
+			eo = *o.expiry
+		}
+		eo.hooks = append(eo.hooks[:len(eo.hooks):len(eo.hooks)], fn)
+		o.expiry = &eo
+	})
+	s.shared.startExpiryTracker(s)
+}
+
+// ArchiveOnExpire moves the expired items of the table into the archive
+// table, without expiry, instead of losing them
+func (s *Sett) ArchiveOnExpire(table string, archive string) {
+	s.shared.tables.update(table, func(o *tableOptions) {
+		eo := expiryOptions{}
+		if o.expiry != nil {
+			eo = *o.expiry
+		}
+		eo.archive = archive
+		o.expiry = &eo
+	})
+	s.shared.startExpiryTracker(s)
+}
+
+// The expiry index is ordered by time. Its keys are
+// expiryIndex | expires at (8 bytes) | full key
+// and its values
+// user meta (1 byte) | len(table) (2 bytes) | table | stored value
+func expiryIndexKey(expiresAt uint64, fullKey []byte) []byte {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], expiresAt)
+	return systemKey(expiryIndex, ts[:], fullKey)
+}
+
+func (s *Sett) trackExpiry(txn Txn, e *Entry) error {
+	e.UserMeta = e.UserMeta | TRACKED_FLAG
+	val := make([]byte, 3, 3+len(s.table)+len(e.Value))
+	val[0] = e.UserMeta
+	binary.BigEndian.PutUint16(val[1:3], uint16(len(s.table)))
+	val = append(val, s.table...)
+	val = append(val, e.Value...)
+	return txn.Set(&Entry{Key: expiryIndexKey(e.ExpiresAt, e.Key), Value: val})
+}
+
+// untrackExpiry removes item, the current value of the key, from the expiry index
+func untrackExpiry(txn Txn, key []byte, item Item) error {
+	if item == nil {
+		return nil
+	}
+	if (item.UserMeta()&TRACKED_FLAG) == 0 || item.ExpiresAt() == 0 {
+		return nil
+	}
+	return txn.Delete(expiryIndexKey(item.ExpiresAt(), key))
+}
+
+type expiryTracker struct {
+	s      *Sett
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (sh *shared) startExpiryTracker(s *Sett) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.expiry != nil {
+		return
+	}
+	et := &expiryTracker{s: &Sett{db: s.db, shared: sh}, closed: make(chan struct{})}
+	sh.expiry = et
+	sh.closers = append(sh.closers, et.stop)
+	et.wg.Add(1)
+	go et.run()
+}
+
+func (et *expiryTracker) run() {
+	defer et.wg.Done()
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Failed batches stay in the index and are tried again next time
+			err := et.process()
+			if errors.Is(err, ErrConflict) {
+				et.s.count(EventConflictRetry)
+			}
+		case <-et.closed:
+			return
+		}
+	}
+}
+
+func (et *expiryTracker) stop() {
+	close(et.closed)
+	et.wg.Wait()
+}
+
+type expiredItem struct {
+	indexKey []byte
+	fullKey  []byte
+	table    string
+	meta     byte
+	value    []byte
+}
+
+// process handles the items in the index that have expired by now
+func (et *expiryTracker) process() error {
+	prefix := systemKey(expiryIndex)
+	for {
+		select {
+		case <-et.closed:
+			return nil
+		default:
+		}
+		now := uint64(time.Now().Unix())
+		var batch []expiredItem
+		err := et.s.db.View(func(txn Txn) error {
+			it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+			defer it.Close()
+			for it.Seek(prefix); it.Valid() && len(batch) < expiryBatchSize; it.Next() {
+				item := it.Item()
+				k := item.KeyCopy(nil)
+				if binary.BigEndian.Uint64(k[len(prefix):len(prefix)+8]) > now {
+					break
+				}
+				val, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				tl := int(binary.BigEndian.Uint16(val[1:3]))
+				batch = append(batch, expiredItem{
+					indexKey: k,
+					fullKey:  k[len(prefix)+8:],
+					meta:     val[0],
+					table:    string(val[3 : 3+tl]),
+					value:    val[3+tl:],
+				})
+			}
+			return nil
+		})
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		fired, err := et.expire(batch)
+		if err != nil {
+			return err
+		}
+		for _, ei := range fired {
+			et.fire(ei)
+		}
+		if len(batch) < expiryBatchSize {
+			return nil
+		}
+	}
+}
+
+// expire removes the batch from the index, archiving the values if required.
+// Returns the items that have really expired
+func (et *expiryTracker) expire(batch []expiredItem) ([]expiredItem, error) {
+	var fired []expiredItem
+	err := et.s.db.Update(func(txn Txn) error {
+		fired = fired[:0]
+		for _, ei := range batch {
+			item, err := txn.Get(ei.fullKey)
+			if err == nil && item.ExpiresAt() == binary.BigEndian.Uint64(ei.indexKey[len(systemPrefix)+len(expiryIndex):]) {
+				// Not expired yet by the clock of the backend
+				continue
+			}
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				return err
+			}
+			// A key written again before its expiry is removed from the
+			// index by the write. One still here with another expiry was
+			// written again after the tracked value expired
+			err = txn.Delete(ei.indexKey)
+			if err != nil {
+				return err
+			}
+			t := et.s.Table(ei.table)
+			archive := t.options().expiry
+			if archive != nil && len(archive.archive) > 0 {
+				key := string(ei.fullKey[len(t.makeKey("")):])
+				plain, err := NewSettItem(t, txn, key).plainValue(ei.meta, ei.value)
+				if err != nil {
+					return err
+				}
+				at := et.s.Table(archive.archive)
+				vtype := ei.meta &^ (ENCRYPTED_FLAG | TRACKED_FLAG)
+				err = NewSettItem(at, txn, key).writeValue(plain, vtype)
+				if err != nil {
+					return err
+				}
+			}
+			fired = append(fired, ei)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fired, nil
+}
+
+func (et *expiryTracker) fire(ei expiredItem) {
+	t := et.s.Table(ei.table)
+	o := t.options().expiry
+	if o == nil || len(o.hooks) == 0 {
+		return
+	}
+	key := string(ei.fullKey[len(t.makeKey("")):])
+	var value interface{}
+	plain, err := NewSettItem(t, nil, key).plainValue(ei.meta, ei.value)
+	if err == nil {
+		value, _ = decodeValue(ei.meta, plain)
+	}
+	for _, fn := range o.hooks {
+		fn(key, value)
+	}
+}
@@ -0,0 +1,120 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnExpire(t *testing.T) {
+	setttest.VerifyNoLeaks(t)
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testOnExpire(t, b.open(t))
+		})
+	}
+}
+
+func testOnExpire(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	var mu sync.Mutex
+	expired := make(map[string]interface{})
+	s.OnExpire("sessions", func(key string, value interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		expired[key] = value
+	})
+	s.ArchiveOnExpire("sessions", "old_sessions")
+
+	tbl := s.Table("sessions")
+	tbl.SetStr("s1", "v1", sett.ExpireIn(time.Second))
+	tbl.SetStruct("s2", &TaskObj{ID: 2}, sett.ExpireIn(time.Second))
+	tbl.SetStr("deleted", "v", sett.ExpireIn(time.Second))
+	tbl.Delete("deleted")
+	tbl.SetStr("persisted", "v", sett.ExpireIn(time.Second))
+	tbl.SetStr("persisted", "v2")
+	tbl.SetStr("touched", "v", sett.ExpireIn(time.Second))
+	tbl.Touch("touched", time.Hour)
+	s.Table("other").SetStr("o1", "v", sett.ExpireIn(time.Second))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(expired)
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	// Give the tracker a chance to fire for the keys that shouldn't expire
+	time.Sleep(1100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 2 {
+		t.Fatalf("Expected 2 expired items, got %v", expired)
+	}
+	if expired["s1"] != "v1" {
+		t.Errorf("Unexpected value of expired string %v", expired["s1"])
+	}
+	if task, ok := expired["s2"].(*TaskObj); !ok || task.ID != 2 {
+		t.Errorf("Unexpected value of expired struct %v", expired["s2"])
+	}
+
+	v, err := s.Table("old_sessions").GetStr("s1")
+	if err != nil || v != "v1" {
+		t.Errorf("Expired item was not archived %v", err)
+	}
+	ttl, _ := s.Table("old_sessions").TTL("s1")
+	if ttl != 0 {
+		t.Errorf("Archived item has an expiry %v", ttl)
+	}
+	if s.Table("old_sessions").HasKey("deleted") {
+		t.Errorf("Deleted item was archived")
+	}
+	if !tbl.HasKey("touched") || !tbl.HasKey("persisted") {
+		t.Errorf("Items with extended expiry are missing")
+	}
+
+	// The expiry index doesn't show up in the keys of the store
+	keys, _ := s.Keys()
+	for _, k := range keys {
+		if len(k) > 0 && k[0] == 0xff {
+			t.Errorf("Internal key listed %q", k)
+		}
+	}
+}
+
+func TestOnExpireWrittenAgain(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			fired := make(chan interface{}, 1)
+			s.OnExpire("sessions", func(key string, value interface{}) {
+				fired <- value
+			})
+			tbl := s.Table("sessions")
+			// written again once expired, before the tracker noticed it
+			tbl.SetStr("s1", "v1", sett.ExpireAt(time.Now().Add(-time.Second)))
+			tbl.SetStr("s1", "v2")
+			select {
+			case v := <-fired:
+				if v != "v1" {
+					t.Errorf("Expected the expired value, got %v", v)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("OnExpire was not called for the value written again")
+			}
+			v, err := tbl.GetStr("s1")
+			if err != nil || v != "v2" {
+				t.Errorf("Expected the new value kept, got %v %v", v, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package sett
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConflictMode tells ImportTable what to do when
+// a key in the input already exists in the store
+type ConflictMode int
+
+const (
+	// ImportSkip keeps the existing value
+	ImportSkip ConflictMode = iota
+	// ImportOverwrite replaces the existing value, even if it is locked
+	ImportOverwrite
+	// ImportFail stops the import with ErrImportConflict
+	ImportFail
+)
+
+var ErrImportConflict = errors.New("key already exists")
+
+// ExportRecord is one line of the JSON Lines export
+type ExportRecord struct {
+	Table string `json:"table"`
+	// Key is the series for the points and rollups of time series
+	Key string `json:"key"`
+	// Type is "string", "struct", "point" or "rollup"
+	Type string `json:"type"`
+	// Time is the time of a point or of the start of a rollup
+	Time *time.Time `json:"time,omitempty"`
+	// Value is the string value, the gob encoded struct in base64,
+	// the value of a point or the encoded rollup in base64
+	Value string `json:"value"`
+	// Data is the struct value or the rollup as JSON, for reading and
+	// diffing only. Present for structs only when the type was registered
+	// with gob, and for both only when the value can be written as JSON
+	Data   interface{} `json:"data,omitempty"`
+	Locked bool        `json:"locked,omitempty"`
+	// TTL is the remaining time to live in seconds. 0 means no expiry
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+const importBatchSize = 1000
+
+// ExportTable writes all the items of the table to w as JSON Lines.
+// Encrypted values are written decrypted. An empty table exports the
+// whole store, with the items of the tables which have settings, like
+// encryption, written as items of their table, so that they are
+// decrypted and imported with the settings of their table.
+// Returns the number of items exported
+func (s *Sett) ExportTable(w io.Writer, table string) (int, error) {
+	return s.ExportTableCtx(context.Background(), w, table)
+}
+
+// ExportTableCtx is ExportTable with a context
+func (s *Sett) ExportTableCtx(ctx context.Context, w io.Writer, table string) (count int, err error) {
+	t := s.Table(table)
+	err = t.do(ctx, OpExport, "", nil, func(c *opCall) error {
+		defer func() { c.result = count }()
+		return t.exportTable(c, w, &count)
+	})
+	return count, err
+}
+
+func (s *Sett) exportTable(c *opCall, w io.Writer, count *int) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var tables []string
+	if len(s.table) == 0 {
+		tables = s.shared.tables.names()
+	}
+	err := s.db.View(func(txn Txn) error {
+		prefix := []byte(s.makeKey(""))
+		it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		now := uint64(time.Now().Unix())
+		for it.Seek(prefix); it.Valid(); it.Next() {
+			if err := c.canceled(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if isSystemKey(item.Key()) {
+				continue
+			}
+			t, k := s, string(item.Key()[len(prefix):])
+			if table, ok := tableOf(tables, k); ok {
+				t, k = s.Table(table), k[len(table)+1:]
+			}
+			rec := ExportRecord{Table: t.table, Key: k}
+			if item.ExpiresAt() > 0 {
+				if item.ExpiresAt() <= now {
+					continue
+				}
+				rec.TTL = int64(item.ExpiresAt() - now)
+			}
+			meta := item.UserMeta()
+			rec.Locked = (meta & 0x80) != 0
+			val, err := NewSettItem(t, txn, k).readValue(item)
+			if err != nil {
+				return fmt.Errorf("export of %s failed: %w", k, err)
+			}
+			switch meta & 0x0F {
+			case STRING_TYPE:
+				rec.Type = "string"
+				rec.Value = string(val)
+			case STRUCT_TYPE:
+				rec.Type = "struct"
+				rec.Value = base64.StdEncoding.EncodeToString(val)
+				var container genericContainer
+				if gob.NewDecoder(bytes.NewBuffer(val)).Decode(&container) == nil {
+					// left out when JSON can't hold it, like NaN fields
+					if data, err := json.Marshal(container.V); err == nil {
+						rec.Data = json.RawMessage(data)
+					}
+				}
+			case POINT_TYPE, ROLLUP_TYPE:
+				err = exportPoint(&rec, meta&0x0F, val)
+				if err != nil {
+					return fmt.Errorf("export of %q failed: %w", k, err)
+				}
+			default:
+				return fmt.Errorf("export of %s failed: unknown value type %d", k, meta&0x0F)
+			}
+			err = enc.Encode(&rec)
+			if err != nil {
+				return err
+			}
+			*count++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// tableOf returns the table of the tables the full key is in, the
+// longest if the names overlap. tables are sorted by decreasing length
+func tableOf(tables []string, fullKey string) (string, bool) {
+	for _, table := range tables {
+		if len(fullKey) > len(table) && fullKey[len(table)] == ':' && strings.HasPrefix(fullKey, table) {
+			return table, true
+		}
+	}
+	return "", false
+}
+
+// exportPoint fills the record of a point or a rollup of a time series,
+// keyed by the series and the time
+func exportPoint(rec *ExportRecord, vtype byte, val []byte) error {
+	series, ns, ok := parsePointKey([]byte(rec.Key))
+	if !ok {
+		return errors.New("not a time series key")
+	}
+	t := time.Unix(0, ns).UTC()
+	rec.Key = series
+	rec.Time = &t
+	if vtype == POINT_TYPE {
+		v, err := decodePoint(val)
+		if err != nil {
+			return err
+		}
+		rec.Type = "point"
+		rec.Value = strconv.FormatFloat(v, 'g', -1, 64)
+		return nil
+	}
+	fs, err := decodeRollup(val)
+	if err != nil {
+		return err
+	}
+	rec.Type = "rollup"
+	rec.Value = base64.StdEncoding.EncodeToString(val)
+	// JSON has no infinities or NaN
+	for _, f := range []float64{fs.Sum, fs.Min, fs.Max} {
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil
+		}
+	}
+	rec.Data = fs
+	return nil
+}
+
+// ImportTable reads JSON Lines written by ExportTable and stores the items
+// in the tables named in the records. Values are encrypted if encryption
+// is enabled for the table. Records are written in batches, so with
+// ImportFail the batches before the conflicting record remain imported.
+// Returns the number of items written
+func (s *Sett) ImportTable(r io.Reader, mode ConflictMode) (int, error) {
+	return s.ImportTableCtx(context.Background(), r, mode)
+}
+
+// ImportTableCtx is ImportTable with a context
+func (s *Sett) ImportTableCtx(ctx context.Context, r io.Reader, mode ConflictMode) (count int, err error) {
+	err = s.do(ctx, OpImport, "", nil, func(c *opCall) error {
+		defer func() { c.result = count }()
+		return s.importTable(c, r, mode, &count)
+	})
+	return count, err
+}
+
+func (s *Sett) importTable(c *opCall, r io.Reader, mode ConflictMode, count *int) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var batch []ExportRecord
+	for {
+		if err := c.canceled(); err != nil {
+			return err
+		}
+		var rec ExportRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("import failed after %d items: %w", *count, err)
+		}
+		batch = append(batch, rec)
+		if len(batch) >= importBatchSize {
+			n, err := s.importBatch(batch, mode)
+			*count += n
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	n, err := s.importBatch(batch, mode)
+	*count += n
+	return err
+}
+
+func (s *Sett) importBatch(batch []ExportRecord, mode ConflictMode) (int, error) {
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	count := 0
+	err := s.db.Update(func(txn Txn) error {
+		count = 0
+		for _, rec := range batch {
+			written, err := s.importRecord(txn, &rec, mode)
+			if err != nil {
+				return err
+			}
+			if written {
+				count++
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrTxnTooBig) && len(batch) > 1 {
+		h := len(batch) / 2
+		n1, err := s.importBatch(batch[:h], mode)
+		if err != nil {
+			return n1, err
+		}
+		n2, err := s.importBatch(batch[h:], mode)
+		return n1 + n2, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Sett) importRecord(txn Txn, rec *ExportRecord, mode ConflictMode) (bool, error) {
+	t := s.Table(rec.Table)
+	t.ttl = time.Duration(rec.TTL) * time.Second
+	key := rec.Key
+	if rec.Type == "point" || rec.Type == "rollup" {
+		if rec.Time == nil {
+			return false, fmt.Errorf("import of %s failed: %s without a time", rec.Key, rec.Type)
+		}
+		key = pointKey(rec.Key, rec.Time.UnixNano())
+	}
+	sit := NewSettItem(t, txn, key)
+
+	_, err := txn.Get([]byte(sit.fullKey))
+	if err == nil {
+		switch mode {
+		case ImportSkip:
+			return false, nil
+		case ImportFail:
+			return false, fmt.Errorf("import of %s failed: %w", sit.fullKey, ErrImportConflict)
+		}
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return false, err
+	}
+
+	var val []byte
+	var vtype byte
+	switch rec.Type {
+	case "string":
+		val = []byte(rec.Value)
+		vtype = STRING_TYPE
+	case "struct":
+		val, err = base64.StdEncoding.DecodeString(rec.Value)
+		if err != nil {
+			return false, fmt.Errorf("import of %s failed: %w", sit.fullKey, err)
+		}
+		vtype = STRUCT_TYPE
+	case "point":
+		var v float64
+		v, err = strconv.ParseFloat(rec.Value, 64)
+		if err != nil {
+			return false, fmt.Errorf("import of %s failed: %w", rec.Key, err)
+		}
+		val = encodePoint(v)
+		vtype = POINT_TYPE
+	case "rollup":
+		val, err = base64.StdEncoding.DecodeString(rec.Value)
+		if err == nil {
+			_, err = decodeRollup(val)
+		}
+		if err != nil {
+			return false, fmt.Errorf("import of %s failed: %w", rec.Key, err)
+		}
+		vtype = ROLLUP_TYPE
+	default:
+		return false, fmt.Errorf("import of %s failed: unknown type %s", sit.fullKey, rec.Type)
+	}
+	if rec.Locked {
+		vtype = vtype | 0x80
+	}
+	err = sit.writeValue(val, vtype)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,192 @@
+package sett_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"math"
+	"path/filepath"
+	"strings"
+	"syreclabs.com/go/faker"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	gob.Register(&Signup{})
+	s := setttest.New(t)
+
+	table := faker.RandomString(8)
+	var su Signup
+	su.Name = faker.Name().Name()
+	su.Email = faker.Internet().SafeEmail()
+	sk, err := s.Table(table).Insert(&su)
+	if err != nil {
+		t.Fatalf("Error inserting struct %v", err)
+	}
+	err = s.Table(table).WithTTL(1*time.Hour).SetStr("session", "abc")
+	if err != nil {
+		t.Fatalf("Error setting string %v", err)
+	}
+	err = s.Table(table).Lock(sk)
+	if err != nil {
+		t.Fatalf("Error locking %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := s.ExportTable(&buf, table)
+	if err != nil || n != 2 {
+		t.Fatalf("Export failed, exported %d, %v", n, err)
+	}
+	data := buf.Bytes()
+
+	err = s.Table(table).Drop()
+	if err != nil {
+		t.Fatalf("Drop failed %v", err)
+	}
+	err = s.Table(table).SetStr("session", "other")
+	if err != nil {
+		t.Fatalf("Error setting string %v", err)
+	}
+
+	n, err = s.ImportTable(bytes.NewReader(data), sett.ImportFail)
+	if !errors.Is(err, sett.ErrImportConflict) {
+		t.Errorf("Expected import conflict, got %v", err)
+	}
+
+	n, err = s.ImportTable(bytes.NewReader(data), sett.ImportSkip)
+	if err != nil || n != 1 {
+		t.Fatalf("Import with skip failed, imported %d, %v", n, err)
+	}
+	v, _ := s.Table(table).GetStr("session")
+	if v != "other" {
+		t.Errorf("Import with skip replaced the existing value")
+	}
+
+	n, err = s.ImportTable(bytes.NewReader(data), sett.ImportOverwrite)
+	if err != nil || n != 2 {
+		t.Fatalf("Import with overwrite failed, imported %d, %v", n, err)
+	}
+	v, _ = s.Table(table).GetStr("session")
+	if v != "abc" {
+		t.Errorf("Import with overwrite didn't replace the existing value")
+	}
+	sur, err := s.Table(table).GetStruct(sk)
+	if err != nil {
+		t.Fatalf("Error getting imported struct %v", err)
+	}
+	if sur.(*Signup).Email != su.Email {
+		t.Errorf("The imported value does not match")
+	}
+	if s.Table(table).Lock(sk) == nil {
+		t.Errorf("The lock was not imported")
+	}
+}
+
+func TestExportImportTimeSeries(t *testing.T) {
+	s := setttest.New(t)
+	metrics := s.Table("metrics").TimeSeries()
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	metrics.Add("dev1", base, 1.5)
+	metrics.Add("dev1", base.Add(30*time.Second), -2)
+	metrics.Add("dev1", time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC), 7)
+	_, err := metrics.Rollup(time.Minute, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Rollup failed %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := s.ExportTable(&buf, "")
+	if err != nil || n != 4 {
+		t.Fatalf("Export failed, exported %d, %v", n, err)
+	}
+	data := buf.Bytes()
+	s.Table("metrics").Drop()
+	s.Table("metrics_1m").Drop()
+
+	n, err = s.ImportTable(bytes.NewReader(data), sett.ImportFail)
+	if err != nil || n != 4 {
+		t.Fatalf("Import failed, imported %d, %v", n, err)
+	}
+	points, err := metrics.Range("dev1", time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), base.Add(time.Hour))
+	if err != nil || len(points) != 3 || points[0].Value != 7 || !points[2].Time.Equal(base.Add(30*time.Second)) || points[2].Value != -2 {
+		t.Errorf("Unexpected points after import %v %v", points, err)
+	}
+	rollups, err := metrics.Rollups("dev1", time.Minute, base, base.Add(time.Hour))
+	if err != nil || len(rollups) != 1 || rollups[0].Count != 2 || rollups[0].Sum != -0.5 {
+		t.Errorf("Unexpected rollups after import %+v %v", rollups, err)
+	}
+}
+
+func TestExportStoreEncrypted(t *testing.T) {
+	s := setttest.New(t)
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	writeKeyFile(t, keyFile, "k1 "+testKey1+"\n")
+	kp, err := sett.NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatalf("Couldn't load keys %v", err)
+	}
+	s.Table("secret").WithEncryption(kp).SetStr("k", "hidden")
+	s.Table("plain").SetStr("k", "open")
+
+	var buf bytes.Buffer
+	n, err := s.ExportTable(&buf, "")
+	if err != nil || n != 2 {
+		t.Fatalf("Export of the store failed, exported %d, %v", n, err)
+	}
+	if !strings.Contains(buf.String(), `"table":"secret","key":"k"`) {
+		t.Errorf("Expected the item of the encrypted table exported in its table\n%s", buf.String())
+	}
+	data := buf.Bytes()
+	s.Table("secret").Drop()
+	s.Table("plain").Drop()
+
+	n, err = s.ImportTable(bytes.NewReader(data), sett.ImportFail)
+	if err != nil || n != 2 {
+		t.Fatalf("Import failed, imported %d, %v", n, err)
+	}
+	for table, want := range map[string]string{"secret": "hidden", "plain": "open"} {
+		v, err := s.Table(table).GetStr("k")
+		if err != nil || v != want {
+			t.Errorf("Expected %q in %s, got %q %v", want, table, v, err)
+		}
+	}
+	// imported encrypted again
+	_, err = s.Table("secret").WithEncryption(nil).GetStr("k")
+	if err == nil {
+		t.Errorf("Expected the imported value to be encrypted")
+	}
+}
+
+func TestExportNaN(t *testing.T) {
+	gob.Register(&OrderObj{})
+	s := setttest.New(t)
+	orders := s.Table("orders")
+	orders.SetStruct("nan", &OrderObj{Status: "open", Amount: math.NaN()})
+	orders.SetStruct("inf", &OrderObj{Status: "open", Amount: math.Inf(1)})
+	orders.SetStruct("ok", &OrderObj{Status: "open", Amount: 10})
+
+	var buf bytes.Buffer
+	n, err := s.ExportTable(&buf, "orders")
+	if err != nil || n != 3 {
+		t.Fatalf("Export failed, exported %d, %v", n, err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		hasData := strings.Contains(line, `"data"`)
+		if strings.Contains(line, `"key":"ok"`) != hasData {
+			t.Errorf("Expected data only for the finite values, got %s", line)
+		}
+	}
+
+	other := setttest.New(t)
+	n, err = other.ImportTable(&buf, sett.ImportFail)
+	if err != nil || n != 3 {
+		t.Fatalf("Import failed, imported %d, %v", n, err)
+	}
+	v, err := other.Table("orders").GetStruct("nan")
+	if err != nil || !math.IsNaN(v.(*OrderObj).Amount) {
+		t.Errorf("Expected the NaN value imported, got %v %v", v, err)
+	}
+}
@@ -0,0 +1,690 @@
+package sett
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TextIndexOptions configures the text index of a table
+type TextIndexOptions struct {
+	// Fields are the string fields of the struct values which are indexed,
+	// or paths like "Author.Name". When empty, all the string fields of the
+	// structs are. String values are always indexed as they are
+	Fields []string
+	// StopWords are left out of the index, DefaultStopWords if nil
+	StopWords []string
+	// NoStemming indexes the words as they are, instead of their
+	// English stems, so that searching "run" doesn't find "running"
+	NoStemming bool
+}
+
+// SearchResult is a value found by Search
+type SearchResult struct {
+	Key string
+	// Score is the BM25 relevance of the value for the query
+	Score float64
+	Value interface{}
+}
+
+var (
+	textPostingsPrefix = []byte("fts:p:")
+	textLengthsPrefix  = []byte("fts:d:")
+	textStatsPrefix    = []byte("fts:n:")
+	textIndexMarker    = []byte("fts:m:")
+)
+
+// textStatsSeq tells apart the changes of the counts made in the same nanosecond
+var textStatsSeq uint64
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// fieldGap separates the positions of the words of
+	// different fields, so that phrases don't span fields
+	fieldGap = 100
+	// textStatsFoldSize is the number of changes of the counts
+	// which are added up into the counts at once
+	textStatsFoldSize = 1000
+)
+
+// textIndex is an inverted index of the words of the values of a table.
+// The positions of each term in a value are under
+// textPostingsPrefix | len(table) (2 bytes) | table | len(term) (2 bytes) | term | key
+// and the number of terms of each value, which BM25 needs, under
+// textLengthsPrefix | len(table) (2 bytes) | table | key
+// BM25 also needs the number of values and their total number of terms.
+// They are kept under textStatsPrefix | len(table) (2 bytes) | table,
+// and each write adds the change to the counts under the same key
+// followed by the time and a sequence number, instead of reading and
+// writing the counts, which would make the writes of the table conflict.
+// The changes of values which expire expire with them, so the counts
+// drop when the values do. The other changes are added up into the
+// counts in the background once there are many of them
+type textIndex struct {
+	// pending is the number of changes written since the last fold
+	pending int64
+	// folding is 1 while a fold runs in the background
+	folding  int32
+	foldMu   sync.Mutex
+	table    string
+	fields   []string
+	analyzer *analyzer
+	db       Backend
+	shared   *shared
+}
+
+func (ti *textIndex) postingsPrefix(term string) []byte {
+	return systemKey(textPostingsPrefix, lengthPrefixed([]byte(ti.table)), lengthPrefixed([]byte(term)))
+}
+
+func (ti *textIndex) lengthsPrefix() []byte {
+	return systemKey(textLengthsPrefix, lengthPrefixed([]byte(ti.table)))
+}
+
+func (ti *textIndex) statsKey() []byte {
+	return systemKey(textStatsPrefix, lengthPrefixed([]byte(ti.table)))
+}
+
+func (ti *textIndex) marker() []byte {
+	return systemKey(textIndexMarker, lengthPrefixed([]byte(ti.table)))
+}
+
+// texts returns the texts of the value which are indexed
+func (ti *textIndex) texts(v interface{}) []string {
+	if str, ok := v.(string); ok {
+		return []string{str}
+	}
+	if len(ti.fields) > 0 {
+		var texts []string
+		for _, f := range ti.fields {
+			if fv, ok := fieldValue(v, f); ok {
+				if str, ok := fv.(string); ok {
+					texts = append(texts, str)
+				}
+			}
+		}
+		return texts
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	var texts []string
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Field(i)
+		if f.Kind() == reflect.String && f.CanInterface() {
+			texts = append(texts, f.String())
+		}
+	}
+	return texts
+}
+
+// terms returns the positions of each term of the value,
+// and the number of terms
+func (ti *textIndex) terms(v interface{}) (map[string][]int, int) {
+	terms := make(map[string][]int)
+	n := 0
+	base := 0
+	for _, text := range ti.texts(v) {
+		tokens := ti.analyzer.tokens(text, base)
+		for _, t := range tokens {
+			terms[t.term] = append(terms[t.term], t.pos)
+		}
+		n += len(tokens)
+		if len(tokens) > 0 {
+			base = tokens[len(tokens)-1].pos + fieldGap
+		}
+	}
+	return terms, n
+}
+
+func (ti *textIndex) update(txn Txn, key string, old interface{}, val interface{}, expiresAt uint64) error {
+	lengthKey := append(ti.lengthsPrefix(), key...)
+	var docs, total int64
+	var oldExpiresAt uint64
+	if old != nil {
+		terms, _ := ti.terms(old)
+		for term := range terms {
+			err := txn.Delete(append(ti.postingsPrefix(term), key...))
+			if err != nil {
+				return err
+			}
+		}
+		n, exp, err := readLength(txn, lengthKey)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			docs, total, oldExpiresAt = -1, -int64(n), exp
+			err = txn.Delete(lengthKey)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if val != nil {
+		terms, n := ti.terms(val)
+		for term, positions := range terms {
+			err := txn.Set(&Entry{Key: append(ti.postingsPrefix(term), key...), Value: encodePositions(positions), ExpiresAt: expiresAt})
+			if err != nil {
+				return err
+			}
+		}
+		if n > 0 {
+			if docs != 0 && oldExpiresAt != expiresAt {
+				// The removal of the old value expires when it would have
+				err := ti.addStats(txn, docs, total, oldExpiresAt)
+				if err != nil {
+					return err
+				}
+				docs, total = 0, 0
+			}
+			length := binary.AppendUvarint(nil, uint64(n))
+			err := txn.Set(&Entry{Key: lengthKey, Value: length, ExpiresAt: expiresAt})
+			if err != nil {
+				return err
+			}
+			docs, total = docs+1, total+int64(n)
+			return ti.addStats(txn, docs, total, expiresAt)
+		}
+	}
+	return ti.addStats(txn, docs, total, oldExpiresAt)
+}
+
+// addStats writes a change of the counts, which expires at expiresAt
+func (ti *textIndex) addStats(txn Txn, docs, total int64, expiresAt uint64) error {
+	if docs == 0 && total == 0 {
+		return nil
+	}
+	change := make([]byte, 16)
+	binary.BigEndian.PutUint64(change, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(change[8:], atomic.AddUint64(&textStatsSeq, 1))
+	err := txn.Set(&Entry{Key: append(ti.statsKey(), change...), Value: encodeStats(docs, total), ExpiresAt: expiresAt})
+	if err != nil || expiresAt > 0 {
+		return err
+	}
+	ti.foldLater()
+	return nil
+}
+
+// foldLater folds the changes in the background once there are many of them
+func (ti *textIndex) foldLater() {
+	if ti.shared == nil || atomic.AddInt64(&ti.pending, 1) < textStatsFoldSize {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&ti.folding, 0, 1) {
+		return
+	}
+	started := ti.shared.goBackground(func() {
+		defer atomic.StoreInt32(&ti.folding, 0)
+		atomic.StoreInt64(&ti.pending, 0)
+		// Best effort, the next writes or searches try again on an error
+		ti.foldStats(ti.db)
+	})
+	if !started {
+		atomic.StoreInt32(&ti.folding, 0)
+	}
+}
+
+// readLength returns the number of terms of the value, 0 if it is
+// not indexed, and when it expires
+func readLength(txn Txn, lengthKey []byte) (int, uint64, error) {
+	item, err := currentItem(txn, lengthKey)
+	if err != nil || item == nil {
+		return 0, 0, err
+	}
+	var n uint64
+	err = item.Value(func(val []byte) error {
+		var read int
+		n, read = binary.Uvarint(val)
+		if read <= 0 {
+			return errors.New("Invalid text index entry")
+		}
+		return nil
+	})
+	return int(n), item.ExpiresAt(), err
+}
+
+func encodeStats(docs, total int64) []byte {
+	return binary.AppendVarint(binary.AppendVarint(nil, docs), total)
+}
+
+func decodeStats(b []byte) (int64, int64, error) {
+	docs, n := binary.Varint(b)
+	if n <= 0 {
+		return 0, 0, errors.New("Invalid text index counts")
+	}
+	total, m := binary.Varint(b[n:])
+	if m <= 0 {
+		return 0, 0, errors.New("Invalid text index counts")
+	}
+	return docs, total, nil
+}
+
+// readStats returns the number of values indexed and their total number
+// of terms, along with the number of changes which can be added up
+func (ti *textIndex) readStats(txn Txn) (docs int64, total int64, changes int, err error) {
+	prefix := ti.statsKey()
+	it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		err = item.Value(func(val []byte) error {
+			d, t, err := decodeStats(val)
+			docs, total = docs+d, total+t
+			return err
+		})
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if len(item.Key()) > len(prefix) && item.ExpiresAt() == 0 {
+			changes++
+		}
+	}
+	return docs, total, changes, nil
+}
+
+// foldStats adds up the changes into the counts, textStatsFoldSize at a time.
+// The changes which expire are left as they are
+func (ti *textIndex) foldStats(db Backend) error {
+	ti.foldMu.Lock()
+	defer ti.foldMu.Unlock()
+	prefix := ti.statsKey()
+	for {
+		folded := 0
+		err := db.Update(func(txn Txn) error {
+			var docs, total int64
+			var changes [][]byte
+			it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+			for it.Seek(prefix); it.Valid() && len(changes) < textStatsFoldSize; it.Next() {
+				item := it.Item()
+				if item.ExpiresAt() > 0 {
+					continue
+				}
+				err := item.Value(func(val []byte) error {
+					d, t, err := decodeStats(val)
+					docs, total = docs+d, total+t
+					return err
+				})
+				if err != nil {
+					it.Close()
+					return err
+				}
+				if len(item.Key()) > len(prefix) {
+					changes = append(changes, item.KeyCopy(nil))
+				}
+			}
+			it.Close()
+			for _, k := range changes {
+				err := txn.Delete(k)
+				if err != nil {
+					return err
+				}
+			}
+			folded = len(changes)
+			return txn.Set(&Entry{Key: prefix, Value: encodeStats(docs, total)})
+		})
+		if err != nil || folded < textStatsFoldSize {
+			return err
+		}
+	}
+}
+
+func encodePositions(positions []int) []byte {
+	b := make([]byte, 0, len(positions)*2)
+	prev := 0
+	for _, p := range positions {
+		b = binary.AppendUvarint(b, uint64(p-prev))
+		prev = p
+	}
+	return b
+}
+
+func decodePositions(b []byte) ([]int, error) {
+	var positions []int
+	prev := 0
+	for len(b) > 0 {
+		d, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("Invalid text index entry")
+		}
+		prev += int(d)
+		positions = append(positions, prev)
+		b = b[n:]
+	}
+	return positions, nil
+}
+
+// CreateTextIndex indexes the words of the values of the table, so that
+// they can be found with Search. The index is kept up to date on every
+// write, and built from the values already in the table the first time.
+// As with WithEncryption, call it each time the store is opened.
+// Changing the options of an existing index needs DropTextIndex first
+func (s *Sett) CreateTextIndex(opts TextIndexOptions) error {
+	stopWords := opts.StopWords
+	if stopWords == nil {
+		stopWords = DefaultStopWords
+	}
+	ti := &textIndex{
+		table:    s.table,
+		fields:   opts.Fields,
+		analyzer: newAnalyzer(stopWords, !opts.NoStemming),
+		db:       s.db,
+		shared:   s.shared,
+	}
+	s.addIndex("text", ti)
+	err := s.buildIndex(nil, ti, ti.marker())
+	if err != nil {
+		return err
+	}
+	return ti.foldStats(s.db)
+}
+
+// DropTextIndex stops indexing the words of the table and removes the index
+func (s *Sett) DropTextIndex() error {
+	ti := &textIndex{table: s.table}
+	s.removeIndex("text")
+	return ti.clear(s)
+}
+
+// RebuildTextIndex builds the text index again from the values of the
+// table, as RebuildIndex does. Needs CreateTextIndex
+func (s *Sett) RebuildTextIndex() error {
+	return s.RebuildTextIndexCtx(context.Background())
+}
+
+// RebuildTextIndexCtx is RebuildTextIndex with a context
+func (s *Sett) RebuildTextIndexCtx(ctx context.Context) error {
+	ti, ok := s.options().indexes["text"].(*textIndex)
+	if !ok {
+		return errors.New("RebuildTextIndex needs a text index, see CreateTextIndex")
+	}
+	return s.do(ctx, OpRebuild, "", nil, func(c *opCall) error {
+		err := ti.clear(s)
+		if err != nil {
+			return err
+		}
+		err = s.buildIndex(c, ti, ti.marker())
+		if err != nil {
+			return err
+		}
+		return ti.foldStats(s.db)
+	})
+}
+
+// clear removes the postings, lengths and counts of the index
+func (ti *textIndex) clear(s *Sett) error {
+	return s.clearIndex(ti.marker(),
+		systemKey(textPostingsPrefix, lengthPrefixed([]byte(ti.table))),
+		ti.lengthsPrefix(),
+		ti.statsKey(),
+	)
+}
+
+// searchTerm is a word or a phrase of a query
+type searchTerm struct {
+	tokens []token
+}
+
+// parseQuery splits the query into clauses separated by OR, each
+// matching the values with all of its words and "quoted phrases"
+func (ti *textIndex) parseQuery(query string) [][]searchTerm {
+	var clauses [][]searchTerm
+	var clause []searchTerm
+	endClause := func() {
+		if len(clause) > 0 {
+			clauses = append(clauses, clause)
+		}
+		clause = nil
+	}
+	rest := query
+	for len(rest) > 0 {
+		rest = strings.TrimLeft(rest, " \t\n")
+		if len(rest) == 0 {
+			break
+		}
+		var part string
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				part, rest = rest[1:], ""
+			} else {
+				part, rest = rest[1:end+1], rest[end+2:]
+			}
+			if tokens := ti.analyzer.tokens(part, 0); len(tokens) > 0 {
+				clause = append(clause, searchTerm{tokens: tokens})
+			}
+			continue
+		}
+		end := strings.IndexAny(rest, " \t\n\"")
+		if end < 0 {
+			part, rest = rest, ""
+		} else {
+			part, rest = rest[:end], rest[end:]
+		}
+		if part == "OR" {
+			endClause()
+			continue
+		}
+		if part == "AND" {
+			continue
+		}
+		for _, t := range ti.analyzer.tokens(part, 0) {
+			clause = append(clause, searchTerm{tokens: []token{{term: t.term}}})
+		}
+	}
+	endClause()
+	return clauses
+}
+
+// Search returns up to limit values of the table matching the query,
+// the most relevant first. Words of the query must all be in the value,
+// "quoted phrases" must be there in the same order, and OR separates
+// alternatives:
+//
+//	notes.Search(`"meeting notes" budget OR forecast`, 10)
+//
+// finds the values with both the phrase and budget, or with forecast.
+// A limit of 0 returns all of them. Needs CreateTextIndex
+func (s *Sett) Search(query string, limit int) ([]SearchResult, error) {
+	return s.SearchCtx(context.Background(), query, limit)
+}
+
+// SearchCtx is Search with a context
+func (s *Sett) SearchCtx(ctx context.Context, query string, limit int) (results []SearchResult, err error) {
+	ti, ok := s.options().indexes["text"].(*textIndex)
+	if !ok {
+		return nil, errors.New("Search needs a text index, see CreateTextIndex")
+	}
+	err = s.do(ctx, OpSearch, "", query, func(c *opCall) error {
+		query, ok := c.value.(string)
+		if !ok {
+			return errors.New("Search needs a string query")
+		}
+		changes := 0
+		err := s.db.View(func(txn Txn) error {
+			var err error
+			results, changes, err = s.search(c, txn, ti, query, limit)
+			c.result = results
+			return err
+		})
+		if err == nil && changes >= textStatsFoldSize {
+			// Best effort, the next search tries again on a conflict
+			ti.foldStats(s.db)
+		}
+		return err
+	})
+	return results, err
+}
+
+// posting is the positions of a term in a value
+type posting map[string][]int
+
+// search returns the results of the query, and the number
+// of changes of the counts which are not added up yet
+func (s *Sett) search(c *opCall, txn Txn, ti *textIndex, query string, limit int) ([]SearchResult, int, error) {
+	clauses := ti.parseQuery(query)
+	if len(clauses) == 0 {
+		return nil, 0, nil
+	}
+	postings := make(map[string]posting)
+	for _, clause := range clauses {
+		for _, st := range clause {
+			for _, t := range st.tokens {
+				if _, ok := postings[t.term]; ok {
+					continue
+				}
+				p, err := ti.readPostings(txn, t.term)
+				if err != nil {
+					return nil, 0, err
+				}
+				postings[t.term] = p
+			}
+		}
+	}
+
+	matches := make(map[string]bool)
+	for _, clause := range clauses {
+		for key := range ti.matchClause(clause, postings) {
+			matches[key] = true
+		}
+	}
+	if len(matches) == 0 {
+		return nil, 0, nil
+	}
+
+	docs, total, changes, err := ti.readStats(txn)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := float64(docs)
+	avgLength := float64(total) / math.Max(n, 1)
+	var results []SearchResult
+	for key := range matches {
+		if err := c.canceled(); err != nil {
+			return nil, 0, err
+		}
+		length, _, err := readLength(txn, append(ti.lengthsPrefix(), key...))
+		if err != nil {
+			return nil, 0, err
+		}
+		dl := float64(length)
+		score := 0.0
+		for _, p := range postings {
+			positions, ok := p[key]
+			if !ok {
+				continue
+			}
+			df := float64(len(p))
+			idf := math.Log(1 + (math.Max(n, df)-df+0.5)/(df+0.5))
+			tf := float64(len(positions))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*dl/avgLength))
+		}
+		results = append(results, SearchResult{Key: key, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Key < results[j].Key
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	found := results[:0]
+	for _, r := range results {
+		v, ok, err := s.indexedValue(c, txn, r.Key)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			r.Value = v
+			found = append(found, r)
+		}
+	}
+	return found, changes, nil
+}
+
+func (ti *textIndex) readPostings(txn Txn, term string) (posting, error) {
+	prefix := ti.postingsPrefix(term)
+	p := make(posting)
+	it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key()[len(prefix):])
+		err := item.Value(func(val []byte) error {
+			positions, err := decodePositions(val)
+			p[key] = positions
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// matchClause returns the keys of the values having all the words and phrases of the clause
+func (ti *textIndex) matchClause(clause []searchTerm, postings map[string]posting) map[string]bool {
+	var keys map[string]bool
+	for _, st := range clause {
+		found := make(map[string]bool)
+		for key, positions := range postings[st.tokens[0].term] {
+			if keys != nil && !keys[key] {
+				continue
+			}
+			if phraseAt(st.tokens, key, positions, postings) {
+				found[key] = true
+			}
+		}
+		keys = found
+		if len(keys) == 0 {
+			break
+		}
+	}
+	return keys
+}
+
+// phraseAt tells if the tokens are in the value of the key at the same
+// distances as in the phrase, starting from one of the positions
+func phraseAt(tokens []token, key string, positions []int, postings map[string]posting) bool {
+	if len(tokens) == 1 {
+		return true
+	}
+	for _, start := range positions {
+		match := true
+		for _, t := range tokens[1:] {
+			want := start + t.pos - tokens[0].pos
+			if !containsInt(postings[t.term][key], want) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(sorted []int, v int) bool {
+	i := sort.SearchInts(sorted, v)
+	return i < len(sorted) && sorted[i] == v
+}
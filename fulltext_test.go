@@ -0,0 +1,223 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type NoteObj struct {
+	Title string
+	Body  string
+	Tag   string
+}
+
+func TestSearch(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testSearch(t, b.open(t))
+		})
+	}
+}
+
+func searchKeys(t *testing.T, s *sett.Sett, query string) []string {
+	results, err := s.Search(query, 0)
+	if err != nil {
+		t.Fatalf("Search %q failed %v", query, err)
+	}
+	keys := []string{}
+	for _, r := range results {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+func testSearch(t *testing.T, s *sett.Sett) {
+	gob.Register(&NoteObj{})
+	notes := s.Table("notes")
+	notes.SetStruct("n1", &NoteObj{Title: "Budget meeting", Body: "The meeting notes about the budget forecast", Tag: "finance"})
+	notes.SetStruct("n2", &NoteObj{Title: "Running notes", Body: "Notes from the morning runs in the park", Tag: "sport"})
+	notes.SetStruct("n3", &NoteObj{Title: "Forecast", Body: "Weather forecast for the weekend: rain", Tag: "weather"})
+	notes.SetStr("n4", "meeting with the notes of the budget")
+
+	_, err := notes.Search("budget", 10)
+	if err == nil {
+		t.Errorf("Expected an error without a text index")
+	}
+	err = notes.CreateTextIndex(sett.TextIndexOptions{Fields: []string{"Title", "Body"}})
+	if err != nil {
+		t.Fatalf("CreateTextIndex failed %v", err)
+	}
+
+	if keys := searchKeys(t, notes, "budget meeting"); !reflect.DeepEqual(keys, []string{"n1", "n4"}) {
+		t.Errorf("Expected n1 and n4 for the words, got %v", keys)
+	}
+	if keys := searchKeys(t, notes, `"meeting notes"`); !reflect.DeepEqual(keys, []string{"n1"}) {
+		t.Errorf("Expected only n1 for the phrase, got %v", keys)
+	}
+	// "Budget meeting" and "The meeting notes" are different fields
+	if keys := searchKeys(t, notes, `"budget meeting notes"`); len(keys) != 0 {
+		t.Errorf("Expected no phrase across fields, got %v", keys)
+	}
+	if keys := searchKeys(t, notes, "rain OR park"); !reflect.DeepEqual(keys, []string{"n2", "n3"}) && !reflect.DeepEqual(keys, []string{"n3", "n2"}) {
+		t.Errorf("Expected n2 and n3 for OR, got %v", keys)
+	}
+	if keys := searchKeys(t, notes, "run"); !reflect.DeepEqual(keys, []string{"n2"}) {
+		t.Errorf("Expected the stem of running to match, got %v", keys)
+	}
+	if keys := searchKeys(t, notes, "finance"); len(keys) != 0 {
+		t.Errorf("Expected the Tag field not to be indexed, got %v", keys)
+	}
+	if keys := searchKeys(t, notes, "the"); len(keys) != 0 {
+		t.Errorf("Expected no stop words in the index, got %v", keys)
+	}
+
+	// n3 has forecast in both fields, n1 only in one
+	results, err := notes.Search("forecast", 1)
+	if err != nil || len(results) != 1 || results[0].Key != "n3" || results[0].Score <= 0 {
+		t.Fatalf("Expected n3 as the best match, got %+v %v", results, err)
+	}
+	if results[0].Value.(*NoteObj).Title != "Forecast" {
+		t.Errorf("Unexpected value of the result %+v", results[0].Value)
+	}
+
+	notes.SetStruct("n3", &NoteObj{Title: "Sunny", Body: "No more rain"})
+	if keys := searchKeys(t, notes, "forecast"); !reflect.DeepEqual(keys, []string{"n1"}) {
+		t.Errorf("Expected the index to follow the update, got %v", keys)
+	}
+	notes.Delete("n1")
+	if keys := searchKeys(t, notes, "budget"); !reflect.DeepEqual(keys, []string{"n4"}) {
+		t.Errorf("Expected the index to follow the delete, got %v", keys)
+	}
+	// another table is not searched
+	others := s.Table("others")
+	others.SetStr("o1", "budget")
+	others.CreateTextIndex(sett.TextIndexOptions{})
+	if keys := searchKeys(t, notes, "budget"); !reflect.DeepEqual(keys, []string{"n4"}) {
+		t.Errorf("Expected only the notes, got %v", keys)
+	}
+
+	allKeys, _ := s.Keys()
+	for _, k := range allKeys {
+		if k != "" && k[0] == 0xff {
+			t.Errorf("Text index entries listed as keys")
+		}
+	}
+
+	err = notes.DropTextIndex()
+	if err != nil {
+		t.Fatalf("DropTextIndex failed %v", err)
+	}
+	_, err = notes.Search("budget", 10)
+	if err == nil {
+		t.Errorf("Expected an error after dropping the index")
+	}
+	// rebuilt from the values
+	notes.CreateTextIndex(sett.TextIndexOptions{NoStemming: true})
+	if keys := searchKeys(t, notes, "run"); len(keys) != 0 {
+		t.Errorf("Expected no stemming, got %v", keys)
+	}
+	if keys := searchKeys(t, notes, "running"); !reflect.DeepEqual(keys, []string{"n2"}) {
+		t.Errorf("Expected the word as it is, got %v", keys)
+	}
+}
+
+func TestSearchCounts(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			// written, updated and deleted many times, with the index
+			changed := s.Table("changed")
+			changed.CreateTextIndex(sett.TextIndexOptions{})
+			for round := 0; round < 3; round++ {
+				for i := 0; i < 600; i++ {
+					changed.SetStr(fmt.Sprintf("n%03d", i), fmt.Sprintf("note %d round %d about apples", i, round))
+				}
+			}
+			for i := 0; i < 600; i += 2 {
+				changed.Delete(fmt.Sprintf("n%03d", i))
+			}
+			// the same values written once, before the index
+			fresh := s.Table("fresh")
+			for i := 1; i < 600; i += 2 {
+				fresh.SetStr(fmt.Sprintf("n%03d", i), fmt.Sprintf("note %d round %d about apples", i, 2))
+			}
+			fresh.CreateTextIndex(sett.TextIndexOptions{})
+
+			for _, query := range []string{"apples", "note 7", "round"} {
+				want, err := fresh.Search(query, 5)
+				if err != nil {
+					t.Fatalf("Search failed %v", err)
+				}
+				// the second search adds up the changes of the counts
+				for i := 0; i < 2; i++ {
+					got, err := changed.Search(query, 5)
+					if err != nil || len(got) != len(want) {
+						t.Fatalf("Expected %d results, got %v %v", len(want), got, err)
+					}
+					for j := range want {
+						if got[j].Key != want[j].Key || math.Abs(got[j].Score-want[j].Score) > 1e-9 {
+							t.Errorf("%q: expected %s %f, got %s %f", query, want[j].Key, want[j].Score, got[j].Key, got[j].Score)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSearchCountsExpiry(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			expiry := time.Now().Add(2 * time.Second)
+			// the even values expire, but for the ones persisted
+			expiring := s.Table("expiring")
+			expiring.CreateTextIndex(sett.TextIndexOptions{})
+			for i := 0; i < 40; i++ {
+				val := fmt.Sprintf("note %d about apples", i)
+				if i%2 == 0 {
+					expiring.SetStr(fmt.Sprintf("n%03d", i), val+" and pears", sett.ExpireIn(time.Hour))
+					expiring.SetStr(fmt.Sprintf("n%03d", i), val, sett.ExpireAt(expiry))
+				} else {
+					expiring.SetStr(fmt.Sprintf("n%03d", i), val)
+				}
+			}
+			for i := 0; i < 40; i += 8 {
+				expiring.Persist(fmt.Sprintf("n%03d", i))
+			}
+			// the values left, written once
+			fresh := s.Table("fresh")
+			fresh.CreateTextIndex(sett.TextIndexOptions{})
+			for i := 0; i < 40; i++ {
+				if i%2 == 1 || i%8 == 0 {
+					fresh.SetStr(fmt.Sprintf("n%03d", i), fmt.Sprintf("note %d about apples", i))
+				}
+			}
+			time.Sleep(time.Until(expiry) + 1100*time.Millisecond)
+
+			for _, query := range []string{"apples", "note 8", "note 7"} {
+				want, err := fresh.Search(query, 5)
+				if err != nil {
+					t.Fatalf("Search failed %v", err)
+				}
+				got, err := expiring.Search(query, 5)
+				if err != nil || len(got) != len(want) {
+					t.Fatalf("Expected %d results, got %v %v", len(want), got, err)
+				}
+				for j := range want {
+					if got[j].Key != want[j].Key || math.Abs(got[j].Score-want[j].Score) > 1e-9 {
+						t.Errorf("%q: expected %s %f, got %s %f", query, want[j].Key, want[j].Score, got[j].Key, got[j].Score)
+					}
+				}
+			}
+		})
+	}
+}
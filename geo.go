@@ -0,0 +1,376 @@
+package sett
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// GeoFunc returns the location of a value in degrees,
+// ok false when the value has none
+type GeoFunc func(k string, v interface{}) (lat, lon float64, ok bool)
+
+// GeoResult is a value found by Nearby or WithinBox
+type GeoResult struct {
+	Key string
+	Lat float64
+	Lon float64
+	// Distance is in meters, from the center of the search
+	Distance float64
+	Value    interface{}
+}
+
+var (
+	geoIndexPrefix = []byte("geo:")
+	geoIndexMarker = []byte("geom:")
+)
+
+const (
+	// earthRadius is the mean radius of the Earth in meters
+	earthRadius  = 6371008.8
+	geoPrecision = 12
+	// maxGeoCells limits the number of geohash cells a search reads
+	maxGeoCells = 32
+	geohashBase = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// geoIndex orders the keys of a table by the geohash of their location.
+// The entries are under
+// geoIndexPrefix | len(table) (2 bytes) | table | geohash (12 bytes) | key
+// with the latitude and longitude as the value of the entry
+type geoIndex struct {
+	table string
+	fn    GeoFunc
+}
+
+func (gi *geoIndex) prefix() []byte {
+	return systemKey(geoIndexPrefix, lengthPrefixed([]byte(gi.table)))
+}
+
+func (gi *geoIndex) marker() []byte {
+	return systemKey(geoIndexMarker, lengthPrefixed([]byte(gi.table)))
+}
+
+func (gi *geoIndex) entry(key string, v interface{}) ([]byte, []byte, bool) {
+	lat, lon, ok := gi.fn(key, v)
+	if !ok || !validLocation(lat, lon) {
+		return nil, nil, false
+	}
+	k := append(gi.prefix(), geohash(lat, lon, geoPrecision)...)
+	k = append(k, key...)
+	val := make([]byte, 16)
+	binary.BigEndian.PutUint64(val, math.Float64bits(lat))
+	binary.BigEndian.PutUint64(val[8:], math.Float64bits(lon))
+	return k, val, true
+}
+
+func (gi *geoIndex) update(txn Txn, key string, old interface{}, val interface{}, expiresAt uint64) error {
+	if old != nil {
+		if k, _, ok := gi.entry(key, old); ok {
+			err := txn.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if val != nil {
+		if k, v, ok := gi.entry(key, val); ok {
+			return txn.Set(&Entry{Key: k, Value: v, ExpiresAt: expiresAt})
+		}
+	}
+	return nil
+}
+
+func validLocation(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// CreateGeoIndex indexes the values of the table by the location fn
+// returns for them, so that they can be found with Nearby and WithinBox.
+// The index is kept up to date on every write, and built from the values
+// already in the table the first time. As with WithEncryption, call it
+// each time the store is opened. Changing fn for an existing index
+// needs DropGeoIndex first
+func (s *Sett) CreateGeoIndex(fn GeoFunc) error {
+	if fn == nil {
+		return fmt.Errorf("CreateGeoIndex needs a GeoFunc")
+	}
+	gi := &geoIndex{table: s.table, fn: fn}
+	s.addIndex("geo", gi)
+	return s.buildIndex(nil, gi, gi.marker())
+}
+
+// DropGeoIndex stops indexing the locations of the table and removes the index
+func (s *Sett) DropGeoIndex() error {
+	gi := &geoIndex{table: s.table}
+	s.removeIndex("geo")
+	return s.clearIndex(gi.marker(), gi.prefix())
+}
+
+// RebuildGeoIndex builds the geo index again from the values of the table,
+// as RebuildIndex does. Needs CreateGeoIndex
+func (s *Sett) RebuildGeoIndex() error {
+	return s.RebuildGeoIndexCtx(context.Background())
+}
+
+// RebuildGeoIndexCtx is RebuildGeoIndex with a context
+func (s *Sett) RebuildGeoIndexCtx(ctx context.Context) error {
+	gi, ok := s.options().indexes["geo"].(*geoIndex)
+	if !ok {
+		return fmt.Errorf("RebuildGeoIndex needs a geo index, see CreateGeoIndex")
+	}
+	return s.do(ctx, OpRebuild, "", nil, func(c *opCall) error {
+		err := s.clearIndex(gi.marker(), gi.prefix())
+		if err != nil {
+			return err
+		}
+		return s.buildIndex(c, gi, gi.marker())
+	})
+}
+
+// Nearby returns up to limit values of the table within radius meters
+// of the location, the closest first. A limit of 0 returns all of them.
+// Needs CreateGeoIndex
+func (s *Sett) Nearby(lat, lon, radius float64, limit int) ([]GeoResult, error) {
+	return s.NearbyCtx(context.Background(), lat, lon, radius, limit)
+}
+
+// NearbyCtx is Nearby with a context
+func (s *Sett) NearbyCtx(ctx context.Context, lat, lon, radius float64, limit int) ([]GeoResult, error) {
+	if !validLocation(lat, lon) || radius < 0 {
+		return nil, fmt.Errorf("Nearby needs a valid location and radius")
+	}
+	dLat := radius / earthRadius * 180 / math.Pi
+	box := geoBox{minLat: lat - dLat, maxLat: lat + dLat, minLon: -180, maxLon: 180}
+	if box.minLat > -90 && box.maxLat < 90 {
+		// the widest part of the circle is on the side closer to a pole
+		widest := math.Max(math.Abs(box.minLat), math.Abs(box.maxLat))
+		dLon := dLat / math.Cos(widest*math.Pi/180)
+		if dLon < 180 {
+			box.minLon, box.maxLon = wrapLon(lon-dLon), wrapLon(lon+dLon)
+		}
+	}
+	box.minLat, box.maxLat = math.Max(box.minLat, -90), math.Min(box.maxLat, 90)
+	within := func(pLat, pLon float64) bool {
+		return distance(lat, lon, pLat, pLon) <= radius
+	}
+	return s.geoSearch(ctx, box, lat, lon, within, limit)
+}
+
+// WithinBox returns up to limit values of the table located in the box,
+// the closest to its center first. A box with minLon > maxLon crosses
+// the 180th meridian. A limit of 0 returns all of them.
+// Needs CreateGeoIndex
+func (s *Sett) WithinBox(minLat, minLon, maxLat, maxLon float64, limit int) ([]GeoResult, error) {
+	return s.WithinBoxCtx(context.Background(), minLat, minLon, maxLat, maxLon, limit)
+}
+
+// WithinBoxCtx is WithinBox with a context
+func (s *Sett) WithinBoxCtx(ctx context.Context, minLat, minLon, maxLat, maxLon float64, limit int) ([]GeoResult, error) {
+	if !validLocation(minLat, minLon) || !validLocation(maxLat, maxLon) || minLat > maxLat {
+		return nil, fmt.Errorf("WithinBox needs a valid box")
+	}
+	box := geoBox{minLat: minLat, minLon: minLon, maxLat: maxLat, maxLon: maxLon}
+	span := maxLon - minLon
+	if span < 0 {
+		span += 360
+	}
+	centerLat, centerLon := (minLat+maxLat)/2, wrapLon(minLon+span/2)
+	return s.geoSearch(ctx, box, centerLat, centerLon, box.contains, limit)
+}
+
+type geoBox struct {
+	minLat, minLon, maxLat, maxLon float64
+}
+
+func (b geoBox) contains(lat, lon float64) bool {
+	if lat < b.minLat || lat > b.maxLat {
+		return false
+	}
+	if b.minLon <= b.maxLon {
+		return lon >= b.minLon && lon <= b.maxLon
+	}
+	return lon >= b.minLon || lon <= b.maxLon
+}
+
+// split returns the box as boxes not crossing the 180th meridian
+func (b geoBox) split() []geoBox {
+	if b.minLon <= b.maxLon {
+		return []geoBox{b}
+	}
+	east, west := b, b
+	east.maxLon = 180
+	west.minLon = -180
+	return []geoBox{east, west}
+}
+
+func (s *Sett) geoSearch(ctx context.Context, box geoBox, lat, lon float64, within func(lat, lon float64) bool, limit int) (results []GeoResult, err error) {
+	gi, ok := s.options().indexes["geo"].(*geoIndex)
+	if !ok {
+		return nil, fmt.Errorf("The search needs a geo index, see CreateGeoIndex")
+	}
+	err = s.do(ctx, OpGeo, "", nil, func(c *opCall) error {
+		defer func() { c.result = results }()
+		return s.db.View(func(txn Txn) error {
+			prefix := gi.prefix()
+			for _, cell := range geoCells(box) {
+				cellPrefix := append(append([]byte{}, prefix...), cell...)
+				it := txn.NewIterator(IteratorOptions{Prefix: cellPrefix})
+				for it.Seek(cellPrefix); it.Valid(); it.Next() {
+					if err := c.canceled(); err != nil {
+						it.Close()
+						return err
+					}
+					item := it.Item()
+					key := string(item.Key()[len(prefix)+geoPrecision:])
+					var pLat, pLon float64
+					err := item.Value(func(val []byte) error {
+						if len(val) != 16 {
+							return fmt.Errorf("Invalid geo index entry for %s", key)
+						}
+						pLat = math.Float64frombits(binary.BigEndian.Uint64(val))
+						pLon = math.Float64frombits(binary.BigEndian.Uint64(val[8:]))
+						return nil
+					})
+					if err != nil {
+						it.Close()
+						return err
+					}
+					if within(pLat, pLon) {
+						results = append(results, GeoResult{Key: key, Lat: pLat, Lon: pLon, Distance: distance(lat, lon, pLat, pLon)})
+					}
+				}
+				it.Close()
+			}
+			sort.Slice(results, func(i, j int) bool {
+				if results[i].Distance != results[j].Distance {
+					return results[i].Distance < results[j].Distance
+				}
+				return results[i].Key < results[j].Key
+			})
+			if limit > 0 && len(results) > limit {
+				results = results[:limit]
+			}
+			found := results[:0]
+			for _, r := range results {
+				v, ok, err := s.indexedValue(c, txn, r.Key)
+				if err != nil {
+					return err
+				}
+				if ok {
+					r.Value = v
+					found = append(found, r)
+				}
+			}
+			results = found
+			return nil
+		})
+	})
+	return results, err
+}
+
+// distance returns the great-circle distance between two locations in meters
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func wrapLon(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
+
+// geohash encodes the location in precision base 32 characters, each
+// halving the cell alternately by longitude and latitude 5 times
+func geohash(lat, lon float64, precision int) []byte {
+	minLat, maxLat, minLon, maxLon := -90.0, 90.0, -180.0, 180.0
+	hash := make([]byte, 0, precision)
+	bit, ch, even := 0, 0, true
+	for len(hash) < precision {
+		if even {
+			mid := (minLon + maxLon) / 2
+			if lon >= mid {
+				ch |= 1 << (4 - bit)
+				minLon = mid
+			} else {
+				maxLon = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+		even = !even
+		if bit < 4 {
+			bit++
+		} else {
+			hash = append(hash, geohashBase[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return hash
+}
+
+// geoCellSize returns the height and width in degrees
+// of the geohash cells of the precision
+func geoCellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Exp2(float64(latBits)), 360 / math.Exp2(float64(lonBits))
+}
+
+// geoCells returns the geohashes of the cells covering the box, with
+// the longest precision that needs no more than maxGeoCells of them
+func geoCells(box geoBox) [][]byte {
+	boxes := box.split()
+	for precision := geoPrecision; precision > 1; precision-- {
+		h, w := geoCellSize(precision)
+		n := 0
+		for _, b := range boxes {
+			rows := math.Floor((b.maxLat+90)/h) - math.Floor((b.minLat+90)/h) + 1
+			cols := math.Floor((b.maxLon+180)/w) - math.Floor((b.minLon+180)/w) + 1
+			n += int(rows * cols)
+		}
+		if n <= maxGeoCells {
+			return coverBoxes(boxes, precision)
+		}
+	}
+	return coverBoxes(boxes, 1)
+}
+
+func coverBoxes(boxes []geoBox, precision int) [][]byte {
+	h, w := geoCellSize(precision)
+	maxRow := int(math.Round(180/h)) - 1
+	maxCol := int(math.Round(360/w)) - 1
+	seen := make(map[string]bool)
+	var cells [][]byte
+	for _, b := range boxes {
+		for row := int((b.minLat + 90) / h); row <= int((b.maxLat+90)/h) && row <= maxRow; row++ {
+			for col := int((b.minLon + 180) / w); col <= int((b.maxLon+180)/w) && col <= maxCol; col++ {
+				// the center of the cell
+				cell := geohash(-90+(float64(row)+0.5)*h, -180+(float64(col)+0.5)*w, precision)
+				if !seen[string(cell)] {
+					seen[string(cell)] = true
+					cells = append(cells, cell)
+				}
+			}
+		}
+	}
+	return cells
+}
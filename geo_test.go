@@ -0,0 +1,128 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"github.com/prasanthmj/sett/v2"
+	"reflect"
+	"testing"
+)
+
+type PlaceObj struct {
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+func TestGeoIndex(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testGeoIndex(t, b.open(t))
+		})
+	}
+}
+
+func geoKeys(t *testing.T, results []sett.GeoResult, err error) []string {
+	if err != nil {
+		t.Fatalf("Geo search failed %v", err)
+	}
+	keys := []string{}
+	for _, r := range results {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+func testGeoIndex(t *testing.T, s *sett.Sett) {
+	gob.Register(&PlaceObj{})
+	places := s.Table("places")
+	places.SetStruct("eiffel", &PlaceObj{Name: "Eiffel Tower", Lat: 48.8584, Lon: 2.2945})
+	places.SetStruct("louvre", &PlaceObj{Name: "Louvre", Lat: 48.8606, Lon: 2.3376})
+	places.SetStruct("notredame", &PlaceObj{Name: "Notre-Dame", Lat: 48.8530, Lon: 2.3499})
+	places.SetStruct("versailles", &PlaceObj{Name: "Versailles", Lat: 48.8049, Lon: 2.1204})
+	places.SetStruct("london", &PlaceObj{Name: "Big Ben", Lat: 51.5007, Lon: -0.1246})
+	places.SetStruct("fiji", &PlaceObj{Name: "Suva", Lat: -18.1416, Lon: 178.4419})
+	places.SetStruct("samoa", &PlaceObj{Name: "Apia", Lat: -13.8333, Lon: -171.7500})
+	places.SetStr("note", "no location")
+
+	_, err := places.Nearby(48.8566, 2.3522, 5000, 0)
+	if err == nil {
+		t.Errorf("Expected an error without a geo index")
+	}
+	err = places.CreateGeoIndex(func(k string, v interface{}) (float64, float64, bool) {
+		p, ok := v.(*PlaceObj)
+		if !ok {
+			return 0, 0, false
+		}
+		return p.Lat, p.Lon, true
+	})
+	if err != nil {
+		t.Fatalf("CreateGeoIndex failed %v", err)
+	}
+
+	// from the Hôtel de Ville
+	results, err := places.Nearby(48.8566, 2.3522, 5000, 0)
+	keys := geoKeys(t, results, err)
+	if !reflect.DeepEqual(keys, []string{"notredame", "louvre", "eiffel"}) {
+		t.Errorf("Expected the places in Paris by distance, got %v", keys)
+	}
+	if d := results[0].Distance; d < 400 || d > 500 {
+		t.Errorf("Unexpected distance to Notre-Dame %f", d)
+	}
+	if results[0].Value.(*PlaceObj).Name != "Notre-Dame" {
+		t.Errorf("Unexpected value %+v", results[0].Value)
+	}
+	results, err = places.Nearby(48.8566, 2.3522, 50000, 2)
+	if keys := geoKeys(t, results, err); !reflect.DeepEqual(keys, []string{"notredame", "louvre"}) {
+		t.Errorf("Expected the 2 closest places, got %v", keys)
+	}
+	results, err = places.Nearby(48.8566, 2.3522, 400000, 0)
+	if keys := geoKeys(t, results, err); len(keys) != 5 || keys[4] != "london" {
+		t.Errorf("Expected London last, got %v", keys)
+	}
+
+	results, err = places.WithinBox(48.80, 2.10, 48.87, 2.30, 0)
+	if keys := geoKeys(t, results, err); !reflect.DeepEqual(keys, []string{"eiffel", "versailles"}) && !reflect.DeepEqual(keys, []string{"versailles", "eiffel"}) {
+		t.Errorf("Expected Versailles and the Eiffel Tower in the box, got %v", keys)
+	}
+	// across the 180th meridian
+	results, err = places.WithinBox(-20, 175, -10, -170, 0)
+	if keys := geoKeys(t, results, err); len(keys) != 2 {
+		t.Errorf("Expected Fiji and Samoa, got %v", keys)
+	}
+	results, err = places.Nearby(-16, 180, 1000000, 0)
+	if keys := geoKeys(t, results, err); len(keys) != 2 {
+		t.Errorf("Expected Fiji and Samoa around the meridian, got %v", keys)
+	}
+
+	places.SetStruct("eiffel", &PlaceObj{Name: "Eiffel Tower", Lat: 51.5, Lon: -0.12})
+	results, err = places.Nearby(48.8566, 2.3522, 5000, 0)
+	if keys := geoKeys(t, results, err); !reflect.DeepEqual(keys, []string{"notredame", "louvre"}) {
+		t.Errorf("Expected the index to follow the update, got %v", keys)
+	}
+	places.Delete("louvre")
+	results, err = places.Nearby(48.8566, 2.3522, 5000, 0)
+	if keys := geoKeys(t, results, err); !reflect.DeepEqual(keys, []string{"notredame"}) {
+		t.Errorf("Expected the index to follow the delete, got %v", keys)
+	}
+
+	_, err = places.WithinBox(50, 0, 40, 1, 0)
+	if err == nil {
+		t.Errorf("Expected an error for an invalid box")
+	}
+	allKeys, _ := s.Keys()
+	for _, k := range allKeys {
+		if k != "" && k[0] == 0xff {
+			t.Errorf("Geo index entries listed as keys")
+		}
+	}
+
+	err = places.DropGeoIndex()
+	if err != nil {
+		t.Fatalf("DropGeoIndex failed %v", err)
+	}
+	_, err = places.Nearby(48.8566, 2.3522, 5000, 0)
+	if err == nil {
+		t.Errorf("Expected an error after dropping the index")
+	}
+}
@@ -4,13 +4,14 @@ go 1.19
 
 require (
 	github.com/dgraph-io/badger/v4 v4.2.0
+	github.com/dgraph-io/ristretto v0.1.1
+	go.etcd.io/bbolt v1.3.8
 	go.uber.org/goleak v1.3.0
 	syreclabs.com/go/faker v1.2.3
 )
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
-	github.com/dgraph-io/ristretto v0.1.1 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/glog v1.2.0 // indirect
@@ -0,0 +1,322 @@
+package sett
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// HistoryOptions configures the history of the values of a table
+type HistoryOptions struct {
+	// Keep is the number of versions kept for each key, 0 keeps them all
+	Keep int
+	// MaxAge is how long a version is kept, 0 keeps it until there
+	// are more than Keep versions
+	MaxAge time.Duration
+}
+
+// HistoryEntry is a version of the value of a key
+type HistoryEntry struct {
+	// Version is the version of the item, as returned by Item.Version().
+	// It is 0 for deletions, and when it is not known as the value
+	// was replaced after it expired
+	Version uint64
+	// Time is when the value was written, zero if it was written
+	// before the history was enabled
+	Time    time.Time
+	Deleted bool
+	Value   interface{}
+}
+
+// WithHistory keeps the previous values of the keys of the table, so they
+// can be read with History, GetAt and GetAtTime. Every write of the table,
+// including Lock and Touch, adds a version to the history of the key.
+// As with WithEncryption, the setting applies to every handle of the table
+func (s *Sett) WithHistory(opts HistoryOptions) *Sett {
+	s.shared.tables.update(s.table, func(o *tableOptions) {
+		o.history = &opts
+	})
+	return s
+}
+
+var historyIndex = []byte("hist:")
+
+const (
+	historyDeleted = 1
+
+	// flags (1 byte) | user meta (1 byte) | version (8 bytes)
+	historyHeaderLength = 10
+)
+
+// The versions are kept under
+// historyIndex | len(table) (2 bytes) | table | len(key) (2 bytes) | key | written at (8 bytes)
+func (s *Sett) historyPrefix(key string) []byte {
+	return systemKey(historyIndex, lengthPrefixed([]byte(s.table)), lengthPrefixed([]byte(key)))
+}
+
+type historyRecord struct {
+	key       []byte
+	writtenAt uint64
+	flags     byte
+	meta      byte
+	version   uint64
+	value     []byte
+}
+
+func decodeHistoryRecord(prefix []byte, item Item) (historyRecord, error) {
+	k := item.KeyCopy(nil)
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return historyRecord{}, err
+	}
+	if len(val) < historyHeaderLength || len(k) != len(prefix)+8 {
+		return historyRecord{}, errors.New("Invalid history record")
+	}
+	return historyRecord{
+		key:       k,
+		writtenAt: binary.BigEndian.Uint64(k[len(prefix):]),
+		flags:     val[0],
+		meta:      val[1],
+		version:   binary.BigEndian.Uint64(val[2:10]),
+		value:     val[historyHeaderLength:],
+	}, nil
+}
+
+func (r *historyRecord) encode() []byte {
+	val := make([]byte, historyHeaderLength, historyHeaderLength+len(r.value))
+	val[0] = r.flags
+	val[1] = r.meta
+	binary.BigEndian.PutUint64(val[2:10], r.version)
+	return append(val, r.value...)
+}
+
+// historyEnd returns the key after the versions with the prefix
+func historyEnd(prefix []byte) []byte {
+	return append(append([]byte{}, prefix...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+}
+
+// lastHistoryRecord returns the most recent version of the key, nil if there is none
+func (s *Sett) lastHistoryRecord(txn Txn, prefix []byte) (*historyRecord, error) {
+	it := txn.NewIterator(IteratorOptions{Prefix: prefix, Reverse: true})
+	defer it.Close()
+	it.Seek(historyEnd(prefix))
+	if !it.Valid() {
+		return nil, nil
+	}
+	r, err := decodeHistoryRecord(prefix, it.Item())
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// trimHistory deletes the oldest versions of the key beyond the keep most recent
+func trimHistory(txn Txn, prefix []byte, keep int) error {
+	var keys [][]byte
+	it := txn.NewIterator(IteratorOptions{Prefix: prefix, KeysOnly: true})
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	if len(keys) <= keep {
+		return nil
+	}
+	for _, k := range keys[:len(keys)-keep] {
+		err := txn.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordHistory adds the value written to the history of the key,
+// e is nil when the key is deleted. old is the value replaced.
+// Only the last version is read, so that keeping all the versions
+// doesn't make the writes slower
+func (s *Sett) recordHistory(txn Txn, fullKey []byte, old Item, e *Entry) error {
+	opts := s.options().history
+	key := string(fullKey[len(s.makeKey("")):])
+	prefix := s.historyPrefix(key)
+	last, err := s.lastHistoryRecord(txn, prefix)
+	if err != nil {
+		return err
+	}
+	var expiresAt uint64
+	if opts.MaxAge > 0 {
+		expiresAt = uint64(time.Now().Add(opts.MaxAge).Unix())
+	}
+	put := func(r historyRecord) error {
+		return txn.Set(&Entry{Key: r.key, Value: r.encode(), ExpiresAt: expiresAt})
+	}
+
+	now := uint64(time.Now().UnixNano())
+	if last != nil {
+		// The version of a value is known once it is committed
+		if old != nil && last.flags&historyDeleted == 0 && last.version == 0 {
+			last.version = old.Version()
+			err = put(*last)
+			if err != nil {
+				return err
+			}
+		}
+		if now <= last.writtenAt {
+			now = last.writtenAt + 1
+		}
+	} else if old != nil {
+		// The value was written before the history was enabled
+		val, err := old.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		r := historyRecord{key: append(append([]byte{}, prefix...), make([]byte, 8)...), meta: old.UserMeta(), version: old.Version(), value: val}
+		err = put(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, now)
+	r := historyRecord{key: append(append([]byte{}, prefix...), ts...), writtenAt: now}
+	if e == nil {
+		r.flags = historyDeleted
+	} else {
+		r.meta = e.UserMeta &^ TRACKED_FLAG
+		r.value = e.Value
+	}
+	err = put(r)
+	if err != nil {
+		return err
+	}
+
+	if opts.Keep > 0 {
+		return trimHistory(txn, prefix, opts.Keep)
+	}
+	return nil
+}
+
+// History returns up to n versions of the key, the most recent first.
+// A n of 0 returns all the versions kept
+func (s *Sett) History(key string, n int) ([]HistoryEntry, error) {
+	return s.HistoryCtx(context.Background(), key, n)
+}
+
+// HistoryCtx is History with a context
+func (s *Sett) HistoryCtx(ctx context.Context, key string, n int) (entries []HistoryEntry, err error) {
+	err = s.do(ctx, OpHistory, key, nil, func(c *opCall) error {
+		defer func() { c.result = entries }()
+		return s.walkHistory(c, func(he HistoryEntry) bool {
+			entries = append(entries, he)
+			return n <= 0 || len(entries) < n
+		})
+	})
+	return entries, err
+}
+
+// walkHistory passes the versions of the key to fn, the most recent
+// first, until fn returns false
+func (s *Sett) walkHistory(c *opCall, fn func(he HistoryEntry) bool) error {
+	return s.db.View(func(txn Txn) error {
+		prefix := s.historyPrefix(c.key)
+		current, err := currentItem(txn, []byte(s.makeKey(c.key)))
+		if err != nil {
+			return err
+		}
+		it := txn.NewIterator(IteratorOptions{Prefix: prefix, Reverse: true})
+		defer it.Close()
+		first := true
+		for it.Seek(historyEnd(prefix)); it.Valid(); it.Next() {
+			if err := c.canceled(); err != nil {
+				return err
+			}
+			r, err := decodeHistoryRecord(prefix, it.Item())
+			if err != nil {
+				return err
+			}
+			if first && r.version == 0 && r.flags&historyDeleted == 0 && current != nil {
+				r.version = current.Version()
+			}
+			first = false
+			he, err := s.historyEntry(c.key, r)
+			if err != nil {
+				return err
+			}
+			if !fn(he) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Sett) historyEntry(key string, r historyRecord) (HistoryEntry, error) {
+	he := HistoryEntry{Version: r.version, Deleted: r.flags&historyDeleted != 0}
+	if r.writtenAt > 0 {
+		he.Time = time.Unix(0, int64(r.writtenAt))
+	}
+	if he.Deleted {
+		return he, nil
+	}
+	plain, err := NewSettItem(s, nil, key).plainValue(r.meta, r.value)
+	if err != nil {
+		return he, err
+	}
+	he.Value, err = decodeValue(r.meta, plain)
+	return he, err
+}
+
+// GetAt returns the value of the key at the version, from its history
+func (s *Sett) GetAt(key string, version uint64) (interface{}, error) {
+	return s.GetAtCtx(context.Background(), key, version)
+}
+
+// GetAtCtx is GetAt with a context
+func (s *Sett) GetAtCtx(ctx context.Context, key string, version uint64) (v interface{}, err error) {
+	err = s.do(ctx, OpHistory, key, nil, func(c *opCall) error {
+		err := ErrKeyNotFound
+		walkErr := s.walkHistory(c, func(he HistoryEntry) bool {
+			if he.Version == version && !he.Deleted {
+				v, err = he.Value, nil
+				return false
+			}
+			return true
+		})
+		if walkErr != nil {
+			return walkErr
+		}
+		c.result = v
+		return err
+	})
+	return v, err
+}
+
+// GetAtTime returns the value the key had at t, from its history.
+// Returns ErrKeyNotFound if the key didn't exist at t, or if its
+// history doesn't go that far back
+func (s *Sett) GetAtTime(key string, t time.Time) (interface{}, error) {
+	return s.GetAtTimeCtx(context.Background(), key, t)
+}
+
+// GetAtTimeCtx is GetAtTime with a context
+func (s *Sett) GetAtTimeCtx(ctx context.Context, key string, t time.Time) (v interface{}, err error) {
+	err = s.do(ctx, OpHistory, key, nil, func(c *opCall) error {
+		err := ErrKeyNotFound
+		walkErr := s.walkHistory(c, func(he HistoryEntry) bool {
+			if he.Time.After(t) {
+				return true
+			}
+			if !he.Deleted {
+				v, err = he.Value, nil
+			}
+			return false
+		})
+		if walkErr != nil {
+			return walkErr
+		}
+		c.result = v
+		return err
+	})
+	return v, err
+}
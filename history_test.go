@@ -0,0 +1,106 @@
+package sett_test
+
+import (
+	"context"
+	"errors"
+	"github.com/prasanthmj/sett/v2"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testHistory(t, b.open(t))
+		})
+	}
+}
+
+func testHistory(t *testing.T, s *sett.Sett) {
+	s.Table("docs").SetStr("d1", "before")
+	tbl := s.Table("docs").WithHistory(sett.HistoryOptions{})
+
+	tbl.SetStr("d1", "v1")
+	tbl.SetStr("d1", "v2")
+	mid := time.Now()
+	tbl.Delete("d1")
+	tbl.SetStr("d1", "v3")
+
+	h, err := tbl.History("d1", 0)
+	if err != nil {
+		t.Fatalf("History failed %v", err)
+	}
+	if len(h) != 5 {
+		t.Fatalf("Expected 5 versions, got %+v", h)
+	}
+	if h[0].Value != "v3" || !h[1].Deleted || h[2].Value != "v2" || h[3].Value != "v1" || h[4].Value != "before" {
+		t.Errorf("Unexpected history %+v", h)
+	}
+	if !h[4].Time.IsZero() || h[3].Time.IsZero() || !h[0].Time.After(h[1].Time) {
+		t.Errorf("Unexpected times %+v", h)
+	}
+	for _, i := range []int{0, 2, 3, 4} {
+		if h[i].Version == 0 {
+			t.Errorf("Version %d not known %+v", i, h[i])
+			continue
+		}
+		v, err := tbl.GetAt("d1", h[i].Version)
+		if err != nil || v != h[i].Value {
+			t.Errorf("GetAt %d returned %v %v", h[i].Version, v, err)
+		}
+	}
+
+	v, err := tbl.GetAtTime("d1", mid)
+	if err != nil || v != "v2" {
+		t.Errorf("GetAtTime before the delete returned %v %v", v, err)
+	}
+	_, err = tbl.GetAtTime("d1", h[1].Time)
+	if !errors.Is(err, sett.ErrKeyNotFound) {
+		t.Errorf("Expected the key not found when deleted, got %v", err)
+	}
+
+	h, _ = tbl.History("d1", 2)
+	if len(h) != 2 || h[0].Value != "v3" {
+		t.Errorf("Expected the 2 latest versions, got %+v", h)
+	}
+
+	kept := s.Table("kept").WithHistory(sett.HistoryOptions{Keep: 2})
+	for _, v := range []string{"a", "b", "c", "d"} {
+		kept.SetStr("k", v)
+	}
+	h, _ = kept.History("k", 0)
+	if len(h) != 2 || h[0].Value != "d" || h[1].Value != "c" {
+		t.Errorf("Expected 2 versions kept, got %+v", h)
+	}
+
+	// the reads of the history go through the middleware
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tbl.HistoryCtx(ctx, "d1", 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected HistoryCtx to be canceled, got %v", err)
+	}
+	errDenied := errors.New("denied")
+	s.Use(func(op sett.Op, next sett.Handler) error {
+		if op.Kind == sett.OpHistory {
+			return errDenied
+		}
+		return next(op)
+	})
+	_, err = tbl.GetAtCtx(context.Background(), "d1", h[0].Version)
+	if !errors.Is(err, errDenied) {
+		t.Errorf("Expected the middleware to refuse GetAt, got %v", err)
+	}
+	_, err = tbl.GetAtTime("d1", time.Now())
+	if !errors.Is(err, errDenied) {
+		t.Errorf("Expected the middleware to refuse GetAtTime, got %v", err)
+	}
+
+	keys, _ := s.Keys()
+	for _, k := range keys {
+		if k != "" && k[0] == 0xff {
+			t.Errorf("History listed as keys")
+		}
+	}
+}
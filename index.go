@@ -0,0 +1,541 @@
+package sett
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tableIndex is an index Sett keeps up to date with the values of a table
+type tableIndex interface {
+	// update replaces the entries of the key for the value old by the
+	// entries for val. The values are decoded, nil when there is none.
+	// expiresAt is the expiry of the item holding val
+	update(txn Txn, key string, old interface{}, val interface{}, expiresAt uint64) error
+}
+
+// updateIndexes keeps the indexes of the table in step with the value
+// of the key being replaced by e, or deleted when e is nil
+func (s *Sett) updateIndexes(txn Txn, fullKey []byte, old Item, e *Entry, indexes map[string]tableIndex) error {
+	key := string(fullKey[len(s.makeKey("")):])
+	si := NewSettItem(s, nil, key)
+	var oldVal, newVal interface{}
+	var expiresAt uint64
+	if old != nil {
+		val, err := old.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		oldVal, err = si.decodeStored(old.UserMeta(), val)
+		if err != nil {
+			return err
+		}
+	}
+	if e != nil {
+		var err error
+		newVal, err = si.decodeStored(e.UserMeta, e.Value)
+		if err != nil {
+			return err
+		}
+		expiresAt = e.ExpiresAt
+	}
+	for _, idx := range indexes {
+		err := idx.update(txn, key, oldVal, newVal, expiresAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeStored returns the value as stored, decrypted and decoded
+func (si *SettItem) decodeStored(meta byte, val []byte) (interface{}, error) {
+	plain, err := si.plainValue(meta, val)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(meta, plain)
+}
+
+// indexedValue returns the value of a key found in an index,
+// false if the item is gone
+func (s *Sett) indexedValue(c *opCall, txn Txn, key string) (interface{}, bool, error) {
+	si := NewSettItem(s, txn, key)
+	si.call = c
+	item, err := currentItem(txn, []byte(si.fullKey))
+	if err != nil || item == nil {
+		return nil, false, err
+	}
+	v, err := si.value(item)
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// addIndex registers the index of the table under the name
+func (s *Sett) addIndex(name string, idx tableIndex) {
+	s.shared.tables.update(s.table, func(o *tableOptions) {
+		indexes := make(map[string]tableIndex, len(o.indexes)+1)
+		for n, i := range o.indexes {
+			indexes[n] = i
+		}
+		indexes[name] = idx
+		o.indexes = indexes
+	})
+}
+
+func (s *Sett) removeIndex(name string) {
+	s.shared.tables.update(s.table, func(o *tableOptions) {
+		indexes := make(map[string]tableIndex, len(o.indexes))
+		for n, i := range o.indexes {
+			if n != name {
+				indexes[n] = i
+			}
+		}
+		o.indexes = indexes
+	})
+}
+
+// buildIndex adds the entries of the values already in the table to the
+// index, unless the marker key tells it was built already. The index is
+// registered before, so the values written meanwhile are indexed as well.
+// c is the operation building it, nil when it is created
+func (s *Sett) buildIndex(c *opCall, idx tableIndex, marker []byte) error {
+	err := s.db.View(func(txn Txn) error {
+		_, err := txn.Get(marker)
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+	var keys []string
+	err = s.db.View(func(txn Txn) error {
+		prefix := []byte(s.makeKey(""))
+		it := txn.NewIterator(IteratorOptions{Prefix: prefix, KeysOnly: true})
+		defer it.Close()
+		for it.Seek(prefix); it.Valid(); it.Next() {
+			if !isSystemKey(it.Item().Key()) {
+				keys = append(keys, string(it.Item().Key()[len(prefix):]))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += importBatchSize {
+		if err := c.canceled(); err != nil {
+			return err
+		}
+		end := start + importBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err = s.db.Update(func(txn Txn) error {
+			for _, key := range keys[start:end] {
+				si := NewSettItem(s, txn, key)
+				item, err := currentItem(txn, []byte(si.fullKey))
+				if err != nil {
+					return err
+				}
+				if item == nil {
+					// deleted since the keys were listed
+					continue
+				}
+				val, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				v, err := si.decodeStored(item.UserMeta(), val)
+				if err != nil {
+					return err
+				}
+				err = idx.update(txn, key, nil, v, item.ExpiresAt())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.Update(func(txn Txn) error {
+		return txn.Set(&Entry{Key: marker, Value: []byte{}})
+	})
+}
+
+// clearIndex removes the entries of an index under the prefixes
+// and its marker, so that buildIndex builds it again
+func (s *Sett) clearIndex(marker []byte, prefixes ...[]byte) error {
+	for _, prefix := range prefixes {
+		err := s.deleteKeys(prefix)
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.Update(func(txn Txn) error {
+		return txn.Delete(marker)
+	})
+}
+
+// deleteKeys removes every key with the prefix
+func (s *Sett) deleteKeys(prefix []byte) error {
+	for {
+		var keys [][]byte
+		err := s.db.View(func(txn Txn) error {
+			it := txn.NewIterator(IteratorOptions{Prefix: prefix, KeysOnly: true})
+			defer it.Close()
+			for it.Seek(prefix); it.Valid() && len(keys) < importBatchSize; it.Next() {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+			return nil
+		})
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		err = s.db.Update(func(txn Txn) error {
+			for _, k := range keys {
+				err := txn.Delete(k)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	fieldIndexPrefix = []byte("idx:")
+	fieldIndexMarker = []byte("idxm:")
+)
+
+// fieldIndex orders the keys of a table by the value of a field.
+// The entries are under
+// fieldIndexPrefix | len(table) (2 bytes) | table | len(field) (2 bytes) | field | value | key
+// with the value encoded so that the byte order follows the order
+// of the values, and the key as the value of the entry
+type fieldIndex struct {
+	table string
+	field string
+}
+
+func (fi *fieldIndex) prefix() []byte {
+	return systemKey(fieldIndexPrefix, lengthPrefixed([]byte(fi.table)), lengthPrefixed([]byte(fi.field)))
+}
+
+func (fi *fieldIndex) marker() []byte {
+	return systemKey(fieldIndexMarker, lengthPrefixed([]byte(fi.table)), lengthPrefixed([]byte(fi.field)))
+}
+
+func (fi *fieldIndex) entryKey(v interface{}, key string) ([]byte, bool) {
+	fv, ok := fieldValue(v, fi.field)
+	if !ok {
+		return nil, false
+	}
+	enc, ok := encodeIndexValue(fv)
+	if !ok {
+		return nil, false
+	}
+	k := append(fi.prefix(), enc...)
+	return append(k, key...), true
+}
+
+func (fi *fieldIndex) update(txn Txn, key string, old interface{}, val interface{}, expiresAt uint64) error {
+	if old != nil {
+		if k, ok := fi.entryKey(old, key); ok {
+			err := txn.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if val != nil {
+		if k, ok := fi.entryKey(val, key); ok {
+			return txn.Set(&Entry{Key: k, Value: []byte(key), ExpiresAt: expiresAt})
+		}
+	}
+	return nil
+}
+
+// CreateIndex indexes the values of the table by the field, so that the
+// queries filtering on the field don't scan the whole table. field is the
+// name of a field of the struct values, or a path like "Customer.Name".
+// Numbers, strings, bools and times can be indexed; the values where the
+// field has another type are not in the index.
+// The index is built from the values already in the table the first time.
+// As with WithEncryption, call it each time the store is opened
+func (s *Sett) CreateIndex(field string) error {
+	if len(field) == 0 {
+		return fmt.Errorf("CreateIndex needs a field")
+	}
+	fi := &fieldIndex{table: s.table, field: field}
+	s.addIndex("field:"+field, fi)
+	return s.buildIndex(nil, fi, fi.marker())
+}
+
+// DropIndex stops indexing the values of the table by the field
+// and removes the index
+func (s *Sett) DropIndex(field string) error {
+	fi := &fieldIndex{table: s.table, field: field}
+	s.removeIndex("field:" + field)
+	return s.clearIndex(fi.marker(), fi.prefix())
+}
+
+// RebuildIndex builds the index of the field again from the values of
+// the table, for the writes made while it was not created, by a process
+// which didn't call CreateIndex or by an import. Needs CreateIndex
+func (s *Sett) RebuildIndex(field string) error {
+	return s.RebuildIndexCtx(context.Background(), field)
+}
+
+// RebuildIndexCtx is RebuildIndex with a context. When the context is
+// done, the index is left partly built until it is rebuilt
+func (s *Sett) RebuildIndexCtx(ctx context.Context, field string) error {
+	fi := s.fieldIndex(field)
+	if fi == nil {
+		return fmt.Errorf("RebuildIndex needs an index of %s, see CreateIndex", field)
+	}
+	return s.do(ctx, OpRebuild, field, nil, func(c *opCall) error {
+		err := s.clearIndex(fi.marker(), fi.prefix())
+		if err != nil {
+			return err
+		}
+		return s.buildIndex(c, fi, fi.marker())
+	})
+}
+
+// Indexes returns the fields the table is indexed by
+func (s *Sett) Indexes() []string {
+	var fields []string
+	for _, idx := range s.options().indexes {
+		if fi, ok := idx.(*fieldIndex); ok {
+			fields = append(fields, fi.field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func (s *Sett) fieldIndex(field string) *fieldIndex {
+	fi, _ := s.options().indexes["field:"+field].(*fieldIndex)
+	return fi
+}
+
+// fieldValue returns the field of a struct, or the entry of a
+// map[string]interface{}, following a path like "Customer.Name"
+func fieldValue(v interface{}, path string) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	for _, name := range strings.Split(path, ".") {
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			if rv.IsNil() {
+				return nil, false
+			}
+			rv = rv.Elem()
+		}
+		switch rv.Kind() {
+		case reflect.Struct:
+			rv = rv.FieldByName(name)
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			rv = rv.MapIndex(reflect.ValueOf(name))
+		default:
+			return nil, false
+		}
+		if !rv.IsValid() || !rv.CanInterface() {
+			return nil, false
+		}
+	}
+	return rv.Interface(), true
+}
+
+// Type tags of the encoded index values. Values of different
+// types are ordered by their tags
+const (
+	indexBool   = 0x10
+	indexNumber = 0x20
+	indexString = 0x30
+	indexTime   = 0x40
+)
+
+// normalize returns the number as float64, so that numbers of
+// different types compare, and the other indexable values as they are
+func normalize(v interface{}) (interface{}, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case *time.Time:
+		if x == nil {
+			return nil, false
+		}
+		return *x, true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		return rv.String(), true
+	case reflect.Bool:
+		return rv.Bool(), true
+	}
+	return nil, false
+}
+
+// encodeIndexValue encodes the value so that the byte order of the
+// encodings follows the order of the values. No encoding is the prefix
+// of another, so the key can follow it in the index entries.
+// Numbers are encoded as float64, so integers above 2^53 can share an
+// encoding. The values found in the index are compared again exactly
+func encodeIndexValue(v interface{}) ([]byte, bool) {
+	nv, ok := normalize(v)
+	if !ok {
+		return nil, false
+	}
+	switch x := nv.(type) {
+	case bool:
+		if x {
+			return []byte{indexBool, 1}, true
+		}
+		return []byte{indexBool, 0}, true
+	case float64:
+		bits := math.Float64bits(x)
+		if x >= 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		b := make([]byte, 9)
+		b[0] = indexNumber
+		binary.BigEndian.PutUint64(b[1:], bits)
+		return b, true
+	case string:
+		b := append([]byte{indexString}, escapeIndexString(x)...)
+		return append(b, 0x00, 0x01), true
+	case time.Time:
+		b := make([]byte, 9)
+		b[0] = indexTime
+		binary.BigEndian.PutUint64(b[1:], uint64(x.UnixNano())^(1<<63))
+		return b, true
+	}
+	return nil, false
+}
+
+// compareIntegers compares two numbers exactly when one of them is an
+// integer, which float64 holds exactly only up to 2^53. exact is false
+// when they are not both numbers, or both floats
+func compareIntegers(a, b interface{}) (cmp int, ok bool, exact bool) {
+	fa, aInt, aOk := exactNumber(a)
+	fb, bInt, bOk := exactNumber(b)
+	if !aOk || !bOk || (!aInt && !bInt) {
+		return 0, false, false
+	}
+	if fa == nil || fb == nil {
+		// NaN
+		return 0, false, true
+	}
+	return fa.Cmp(fb), true, true
+}
+
+// exactNumber returns the number as a big.Float holding it exactly,
+// nil for NaN, and whether it is an integer
+func exactNumber(v interface{}) (*big.Float, bool, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Float).SetInt64(rv.Int()), true, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Float).SetUint64(rv.Uint()), true, true
+	case reflect.Float32, reflect.Float64:
+		if math.IsNaN(rv.Float()) {
+			return nil, false, true
+		}
+		return new(big.Float).SetFloat64(rv.Float()), false, true
+	}
+	return nil, false, false
+}
+
+// escapeIndexString escapes the zero bytes, which
+// end the strings in the encoded index values
+func escapeIndexString(s string) []byte {
+	return bytes.ReplaceAll([]byte(s), []byte{0x00}, []byte{0x00, 0xff})
+}
+
+// compareValues compares two indexable values, false if they don't compare
+func compareValues(a, b interface{}) (int, bool) {
+	if cmp, ok, exact := compareIntegers(a, b); exact {
+		return cmp, ok
+	}
+	na, ok := normalize(a)
+	if !ok {
+		return 0, false
+	}
+	nb, ok := normalize(b)
+	if !ok {
+		return 0, false
+	}
+	switch x := na.(type) {
+	case float64:
+		y, ok := nb.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := nb.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, ok := nb.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	case time.Time:
+		y, ok := nb.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
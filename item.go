@@ -5,19 +5,45 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"github.com/dgraph-io/badger/v4"
+	"time"
 )
 
 const (
 	STRUCT_TYPE = 1
 	STRING_TYPE = 2
+	// POINT_TYPE is the value of a point of a TimeSeries, a float64
+	POINT_TYPE = 3
+	// ROLLUP_TYPE is the FieldStats of the points of a rollup bucket
+	ROLLUP_TYPE = 4
+	// ENCRYPTED_FLAG marks values sealed with the table's KeyProvider
+	ENCRYPTED_FLAG = 0x40
+	// TRACKED_FLAG marks values that are in the expiry index
+	TRACKED_FLAG = 0x20
 )
 
+// ErrLocked is matched by the errors of the writes refused because the item is locked
+var ErrLocked = errors.New("The item is locked")
+
+type lockedError struct {
+	msg string
+}
+
+func (e *lockedError) Error() string {
+	return e.msg
+}
+
+func (e *lockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
 type SettItem struct {
 	fullKey string
 	s       *Sett
-	txn     *badger.Txn
+	txn     Txn
 	unlock  bool
+	opts    setOptions
+	// call is the operation the item is read or written for, if any
+	call *opCall
 }
 
 type SettValueItem struct {
@@ -25,7 +51,7 @@ type SettValueItem struct {
 	Locked bool
 }
 
-func NewSettItem(s *Sett, txn *badger.Txn, key string) *SettItem {
+func NewSettItem(s *Sett, txn Txn, key string) *SettItem {
 	k := s.makeKey(key)
 	return &SettItem{fullKey: k, s: s, txn: txn, unlock: false}
 }
@@ -34,31 +60,47 @@ func (si *SettItem) Unlock(u bool) {
 	si.unlock = u
 }
 
+// WithOptions applies the options to the following writes of the item
+func (si *SettItem) WithOptions(opts ...SetOption) {
+	for _, o := range opts {
+		o(&si.opts)
+	}
+}
+
 func (si *SettItem) GetStructValue() (*SettValueItem, error) {
 	item, err := si.txn.Get([]byte(si.fullKey))
 	if err != nil {
 		return nil, err
 	}
+	return si.structValue(item)
+}
+
+func (si *SettItem) structValue(item Item) (*SettValueItem, error) {
+	sv, _, err := si.decodeStruct(item)
+	return sv, err
+}
+
+// decodeStruct returns the struct value and the size of its encoding
+func (si *SettItem) decodeStruct(item Item) (*SettValueItem, int, error) {
 	meta := item.UserMeta()
 	if (meta & 0x0F) != STRUCT_TYPE {
-		return nil, errors.New("Attempt to fetch Struct where item was not struct type")
+		return nil, 0, errors.New("Attempt to fetch Struct where item was not struct type")
 	}
-	var val []byte
-	val, err = item.ValueCopy(nil)
+	val, err := si.readValue(item)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	var container genericContainer
 	err = gob.NewDecoder(bytes.NewBuffer(val)).Decode(&container)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	var locked bool = false
 	if (meta & 0x80) != 0 {
 		locked = true
 	}
 	ret := &SettValueItem{V: container.V, Locked: locked}
-	return ret, nil
+	return ret, len(val), nil
 }
 
 func (si *SettItem) IsLocked() bool {
@@ -79,51 +121,112 @@ func (si *SettItem) Lock() error {
 	}
 	meta := item.UserMeta()
 	if (meta & 0x80) != 0 {
-		return fmt.Errorf("The item was already locked")
+		return &lockedError{"The item was already locked"}
 	}
 	var val []byte
 	val, err = item.ValueCopy(nil)
 	if err != nil {
 		return err
 	}
-	e := badger.NewEntry([]byte(si.fullKey), val)
-	meta = meta | 0x80
-	err = si.setEntry(e, meta)
-	return err
+	// Locking doesn't change the expiry of the item
+	e := &Entry{Key: []byte(si.fullKey), Value: val, UserMeta: meta | 0x80, ExpiresAt: item.ExpiresAt()}
+	return si.s.putEntry(si.call, si.txn, e)
 }
 
 func (si *SettItem) SetStructValue(val interface{}) error {
 	if !si.unlock && si.IsLocked() {
-		return fmt.Errorf("The item with key %s is locked. Can't update now", si.fullKey)
+		return &lockedError{fmt.Sprintf("The item with key %s is locked. Can't update now", si.fullKey)}
+	}
+	data, err := encodeStruct(val)
+	if err != nil {
+		return err
 	}
+	return si.writeValue(data, STRUCT_TYPE)
+}
+
+func encodeStruct(val interface{}) ([]byte, error) {
 	var bValue bytes.Buffer
 	container := genericContainer{V: val}
 	err := gob.NewEncoder(&bValue).Encode(&container)
+	if err != nil {
+		return nil, err
+	}
+	return bValue.Bytes(), nil
+}
+
+// writeValue stores the value, encrypting it first
+// if the table has encryption enabled
+func (si *SettItem) writeValue(val []byte, vtype byte) error {
+	si.call.setSize(len(val))
+	val, vtype, err := si.s.sealedValue([]byte(si.fullKey), val, vtype)
 	if err != nil {
 		return err
 	}
-	e := badger.NewEntry([]byte(si.fullKey), bValue.Bytes())
+	e := &Entry{Key: []byte(si.fullKey), Value: val}
+	return si.setEntry(e, vtype)
+}
+
+// sealedValue returns the value to store, encrypted
+// if the table has encryption enabled
+func (s *Sett) sealedValue(fullKey []byte, val []byte, vtype byte) ([]byte, byte, error) {
+	keys := s.options().keys
+	if keys == nil {
+		return val, vtype, nil
+	}
+	sealed, err := sealValue(keys, fullKey, val)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sealed, vtype | ENCRYPTED_FLAG, nil
+}
 
-	err = si.setEntry(e, STRUCT_TYPE)
-	return err
+// readValue returns a copy of the plain value of the item
+func (si *SettItem) readValue(item Item) ([]byte, error) {
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	val, err = si.plainValue(item.UserMeta(), val)
+	if err != nil {
+		return nil, err
+	}
+	si.call.setSize(len(val))
+	return val, nil
 }
 
-func (si *SettItem) setEntry(e *badger.Entry, vtype byte) error {
-	if si.s.ttl > 0 {
-		e.WithTTL(si.s.ttl)
+// plainValue decrypts the stored value if it is encrypted
+func (si *SettItem) plainValue(meta byte, val []byte) ([]byte, error) {
+	if (meta & ENCRYPTED_FLAG) == 0 {
+		return val, nil
+	}
+	keys := si.s.options().keys
+	if keys == nil {
+		return nil, fmt.Errorf("The item with key %s is encrypted but the table has no key provider", si.fullKey)
 	}
-	e.WithMeta(vtype)
-	return si.txn.SetEntry(e)
+	return openValue(keys, []byte(si.fullKey), val)
+}
+
+func (si *SettItem) setEntry(e *Entry, vtype byte) error {
+	switch {
+	case si.opts.expire:
+		e.ExpiresAt = si.opts.expiresAt
+	case si.opts.keepTTL:
+		item, err := si.txn.Get(e.Key)
+		if err == nil {
+			e.ExpiresAt = item.ExpiresAt()
+		}
+	case si.s.ttl > 0:
+		e.ExpiresAt = uint64(time.Now().Add(si.s.ttl).Unix())
+	}
+	e.UserMeta = vtype
+	return si.s.putEntry(si.call, si.txn, e)
 }
 
 func (si *SettItem) SetStringValue(val string) error {
 	if !si.unlock && si.IsLocked() {
-		return fmt.Errorf("The item with key %s is locked. Can't update now", si.fullKey)
+		return &lockedError{fmt.Sprintf("The item with key %s is locked. Can't update now", si.fullKey)}
 	}
-	e := badger.NewEntry([]byte(si.fullKey), []byte(val))
-
-	err := si.setEntry(e, STRING_TYPE)
-	return err
+	return si.writeValue([]byte(val), STRING_TYPE)
 }
 
 func (si *SettItem) GetStringValue() (string, error) {
@@ -131,22 +234,56 @@ func (si *SettItem) GetStringValue() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return si.stringValue(item)
+}
+
+// stringValue returns the string value of the item through the cache
+func (si *SettItem) stringValue(item Item) (string, error) {
 	meta := item.UserMeta()
 	if (meta & 0x0F) != STRING_TYPE {
 		return "", errors.New("Attempt to fetch Struct where item was not struct type")
 	}
-	var val []byte
-	val, err = item.ValueCopy(nil)
+	cache := si.s.options().cache
+	if cache != nil {
+		if v, size, ok := cache.get(si.fullKey, item.Version()); ok {
+			si.call.setSize(size)
+			return v.(string), nil
+		}
+	}
+	val, err := si.readValue(item)
 	if err != nil {
 		return "", err
 	}
+	if cache != nil {
+		cache.put(si.fullKey, item, string(val), len(val))
+	}
 	return string(val), nil
 }
 
 func (si *SettItem) Delete() error {
 	if !si.unlock && si.IsLocked() {
-		return fmt.Errorf("The item with key %s is locked. Can't delete now", si.fullKey)
+		return &lockedError{fmt.Sprintf("The item with key %s is locked. Can't delete now", si.fullKey)}
 	}
 
-	return si.txn.Delete([]byte(si.fullKey))
+	return si.s.deleteEntry(si.call, si.txn, []byte(si.fullKey))
+}
+
+// decodeValue returns the plain value as string or the decoded struct
+func decodeValue(meta byte, val []byte) (interface{}, error) {
+	switch meta & 0x0F {
+	case STRING_TYPE:
+		return string(val), nil
+	case STRUCT_TYPE:
+		var container genericContainer
+		err := gob.NewDecoder(bytes.NewBuffer(val)).Decode(&container)
+		if err != nil {
+			return nil, err
+		}
+		return container.V, nil
+	case POINT_TYPE:
+		return decodePoint(val)
+	case ROLLUP_TYPE:
+		return decodeRollup(val)
+	}
+	return nil, fmt.Errorf("Unknown value type %d", meta&0x0F)
 }
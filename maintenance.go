@@ -0,0 +1,241 @@
+package sett
+
+import (
+	"context"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OpenOption changes how a store is opened
+type OpenOption func(o *openOptions)
+
+type openOptions struct {
+	maintenance *Maintenance
+}
+
+// Maintenance configures the background maintenance of the store.
+// A zero interval disables the task
+type Maintenance struct {
+	// GCInterval is the interval between value log garbage collections
+	GCInterval time.Duration
+	// DiscardRatio is the ratio of stale data a value log file must have
+	// to be rewritten. Defaults to 0.5
+	DiscardRatio float64
+	// FlattenInterval is the interval between compactions of the LSM tree
+	// into a single level
+	FlattenInterval time.Duration
+	// FlattenWorkers is the number of compaction workers Flatten uses. Defaults to 1
+	FlattenWorkers int
+	// OnReport is called after each maintenance run
+	OnReport func(r MaintenanceReport)
+	// OnError is called when a maintenance run fails
+	OnError func(err error)
+}
+
+// MaintenanceReport is the outcome of a maintenance run
+type MaintenanceReport struct {
+	// GCRuns is the number of value log files rewritten
+	GCRuns int
+	// Purged is the number of expired keys removed, for the backends
+	// which don't remove them on their own
+	Purged int
+	// Flattened is true when the LSM tree was compacted
+	Flattened bool
+	// Reclaimed is the number of bytes freed on disk. Always 0 on bolt
+	// stores, whose file keeps its size and reuses the freed pages
+	Reclaimed int64
+	Duration  time.Duration
+}
+
+// WithMaintenance starts a goroutine doing the maintenance of the store
+// until it is closed. Badger stores run value log GC and Flatten,
+// bolt stores remove the expired keys at GCInterval
+func WithMaintenance(m Maintenance) OpenOption {
+	return func(o *openOptions) {
+		o.maintenance = &m
+	}
+}
+
+func (s *Sett) apply(options []OpenOption) {
+	var o openOptions
+	for _, opt := range options {
+		opt(&o)
+	}
+	if o.maintenance != nil {
+		s.startMaintenance(*o.maintenance)
+	}
+}
+
+const defaultDiscardRatio = 0.5
+
+// CollectGarbage rewrites the value log files having at least discardRatio
+// of stale data, until none is left. On bolt stores the expired keys are
+// removed instead, their pages being reused by later writes. They are
+// removed as deletes of their table, recorded in its audit, history and
+// indexes
+func (s *Sett) CollectGarbage(discardRatio float64) (MaintenanceReport, error) {
+	start := time.Now()
+	var r MaintenanceReport
+	var err error
+	if bb, ok := s.db.(*boltBackend); ok {
+		r.Purged, err = bb.purgeExpired(s.purgeItem(s.shared.tables.names()))
+		r.Duration = time.Since(start)
+		return r, err
+	}
+	db, err := s.badger()
+	if err != nil {
+		return r, err
+	}
+	if db.Opts().InMemory {
+		return r, nil
+	}
+	if discardRatio <= 0 || discardRatio >= 1 {
+		discardRatio = defaultDiscardRatio
+	}
+	before := badgerDiskSize(db)
+	for {
+		err = db.RunValueLogGC(discardRatio)
+		if err != nil {
+			break
+		}
+		r.GCRuns++
+	}
+	if errors.Is(err, badger.ErrNoRewrite) {
+		err = nil
+	}
+	r.Reclaimed = before - badgerDiskSize(db)
+	r.Duration = time.Since(start)
+	return r, err
+}
+
+// purgeItem returns the function removing an expired key from its table,
+// given the tables with options. The keys of no such table are in the root
+func (s *Sett) purgeItem(tables []string) func(txn Txn, key []byte, item Item) error {
+	return func(txn Txn, key []byte, item Item) error {
+		if isSystemKey(key) {
+			return txn.Delete(key)
+		}
+		table, _ := tableOf(tables, string(key))
+		t := s.Table(table)
+		t.invalidate(key)
+		c := &opCall{ctx: context.Background(), op: OpExpire, key: string(key[len(t.makeKey("")):])}
+		return t.removeItem(c, txn, key, item)
+	}
+}
+
+// Flatten compacts the LSM tree of the badger store into a single level
+func (s *Sett) Flatten(workers int) (MaintenanceReport, error) {
+	start := time.Now()
+	var r MaintenanceReport
+	db, err := s.badger()
+	if err != nil {
+		return r, err
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	before := badgerDiskSize(db)
+	err = db.Flatten(workers)
+	if err != nil {
+		return r, err
+	}
+	r.Flattened = true
+	r.Reclaimed = before - badgerDiskSize(db)
+	r.Duration = time.Since(start)
+	return r, nil
+}
+
+// badgerDiskSize returns the size of the table and value log files.
+// Size() of badger is refreshed only once a minute, too late for the report
+func badgerDiskSize(db *badger.DB) int64 {
+	opts := db.Opts()
+	if opts.InMemory {
+		return 0
+	}
+	var size int64
+	dirs := []string{opts.Dir}
+	if opts.ValueDir != opts.Dir {
+		dirs = append(dirs, opts.ValueDir)
+	}
+	for _, dir := range dirs {
+		for _, pattern := range []string{"*.sst", "*.vlog"} {
+			files, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, f := range files {
+				fi, err := os.Stat(f)
+				if err == nil {
+					size += fi.Size()
+				}
+			}
+		}
+	}
+	return size
+}
+
+type maintainer struct {
+	s      *Sett
+	m      Maintenance
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (s *Sett) startMaintenance(m Maintenance) {
+	if m.GCInterval <= 0 && m.FlattenInterval <= 0 {
+		return
+	}
+	mt := &maintainer{s: s, m: m, closed: make(chan struct{})}
+	s.shared.onClose(mt.stop)
+	mt.wg.Add(1)
+	go mt.run()
+}
+
+// tick returns the channel of a ticker, nil for a disabled task so it never fires
+func tick(d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+func (mt *maintainer) run() {
+	defer mt.wg.Done()
+	gc, stopGC := tick(mt.m.GCInterval)
+	defer stopGC()
+	flatten, stopFlatten := tick(mt.m.FlattenInterval)
+	defer stopFlatten()
+	for {
+		select {
+		case <-gc:
+			mt.report(mt.s.CollectGarbage(mt.m.DiscardRatio))
+		case <-flatten:
+			mt.report(mt.s.Flatten(mt.m.FlattenWorkers))
+		case <-mt.closed:
+			return
+		}
+	}
+}
+
+func (mt *maintainer) report(r MaintenanceReport, err error) {
+	if errors.Is(err, ErrNotSupported) {
+		// Flatten on a bolt store
+		return
+	}
+	if err != nil {
+		if mt.m.OnError != nil {
+			mt.m.OnError(err)
+		}
+		return
+	}
+	if mt.m.OnReport != nil {
+		mt.m.OnReport(r)
+	}
+}
+
+func (mt *maintainer) stop() {
+	close(mt.closed)
+	mt.wg.Wait()
+}
@@ -0,0 +1,151 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	opts := sett.DefaultOptions(dir).WithLogger(nil).WithValueLogFileSize(1 << 20).WithValueThreshold(1 << 10).WithNumLevelZeroTables(1)
+	s, err := sett.Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+
+	big := strings.Repeat("x", 64<<10)
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 32; i++ {
+			s.Table("t").SetStr(string(rune('a'+i)), big)
+		}
+		// Reopen to flush the memtable into a level 0 table
+		s.Close()
+		s, err = sett.Open(opts)
+		if err != nil {
+			t.Fatalf("Reopen failed %v", err)
+		}
+	}
+	defer s.Close()
+	// Compaction drops the stale versions, telling GC which files to rewrite
+	_, err = s.Flatten(2)
+	if err != nil {
+		t.Fatalf("Flatten failed %v", err)
+	}
+	r, err := s.CollectGarbage(0.5)
+	if err != nil {
+		t.Fatalf("GC failed %v", err)
+	}
+	if r.GCRuns == 0 || r.Reclaimed <= 0 {
+		t.Errorf("Expected value log files to be rewritten, got %+v", r)
+	}
+	v, _ := s.Table("t").GetStr("a")
+	if v != big {
+		t.Errorf("Value lost by GC")
+	}
+}
+
+func TestPurgeExpiredBolt(t *testing.T) {
+	s := setttest.NewBolt(t)
+	s.Table("t").SetStr("k1", "v", sett.ExpireAt(time.Now().Add(-time.Second)))
+	s.Table("t").SetStr("k2", "v")
+	r, err := s.CollectGarbage(0)
+	if err != nil {
+		t.Fatalf("Purge failed %v", err)
+	}
+	if r.Purged != 1 || r.Reclaimed != 0 {
+		t.Errorf("Expected one key purged and the file left as it is, got %+v", r)
+	}
+	if !s.Table("t").HasKey("k2") {
+		t.Errorf("Purge removed a live key")
+	}
+
+	// the expired keys are deleted from their table
+	gob.Register(&OrderObj{})
+	orders := s.Table("orders").WithHistory(sett.HistoryOptions{}).WithAudit(sett.AuditOptions{})
+	err = orders.CreateIndex("Status")
+	if err != nil {
+		t.Fatalf("CreateIndex failed %v", err)
+	}
+	orders.SetStruct("o1", &OrderObj{Status: "open"}, sett.ExpireIn(time.Second))
+	orders.SetStruct("o2", &OrderObj{Status: "open"})
+	time.Sleep(1100 * time.Millisecond)
+	r, err = s.CollectGarbage(0)
+	if err != nil || r.Purged != 1 {
+		t.Fatalf("Expected o1 purged, got %+v %v", r, err)
+	}
+	history, err := orders.History("o1", 0)
+	if err != nil || len(history) != 2 || !history[0].Deleted {
+		t.Errorf("Expected the purge in the history, got %+v %v", history, err)
+	}
+	records, err := orders.AuditByKey("o1", 0)
+	if err != nil || len(records) != 2 || records[1].Op != sett.OpExpire {
+		t.Errorf("Expected the purge in the audit, got %+v %v", records, err)
+	}
+	r, err = s.CollectGarbage(0)
+	if err != nil || r.Purged != 0 {
+		t.Errorf("Expected nothing left to purge, got %+v %v", r, err)
+	}
+
+	// and from the root table
+	root := s.Table("").WithHistory(sett.HistoryOptions{}).WithAudit(sett.AuditOptions{})
+	root.SetStruct("r1", &OrderObj{Status: "open"}, sett.ExpireIn(time.Second))
+	time.Sleep(1100 * time.Millisecond)
+	r, err = s.CollectGarbage(0)
+	if err != nil || r.Purged != 1 {
+		t.Fatalf("Expected r1 purged, got %+v %v", r, err)
+	}
+	history, err = root.History("r1", 0)
+	if err != nil || len(history) != 2 || !history[0].Deleted {
+		t.Errorf("Expected the purge in the history of the root, got %+v %v", history, err)
+	}
+	records, err = root.AuditByKey("r1", 0)
+	if err != nil || len(records) != 2 || records[1].Op != sett.OpExpire {
+		t.Errorf("Expected the purge in the audit of the root, got %+v %v", records, err)
+	}
+}
+
+func TestMaintenanceStopsOnClose(t *testing.T) {
+	setttest.VerifyNoLeaks(t)
+	reports := make(chan sett.MaintenanceReport, 10)
+	m := sett.Maintenance{
+		GCInterval:      50 * time.Millisecond,
+		FlattenInterval: 80 * time.Millisecond,
+		OnReport: func(r sett.MaintenanceReport) {
+			select {
+			case reports <- r:
+			default:
+			}
+		},
+		OnError: func(err error) {
+			t.Errorf("Maintenance failed %v", err)
+		},
+	}
+	for _, open := range []func() (*sett.Sett, error){
+		func() (*sett.Sett, error) {
+			return sett.Open(sett.DefaultOptions(filepath.Join(t.TempDir(), "db")).WithLogger(nil), sett.WithMaintenance(m))
+		},
+		func() (*sett.Sett, error) {
+			return sett.OpenBolt(filepath.Join(t.TempDir(), "bolt.db"), nil, sett.WithMaintenance(m))
+		},
+	} {
+		s, err := open()
+		if err != nil {
+			t.Fatalf("Open failed %v", err)
+		}
+		s.SetStr("k", "v")
+		select {
+		case <-reports:
+		case <-time.After(5 * time.Second):
+			t.Errorf("No maintenance report received")
+		}
+		err = s.Close()
+		if err != nil {
+			t.Errorf("Close failed %v", err)
+		}
+	}
+}
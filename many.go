@@ -0,0 +1,122 @@
+package sett
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// GetMany returns the values of the keys read in a single transaction.
+// Keys which can't be read, for example as they don't exist, are not in
+// values but in errs, with ErrKeyNotFound for the missing ones.
+// err is set only if the transaction itself failed
+func (s *Sett) GetMany(keys []string) (values map[string]interface{}, errs map[string]error, err error) {
+	return s.GetManyCtx(context.Background(), keys)
+}
+
+// GetManyCtx is GetMany with a context
+func (s *Sett) GetManyCtx(ctx context.Context, keys []string) (values map[string]interface{}, errs map[string]error, err error) {
+	err = s.do(ctx, OpGetMany, "", keys, func(c *opCall) error {
+		keys, ok := c.value.([]string)
+		if !ok {
+			return fmt.Errorf("GetMany needs the keys as []string, got %T", c.value)
+		}
+		return s.db.View(func(txn Txn) error {
+			values = make(map[string]interface{}, len(keys))
+			errs = make(map[string]error)
+			for _, key := range keys {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				si := NewSettItem(s, txn, key)
+				si.call = c
+				item, err := txn.Get([]byte(si.fullKey))
+				if err == nil {
+					var v interface{}
+					v, err = si.value(item)
+					if err == nil {
+						values[key] = v
+						continue
+					}
+				}
+				if !errors.Is(err, ErrKeyNotFound) {
+					err = fmt.Errorf("GetMany of %s failed: %w", key, err)
+				}
+				errs[key] = err
+			}
+			c.result = values
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return values, errs, nil
+}
+
+// SetMany sets the string or struct values of the keys in a single
+// transaction: either all of them are set or none is. Fails if any
+// of the keys is locked
+func (s *Sett) SetMany(values map[string]interface{}, opts ...SetOption) error {
+	return s.SetManyCtx(context.Background(), values, opts...)
+}
+
+// SetManyCtx is SetMany with a context
+func (s *Sett) SetManyCtx(ctx context.Context, values map[string]interface{}, opts ...SetOption) error {
+	return s.do(ctx, OpSetMany, "", values, func(c *opCall) error {
+		values, ok := c.value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("SetMany needs the values as map[string]interface{}, got %T", c.value)
+		}
+		return s.db.Update(func(txn Txn) error {
+			for key, val := range values {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				si := NewSettItem(s, txn, key)
+				si.call = c
+				si.WithOptions(opts...)
+				var err error
+				if str, ok := val.(string); ok {
+					err = si.SetStringValue(str)
+				} else {
+					err = si.SetStructValue(val)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// DeleteMany removes the keys in a single transaction: either all of
+// them are removed or none is. Fails if any of the keys is locked
+func (s *Sett) DeleteMany(keys []string) error {
+	return s.DeleteManyCtx(context.Background(), keys)
+}
+
+// DeleteManyCtx is DeleteMany with a context
+func (s *Sett) DeleteManyCtx(ctx context.Context, keys []string) error {
+	return s.do(ctx, OpDeleteMany, "", keys, func(c *opCall) error {
+		keys, ok := c.value.([]string)
+		if !ok {
+			return fmt.Errorf("DeleteMany needs the keys as []string, got %T", c.value)
+		}
+		return s.db.Update(func(txn Txn) error {
+			for _, key := range keys {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				si := NewSettItem(s, txn, key)
+				si.call = c
+				err := si.Delete()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
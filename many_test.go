@@ -0,0 +1,63 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"errors"
+	"github.com/prasanthmj/sett/v2"
+	"testing"
+)
+
+func TestMany(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testMany(t, b.open(t))
+		})
+	}
+}
+
+func testMany(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	tbl := s.Table("many")
+
+	err := tbl.SetMany(map[string]interface{}{
+		"a": "alpha",
+		"b": &TaskObj{ID: 2, Status: "open"},
+		"c": "gamma",
+	})
+	if err != nil {
+		t.Fatalf("SetMany failed %v", err)
+	}
+	values, errs, err := tbl.GetMany([]string{"a", "b", "c", "missing"})
+	if err != nil {
+		t.Fatalf("GetMany failed %v", err)
+	}
+	if len(values) != 3 || values["a"] != "alpha" || values["b"].(*TaskObj).ID != 2 {
+		t.Errorf("Unexpected values %+v", values)
+	}
+	if len(errs) != 1 || !errors.Is(errs["missing"], sett.ErrKeyNotFound) {
+		t.Errorf("Expected the missing key in the errors, got %+v", errs)
+	}
+
+	tbl.Lock("c")
+	err = tbl.SetMany(map[string]interface{}{"a": "changed", "c": "changed"})
+	if !errors.Is(err, sett.ErrLocked) {
+		t.Errorf("Expected SetMany to fail on the locked key, got %v", err)
+	}
+	err = tbl.DeleteMany([]string{"a", "c"})
+	if !errors.Is(err, sett.ErrLocked) {
+		t.Errorf("Expected DeleteMany to fail on the locked key, got %v", err)
+	}
+	values, _, _ = tbl.GetMany([]string{"a", "c"})
+	if values["a"] != "alpha" || values["c"] != "gamma" {
+		t.Errorf("A failed batch changed the values %+v", values)
+	}
+
+	err = tbl.DeleteMany([]string{"a", "b"})
+	if err != nil {
+		t.Errorf("DeleteMany failed %v", err)
+	}
+	if tbl.HasKey("a") || tbl.HasKey("b") || !tbl.HasKey("c") {
+		t.Errorf("DeleteMany didn't remove the keys")
+	}
+}
@@ -0,0 +1,117 @@
+package sett
+
+import (
+	"time"
+)
+
+// OpKind names an operation on a table
+type OpKind string
+
+const (
+	OpGet    OpKind = "get"
+	OpSet    OpKind = "set"
+	OpInsert OpKind = "insert"
+	OpCut    OpKind = "cut"
+	OpUpdate OpKind = "update"
+	OpLock   OpKind = "lock"
+	OpDelete OpKind = "delete"
+	OpFilter OpKind = "filter"
+	OpKeys   OpKind = "keys"
+	OpDrop   OpKind = "drop"
+
+	OpGetMany    OpKind = "get_many"
+	OpSetMany    OpKind = "set_many"
+	OpDeleteMany OpKind = "delete_many"
+	OpQuery      OpKind = "query"
+	OpAggregate  OpKind = "aggregate"
+	OpScan       OpKind = "scan"
+	OpSearch     OpKind = "search"
+	OpGeo        OpKind = "geo"
+	OpVector     OpKind = "vector"
+	OpAddPoints  OpKind = "add_points"
+	OpRange      OpKind = "range"
+	OpRollup     OpKind = "rollup"
+	OpHistory    OpKind = "history"
+	OpTTL        OpKind = "ttl"
+	OpExpire     OpKind = "expire"
+	OpExport     OpKind = "export"
+	OpImport     OpKind = "import"
+	OpAudit      OpKind = "audit"
+	OpRotateKeys OpKind = "rotate_keys"
+	OpBackup     OpKind = "backup"
+	OpRestore    OpKind = "restore"
+	OpRebuild    OpKind = "rebuild"
+)
+
+// Event names something that slows the operations down
+type Event string
+
+const (
+	// EventConflict is counted when a transaction fails with ErrConflict
+	EventConflict Event = "conflict"
+	// EventConflictRetry is counted when a transaction is retried after a conflict
+	EventConflictRetry Event = "conflict_retry"
+	// EventLockContention is counted when an operation fails as the item is locked
+	EventLockContention Event = "lock_contention"
+	// EventVersionConflict is counted when a conditional write fails with ErrVersionConflict
+	EventVersionConflict Event = "version_conflict"
+	// EventKeyRetry is counted when a generated key is taken already
+	EventKeyRetry Event = "key_retry"
+)
+
+// Metrics receives the measurements of a store. The methods are called
+// concurrently, right after the operations, and should return quickly
+type Metrics interface {
+	// ObserveOp is called when an operation on the table completes
+	ObserveOp(table string, op OpKind, d time.Duration, err error)
+	// Count is called when the event happens in the table
+	Count(table string, event Event)
+}
+
+// sizeCollector is implemented by the metrics which report the size of the
+// stores. The size function of a store replaces the one it had, as the
+// handles of a store share the store, and nil removes it
+type sizeCollector interface {
+	collectSizes(store *shared, fn func() (lsm int64, vlog int64, err error))
+}
+
+type metricsHolder struct {
+	m Metrics
+}
+
+// WithMetrics sends the measurements of every table of the store to m
+func (s *Sett) WithMetrics(m Metrics) *Sett {
+	if sc, ok := s.metrics().(sizeCollector); ok {
+		sc.collectSizes(s.shared, nil)
+	}
+	s.shared.metrics.Store(&metricsHolder{m: m})
+	if sc, ok := m.(sizeCollector); ok {
+		sc.collectSizes(s.shared, s.Size)
+	}
+	return s
+}
+
+func (s *Sett) metrics() Metrics {
+	h, _ := s.shared.metrics.Load().(*metricsHolder)
+	if h == nil {
+		return nil
+	}
+	return h.m
+}
+
+func (s *Sett) count(event Event) {
+	if m := s.metrics(); m != nil {
+		m.Count(s.table, event)
+	}
+}
+
+// Size returns the size of the LSM tree and of the value log of a
+// badger store, as last computed by badger
+func (s *Sett) Size() (lsm int64, vlog int64, err error) {
+	db, err := s.badger()
+	if err != nil {
+		return 0, 0, err
+	}
+	lsm, vlog = db.Size()
+	return lsm, vlog, nil
+}
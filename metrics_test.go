@@ -0,0 +1,144 @@
+package sett_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type opRecord struct {
+	table string
+	op    sett.OpKind
+	err   error
+}
+
+type testMetrics struct {
+	mu     sync.Mutex
+	ops    []opRecord
+	events map[sett.Event]int
+}
+
+func (m *testMetrics) ObserveOp(table string, op sett.OpKind, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ops = append(m.ops, opRecord{table, op, err})
+}
+
+func (m *testMetrics) Count(table string, event sett.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[event]++
+}
+
+func TestMetrics(t *testing.T) {
+	gob.Register(&TaskObj{})
+	s := setttest.New(t)
+	m := &testMetrics{events: make(map[sett.Event]int)}
+	s.WithMetrics(m)
+
+	tbl := s.Table("tasks")
+	k, _ := tbl.Insert(&TaskObj{ID: 1})
+	tbl.Get(k)
+	tbl.Lock(k)
+	tbl.Lock(k)
+	tbl.SetStr("s", "v")
+	tbl.Get("missing")
+
+	want := []sett.OpKind{sett.OpInsert, sett.OpGet, sett.OpLock, sett.OpLock, sett.OpSet, sett.OpGet}
+	if len(m.ops) != len(want) {
+		t.Fatalf("Expected ops %v, got %v", want, m.ops)
+	}
+	for i, op := range want {
+		if m.ops[i].op != op || m.ops[i].table != "tasks" {
+			t.Errorf("Expected %s on tasks, got %v", op, m.ops[i])
+		}
+	}
+	if m.ops[2].err != nil || !errors.Is(m.ops[3].err, sett.ErrLocked) {
+		t.Errorf("Lock errors not reported %v %v", m.ops[2].err, m.ops[3].err)
+	}
+	if !errors.Is(m.ops[5].err, sett.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound reported, got %v", m.ops[5].err)
+	}
+	if m.events[sett.EventLockContention] != 1 {
+		t.Errorf("Expected lock contention counted, got %v", m.events)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	s := setttest.NewOnDisk(t)
+	pm := sett.NewPrometheusMetrics("")
+	s.WithMetrics(pm)
+	s.Table("t\"1").SetStr("k", "v")
+	s.Table("t\"1").GetStr("k")
+	s.Table("t\"1").GetStr("missing")
+
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, line := range []string{
+		`sett_operations_total{table="t\"1",op="get"} 2`,
+		`sett_operation_errors_total{table="t\"1",op="get"} 1`,
+		`sett_operation_duration_seconds_count{table="t\"1",op="set"} 1`,
+		`sett_operation_duration_seconds_bucket{table="t\"1",op="set",le="+Inf"} 1`,
+		"# TYPE sett_lsm_size_bytes gauge",
+		"sett_vlog_size_bytes ",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Missing %q in\n%s", line, out)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := pm.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d %v for %d bytes", n, err, buf.Len())
+	}
+}
+
+func TestPrometheusSizes(t *testing.T) {
+	// the sizes are known once the store is reopened
+	dir := filepath.Join(t.TempDir(), "db")
+	first, err := sett.Open(sett.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		first.SetStr(fmt.Sprint(i), strings.Repeat("v", 100))
+	}
+	first.Close()
+	s := setttest.OpenDir(t, dir)
+	pm := sett.NewPrometheusMetrics("")
+	s.WithMetrics(pm)
+	s.Table("other").WithMetrics(pm)
+	closed := setttest.NewOnDisk(t)
+	closed.WithMetrics(pm)
+	closed.Close()
+	s.SetStr("k", "v")
+
+	var buf bytes.Buffer
+	_, err = pm.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed %v", err)
+	}
+	lsm, vlog, err := s.Size()
+	if err != nil || lsm+vlog == 0 {
+		t.Fatalf("Size failed %d %d %v", lsm, vlog, err)
+	}
+	for _, line := range []string{
+		fmt.Sprintf("sett_lsm_size_bytes %d\n", lsm),
+		fmt.Sprintf("sett_vlog_size_bytes %d\n", vlog),
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected the size of the store counted once, missing %q in\n%s", line, buf.String())
+		}
+	}
+}
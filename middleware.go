@@ -0,0 +1,78 @@
+package sett
+
+import (
+	"context"
+)
+
+// Op is an operation on a table, as seen by the middleware
+type Op struct {
+	Ctx  context.Context
+	Kind OpKind
+	// Table is the table of the handle. Changing it has no effect
+	Table string
+	// Key is the key of the operations on a single value. It is the
+	// series for AddPoints, Range and Rollups, and the field
+	// for RebuildIndex and VectorIndex.Rebuild. It is empty for Insert,
+	// which generates it, for GetMany, SetMany and DeleteMany, which
+	// carry their keys in Value, and for the other operations on the
+	// whole table, like Keys, Filter, Drop, Query, Search or Backup
+	Key string
+	// Value depends on the Kind. Changing it must keep its type:
+	//   - OpSet, OpInsert: the value written, a string for SetStr,
+	//     else the value given to Set, SetStruct, SetIfAbsent,
+	//     CompareAndSwap or Batch.Set
+	//   - OpGetMany, OpDeleteMany: the keys, a []string
+	//   - OpSetMany: the values, a map[string]interface{}
+	//   - OpSearch: the query, a string
+	//   - OpVector: the vector given to Nearest, a []float32
+	//   - OpAddPoints: the points, a []Point
+	// It is nil for the other operations
+	Value interface{}
+
+	call *opCall
+}
+
+// Result returns the outcome of the operation once next returned:
+// the value read by Get, Cut and Update, the keys found by Keys and
+// Filter, or the key generated by Insert
+func (op Op) Result() interface{} {
+	if op.call == nil {
+		return nil
+	}
+	return op.call.result
+}
+
+// Handler runs the operation
+type Handler func(op Op) error
+
+// Middleware wraps the operations on the store. It calls next to run the
+// operation, possibly with a changed Ctx, Key or Value, or returns an
+// error to refuse it
+type Middleware func(op Op, next Handler) error
+
+// Use adds the middleware around every operation on every table of the store.
+// The middleware added first is the outermost
+func (s *Sett) Use(mw ...Middleware) *Sett {
+	s.shared.mu.Lock()
+	defer s.shared.mu.Unlock()
+	// Copied on write, so that the operations in progress keep their chain
+	chain := make([]Middleware, 0, len(s.shared.middleware)+len(mw))
+	chain = append(chain, s.shared.middleware...)
+	chain = append(chain, mw...)
+	s.shared.middleware = chain
+	return s
+}
+
+// chain returns h wrapped by the middleware of the store
+func (s *Sett) chain(h Handler) Handler {
+	s.shared.mu.Lock()
+	mws := s.shared.middleware
+	s.shared.mu.Unlock()
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw, next := mws[i], h
+		h = func(op Op) error {
+			return mw(op, next)
+		}
+	}
+	return h
+}
@@ -0,0 +1,105 @@
+package sett_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	gob.Register(&TaskObj{})
+	s := setttest.New(t)
+
+	var order []string
+	var reads []interface{}
+	errReadOnly := errors.New("read only key")
+	s.Use(
+		func(op sett.Op, next sett.Handler) error {
+			order = append(order, "outer")
+			err := next(op)
+			if op.Kind == sett.OpGet {
+				reads = append(reads, op.Result())
+			}
+			return err
+		},
+		func(op sett.Op, next sett.Handler) error {
+			order = append(order, "inner")
+			if op.Kind == sett.OpSet && strings.HasPrefix(op.Key, "ro_") {
+				return errReadOnly
+			}
+			// Keys are kept per tenant
+			if op.Key != "" {
+				op.Key = "acme/" + op.Key
+			}
+			if s, ok := op.Value.(string); ok {
+				op.Value = strings.TrimSpace(s)
+			}
+			return next(op)
+		},
+	)
+
+	tbl := s.Table("t")
+	err := tbl.SetStr("k", "  v  ")
+	if err != nil {
+		t.Fatalf("Set failed %v", err)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("Middleware ran in order %v", order)
+	}
+	v, err := tbl.GetStr("k")
+	if err != nil || v != "v" {
+		t.Errorf("Expected the trimmed value, got %q %v", v, err)
+	}
+	if len(reads) != 1 || reads[0] != "v" {
+		t.Errorf("Middleware didn't see the result of Get %v", reads)
+	}
+	keys, _ := tbl.Keys()
+	if len(keys) != 1 || keys[0] != "acme/k" {
+		t.Errorf("Key was not rewritten %v", keys)
+	}
+	err = tbl.SetStr("ro_k", "v")
+	if !errors.Is(err, errReadOnly) || tbl.HasKey("acme/ro_k") {
+		t.Errorf("Middleware didn't refuse the write %v", err)
+	}
+
+	var inserted interface{}
+	s.Use(func(op sett.Op, next sett.Handler) error {
+		err := next(op)
+		if op.Kind == sett.OpInsert {
+			inserted = op.Result()
+		}
+		return err
+	})
+	k, err := tbl.Insert(&TaskObj{ID: 1})
+	if err != nil || inserted != k {
+		t.Errorf("Expected the generated key %s as result, got %v %v", k, inserted, err)
+	}
+}
+
+func TestMiddlewareKinds(t *testing.T) {
+	s := setttest.New(t)
+	var kinds []sett.OpKind
+	s.Use(func(op sett.Op, next sett.Handler) error {
+		kinds = append(kinds, op.Kind)
+		return next(op)
+	})
+	tbl := s.Table("t")
+	tbl.SetStr("k", "v")
+	tbl.Touch("k", time.Hour)
+	tbl.TTL("k")
+	tbl.Persist("k")
+	tbl.GetOrCompute("k", time.Hour, func() (interface{}, error) { return "other", nil })
+	var buf bytes.Buffer
+	s.ExportTable(&buf, "t")
+	s.ImportTable(&buf, sett.ImportSkip)
+	want := []sett.OpKind{sett.OpSet, sett.OpExpire, sett.OpTTL, sett.OpExpire, sett.OpGet, sett.OpExport, sett.OpImport}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("Expected the middleware to see %v, got %v", want, kinds)
+	}
+}
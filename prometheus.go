@@ -0,0 +1,214 @@
+package sett
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds,
+// of the operation latency histogram
+var DefaultLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// PrometheusMetrics implements Metrics and serves the measurements
+// in the Prometheus text format, without depending on the Prometheus client
+//
+//	pm := sett.NewPrometheusMetrics("myapp")
+//	s.WithMetrics(pm)
+//	http.Handle("/metrics", pm)
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+
+	mu     sync.Mutex
+	ops    map[opLabels]*opStats
+	events map[eventLabels]uint64
+	sizes  map[*shared]func() (int64, int64, error)
+}
+
+type opLabels struct {
+	table string
+	op    OpKind
+}
+
+type eventLabels struct {
+	table string
+	event Event
+}
+
+type opStats struct {
+	count   uint64
+	errors  uint64
+	sum     float64
+	buckets []uint64
+}
+
+// NewPrometheusMetrics returns the metrics named with the namespace
+// prefix, "sett" if namespace is empty
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	if len(namespace) == 0 {
+		namespace = "sett"
+	}
+	return &PrometheusMetrics{
+		namespace: namespace,
+		buckets:   DefaultLatencyBuckets,
+		ops:       make(map[opLabels]*opStats),
+		events:    make(map[eventLabels]uint64),
+		sizes:     make(map[*shared]func() (int64, int64, error)),
+	}
+}
+
+func (pm *PrometheusMetrics) ObserveOp(table string, op OpKind, d time.Duration, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	l := opLabels{table: table, op: op}
+	st, ok := pm.ops[l]
+	if !ok {
+		st = &opStats{buckets: make([]uint64, len(pm.buckets))}
+		pm.ops[l] = st
+	}
+	st.count++
+	if err != nil {
+		st.errors++
+	}
+	secs := d.Seconds()
+	st.sum += secs
+	for i, b := range pm.buckets {
+		if secs <= b {
+			st.buckets[i]++
+		}
+	}
+}
+
+func (pm *PrometheusMetrics) Count(table string, event Event) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.events[eventLabels{table: table, event: event}]++
+}
+
+func (pm *PrometheusMetrics) collectSizes(store *shared, fn func() (int64, int64, error)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if fn == nil {
+		delete(pm.sizes, store)
+		return
+	}
+	pm.sizes[store] = fn
+}
+
+// ServeHTTP writes the metrics for a Prometheus scrape
+func (pm *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, err := pm.WriteTo(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (pm *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	pm.mu.Lock()
+	ops := make([]opLabels, 0, len(pm.ops))
+	for l := range pm.ops {
+		ops = append(ops, l)
+	}
+	stats := make(map[opLabels]opStats, len(pm.ops))
+	for l, st := range pm.ops {
+		cp := *st
+		cp.buckets = append([]uint64{}, st.buckets...)
+		stats[l] = cp
+	}
+	events := make([]eventLabels, 0, len(pm.events))
+	counts := make(map[eventLabels]uint64, len(pm.events))
+	for l, n := range pm.events {
+		events = append(events, l)
+		counts[l] = n
+	}
+	sizes := make([]func() (int64, int64, error), 0, len(pm.sizes))
+	for _, fn := range pm.sizes {
+		sizes = append(sizes, fn)
+	}
+	pm.mu.Unlock()
+
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].table != ops[j].table {
+			return ops[i].table < ops[j].table
+		}
+		return ops[i].op < ops[j].op
+	})
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].table != events[j].table {
+			return events[i].table < events[j].table
+		}
+		return events[i].event < events[j].event
+	})
+
+	// bw keeps the first error, so the writes after it are skipped
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	name := func(n string) string {
+		return pm.namespace + "_" + n
+	}
+	header := func(n, typ, help string) {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", name(n), help, name(n), typ)
+	}
+
+	header("operations_total", "counter", "Operations on the tables.")
+	for _, l := range ops {
+		fmt.Fprintf(cw, "%s{table=%s,op=%s} %d\n", name("operations_total"), quote(l.table), quote(string(l.op)), stats[l].count)
+	}
+	header("operation_errors_total", "counter", "Operations on the tables which failed.")
+	for _, l := range ops {
+		fmt.Fprintf(cw, "%s{table=%s,op=%s} %d\n", name("operation_errors_total"), quote(l.table), quote(string(l.op)), stats[l].errors)
+	}
+	header("operation_duration_seconds", "histogram", "Latency of the operations on the tables.")
+	for _, l := range ops {
+		st := stats[l]
+		labels := fmt.Sprintf("table=%s,op=%s", quote(l.table), quote(string(l.op)))
+		for i, b := range pm.buckets {
+			fmt.Fprintf(cw, "%s_bucket{%s,le=\"%s\"} %d\n", name("operation_duration_seconds"), labels, formatFloat(b), st.buckets[i])
+		}
+		fmt.Fprintf(cw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name("operation_duration_seconds"), labels, st.count)
+		fmt.Fprintf(cw, "%s_sum{%s} %s\n", name("operation_duration_seconds"), labels, formatFloat(st.sum))
+		fmt.Fprintf(cw, "%s_count{%s} %d\n", name("operation_duration_seconds"), labels, st.count)
+	}
+	header("events_total", "counter", "Conflicts, lock contention and key generation retries.")
+	for _, l := range events {
+		fmt.Fprintf(cw, "%s{table=%s,event=%s} %d\n", name("events_total"), quote(l.table), quote(string(l.event)), counts[l])
+	}
+
+	var lsm, vlog int64
+	var haveSizes bool
+	for _, fn := range sizes {
+		l, v, err := fn()
+		if err != nil {
+			continue
+		}
+		lsm += l
+		vlog += v
+		haveSizes = true
+	}
+	if haveSizes {
+		header("lsm_size_bytes", "gauge", "Size of the LSM tree of the store.")
+		fmt.Fprintf(cw, "%s %d\n", name("lsm_size_bytes"), lsm)
+		header("vlog_size_bytes", "gauge", "Size of the value log of the store.")
+		fmt.Fprintf(cw, "%s %d\n", name("vlog_size_bytes"), vlog)
+	}
+
+	return cw.n, bw.Flush()
+}
+
+func quote(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,392 @@
+package sett
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QueryResult is a value found by a query
+type QueryResult struct {
+	Key string
+	// Value is the decoded value, or a map of the selected fields
+	// when the query has a Select
+	Value interface{}
+}
+
+// Query finds the values of a table matching conditions on their fields.
+// The conditions are checked on the decoded values; a query uses an index
+// created with CreateIndex for one of its conditions when there is one,
+// and otherwise scans the table, or the keys with the prefix given to
+// KeyPrefix.
+//
+//	results, err := s.Table("orders").Query().
+//		Where("Status", "=", "open").
+//		OrderBy("CreatedAt", true).
+//		Limit(20).Offset(40).
+//		Run()
+type Query struct {
+	s         *Sett
+	conds     []queryCond
+	keyPrefix string
+	orders    []queryOrder
+	limit     int
+	offset    int
+	fields    []string
+	err       error
+}
+
+type queryCond struct {
+	field string
+	op    string
+	value interface{}
+}
+
+type queryOrder struct {
+	field string
+	desc  bool
+}
+
+// Query returns a query on the table
+func (s *Sett) Query() *Query {
+	return &Query{s: s}
+}
+
+// Where adds a condition on a field of the values, a field name or a path
+// like "Customer.Name". op is one of =, !=, <, <=, >, >= or prefix, the
+// latter matching the strings starting with value. Numbers of any type
+// compare with each other exactly. Values where the field is missing, or doesn't
+// compare with value, don't match
+func (q *Query) Where(field string, op string, value interface{}) *Query {
+	switch op {
+	case "==":
+		op = "="
+	case "=", "!=", "<", "<=", ">", ">=":
+	case "prefix":
+		if _, ok := value.(string); !ok {
+			q.err = fmt.Errorf("Where %s prefix needs a string, got %T", field, value)
+		}
+	default:
+		q.err = fmt.Errorf("Where %s: unknown operator %s", field, op)
+	}
+	q.conds = append(q.conds, queryCond{field: field, op: op, value: value})
+	return q
+}
+
+// KeyPrefix limits the query to the keys starting with the prefix
+func (q *Query) KeyPrefix(prefix string) *Query {
+	q.keyPrefix = prefix
+	return q
+}
+
+// OrderBy sorts the results by the field, in descending order if desc
+// is true. Further calls sort the results with the same values of the
+// previous fields. Without OrderBy, the results are in key order
+func (q *Query) OrderBy(field string, desc bool) *Query {
+	q.orders = append(q.orders, queryOrder{field: field, desc: desc})
+	return q
+}
+
+// Limit returns at most n results
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset skips the first n results
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+// Select returns only the fields of the values,
+// as a map[string]interface{} keyed by field
+func (q *Query) Select(fields ...string) *Query {
+	q.fields = fields
+	return q
+}
+
+// queryPlan is how a query finds the candidate values
+type queryPlan struct {
+	// index is the index used for cond, nil to scan the keys
+	index *fieldIndex
+	cond  queryCond
+}
+
+func (q *Query) plan() queryPlan {
+	var p queryPlan
+	for _, c := range q.conds {
+		if c.op == "!=" {
+			continue
+		}
+		fi := q.s.fieldIndex(c.field)
+		if fi == nil {
+			continue
+		}
+		if _, ok := encodeIndexValue(c.value); !ok {
+			continue
+		}
+		// An equality narrows the candidates down the most
+		if p.index == nil || (c.op == "=" && p.cond.op != "=") {
+			p = queryPlan{index: fi, cond: c}
+		}
+	}
+	return p
+}
+
+// Explain describes how the query would run
+func (q *Query) Explain() string {
+	var b strings.Builder
+	p := q.plan()
+	switch {
+	case p.index != nil:
+		fmt.Fprintf(&b, "index scan %s.%s %s %#v\n", q.s.table, p.cond.field, p.cond.op, p.cond.value)
+	case len(q.keyPrefix) > 0:
+		fmt.Fprintf(&b, "prefix scan %s %q\n", q.s.table, q.keyPrefix)
+	default:
+		fmt.Fprintf(&b, "full scan %s\n", q.s.table)
+	}
+	if p.index != nil && len(q.keyPrefix) > 0 {
+		fmt.Fprintf(&b, "key prefix %q\n", q.keyPrefix)
+	}
+	for _, c := range q.conds {
+		fmt.Fprintf(&b, "filter %s %s %#v\n", c.field, c.op, c.value)
+	}
+	for _, o := range q.orders {
+		dir := "asc"
+		if o.desc {
+			dir = "desc"
+		}
+		fmt.Fprintf(&b, "order by %s %s\n", o.field, dir)
+	}
+	if len(q.orders) == 0 && p.index != nil {
+		b.WriteString("order by key\n")
+	}
+	if q.offset > 0 {
+		fmt.Fprintf(&b, "offset %d\n", q.offset)
+	}
+	if q.limit > 0 {
+		fmt.Fprintf(&b, "limit %d\n", q.limit)
+	}
+	if len(q.fields) > 0 {
+		fmt.Fprintf(&b, "select %s\n", strings.Join(q.fields, ", "))
+	}
+	return b.String()
+}
+
+// Run returns the values matching the query
+func (q *Query) Run() ([]QueryResult, error) {
+	return q.RunCtx(context.Background())
+}
+
+// RunCtx is Run with a context. The scan stops when ctx is done
+func (q *Query) RunCtx(ctx context.Context) (results []QueryResult, err error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	err = q.s.do(ctx, OpQuery, "", nil, func(c *opCall) error {
+		defer func() { c.result = results }()
+		return q.s.db.View(func(txn Txn) error {
+			var err error
+			results, err = q.run(c, txn)
+			return err
+		})
+	})
+	return results, err
+}
+
+func (q *Query) run(c *opCall, txn Txn) ([]QueryResult, error) {
+	p := q.plan()
+	// Results come in key order from a key scan,
+	// so it can stop once it has enough of them
+	enough := -1
+	if q.limit > 0 && len(q.orders) == 0 && p.index == nil {
+		enough = q.offset + q.limit
+	}
+	var results []QueryResult
+	visit := func(key string, item Item) (bool, error) {
+		if err := c.canceled(); err != nil {
+			return false, err
+		}
+		si := NewSettItem(q.s, txn, key)
+		si.call = c
+		v, err := si.value(item)
+		if err != nil {
+			return false, err
+		}
+		if q.match(v) {
+			results = append(results, QueryResult{Key: key, Value: v})
+		}
+		return len(results) != enough, nil
+	}
+	var err error
+	if p.index != nil {
+		err = q.indexScan(txn, p, visit)
+	} else {
+		err = q.s.scanTable(txn, q.keyPrefix, false, visit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(q.orders) > 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			return q.less(results[i].Value, results[j].Value)
+		})
+	} else if p.index != nil {
+		sort.Slice(results, func(i, j int) bool {
+			return results[i].Key < results[j].Key
+		})
+	}
+	if q.offset > 0 {
+		if q.offset >= len(results) {
+			return nil, nil
+		}
+		results = results[q.offset:]
+	}
+	if q.limit > 0 && len(results) > q.limit {
+		results = results[:q.limit]
+	}
+	if len(q.fields) > 0 {
+		for i := range results {
+			m := make(map[string]interface{}, len(q.fields))
+			for _, f := range q.fields {
+				if fv, ok := fieldValue(results[i].Value, f); ok {
+					m[f] = fv
+				}
+			}
+			results[i].Value = m
+		}
+	}
+	return results, nil
+}
+
+// indexScan visits the keys the index has for the condition of the plan
+func (q *Query) indexScan(txn Txn, p queryPlan, visit func(key string, item Item) (bool, error)) error {
+	prefix := p.index.prefix()
+	enc, _ := encodeIndexValue(p.cond.value)
+	// All the values of the same type as the condition's
+	typePrefix := append(append([]byte{}, prefix...), enc[0])
+	seek := typePrefix
+	var within func(v []byte) bool
+	switch p.cond.op {
+	case "=":
+		seek = append(append([]byte{}, prefix...), enc...)
+		within = func(v []byte) bool { return bytes.HasPrefix(v, enc) }
+	case "prefix":
+		// The encoded string without its end marker
+		start := enc[:len(enc)-2]
+		seek = append(append([]byte{}, prefix...), start...)
+		within = func(v []byte) bool { return bytes.HasPrefix(v, start) }
+	case ">", ">=":
+		seek = append(append([]byte{}, prefix...), enc...)
+		within = func(v []byte) bool { return true }
+	case "<", "<=":
+		// with the values of the same encoding, which
+		// match tells apart for large integers
+		within = func(v []byte) bool { return bytes.Compare(v, enc) < 0 || bytes.HasPrefix(v, enc) }
+	}
+	it := txn.NewIterator(IteratorOptions{Prefix: typePrefix})
+	defer it.Close()
+	for it.Seek(seek); it.Valid(); it.Next() {
+		entry := it.Item()
+		if !within(entry.Key()[len(prefix):]) {
+			break
+		}
+		var key string
+		err := entry.Value(func(val []byte) error {
+			key = string(val)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(key, q.keyPrefix) {
+			continue
+		}
+		item, err := currentItem(txn, []byte(q.s.makeKey(key)))
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			continue
+		}
+		more, err := visit(key, item)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// match tells if the value meets all the conditions
+func (q *Query) match(v interface{}) bool {
+	for _, c := range q.conds {
+		fv, ok := fieldValue(v, c.field)
+		if !ok {
+			return false
+		}
+		if c.op == "prefix" {
+			s, ok := normalize(fv)
+			if str, isStr := s.(string); !ok || !isStr || !strings.HasPrefix(str, c.value.(string)) {
+				return false
+			}
+			continue
+		}
+		cmp, ok := compareValues(fv, c.value)
+		if !ok {
+			return false
+		}
+		var m bool
+		switch c.op {
+		case "=":
+			m = cmp == 0
+		case "!=":
+			m = cmp != 0
+		case "<":
+			m = cmp < 0
+		case "<=":
+			m = cmp <= 0
+		case ">":
+			m = cmp > 0
+		case ">=":
+			m = cmp >= 0
+		}
+		if !m {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders the values by the fields of OrderBy. Values missing
+// a field, or where it doesn't compare, come last
+func (q *Query) less(a, b interface{}) bool {
+	for _, o := range q.orders {
+		fa, okA := fieldValue(a, o.field)
+		fb, okB := fieldValue(b, o.field)
+		cmp, ok := 0, false
+		if okA && okB {
+			cmp, ok = compareValues(fa, fb)
+		}
+		if !ok {
+			_, aOk := normalize(fa)
+			_, bOk := normalize(fb)
+			aOk, bOk = aOk && okA, bOk && okB
+			if aOk != bOk {
+				return aOk
+			}
+			continue
+		}
+		if cmp == 0 {
+			continue
+		}
+		if o.desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type OrderCustomer struct {
+	Name string
+}
+
+type OrderObj struct {
+	Status    string
+	Amount    float64
+	Qty       int
+	CreatedAt time.Time
+	Customer  OrderCustomer
+}
+
+func TestQuery(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testQuery(t, b.open(t))
+		})
+	}
+}
+
+func queryKeys(t *testing.T, q *sett.Query) []string {
+	results, err := q.Run()
+	if err != nil {
+		t.Fatalf("Query failed %v\n%s", err, q.Explain())
+	}
+	keys := []string{}
+	for _, r := range results {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+func testQuery(t *testing.T, s *sett.Sett) {
+	gob.Register(&OrderObj{})
+	orders := s.Table("orders")
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	statuses := []string{"open", "shipped", "closed"}
+	for i := 0; i < 30; i++ {
+		orders.SetStruct(fmt.Sprintf("o%02d", i), &OrderObj{
+			Status:    statuses[i%3],
+			Amount:    float64(i) * 10,
+			Qty:       i % 5,
+			CreatedAt: base.Add(time.Duration(i) * time.Hour),
+			Customer:  OrderCustomer{Name: fmt.Sprintf("cust%d", i%4)},
+		})
+	}
+	orders.SetStr("note", "not an order")
+	s.Table("orders2").SetStruct("o00", &OrderObj{Status: "open"})
+
+	open := func() *sett.Query {
+		return orders.Query().Where("Status", "=", "open").OrderBy("CreatedAt", true).Limit(3).Offset(2)
+	}
+	want := []string{"o21", "o18", "o15"}
+	if !strings.HasPrefix(open().Explain(), "full scan") {
+		t.Errorf("Expected a full scan without an index, got\n%s", open().Explain())
+	}
+	scanned := queryKeys(t, open())
+	if !reflect.DeepEqual(scanned, want) {
+		t.Errorf("Expected %v, got %v", want, scanned)
+	}
+
+	for _, f := range []string{"Status", "Amount", "Customer.Name"} {
+		err := orders.CreateIndex(f)
+		if err != nil {
+			t.Fatalf("CreateIndex %s failed %v", f, err)
+		}
+	}
+	if !strings.HasPrefix(open().Explain(), "index scan orders.Status = ") {
+		t.Errorf("Expected an index scan, got\n%s", open().Explain())
+	}
+	indexed := queryKeys(t, open())
+	if !reflect.DeepEqual(indexed, want) {
+		t.Errorf("Expected %v from the index, got %v", want, indexed)
+	}
+
+	keys := queryKeys(t, orders.Query().Where("Amount", ">=", 250).Where("Qty", "!=", 0))
+	if !reflect.DeepEqual(keys, []string{"o26", "o27", "o28", "o29"}) {
+		t.Errorf("Unexpected range results %v", keys)
+	}
+	keys = queryKeys(t, orders.Query().Where("Amount", "<", 30))
+	if !reflect.DeepEqual(keys, []string{"o00", "o01", "o02"}) {
+		t.Errorf("Unexpected results below 30 %v", keys)
+	}
+	keys = queryKeys(t, orders.Query().Where("Customer.Name", "prefix", "cust3").Limit(2))
+	if !reflect.DeepEqual(keys, []string{"o03", "o07"}) {
+		t.Errorf("Unexpected prefix results %v", keys)
+	}
+
+	q := orders.Query().KeyPrefix("o1").Where("Qty", ">", 2)
+	if !strings.HasPrefix(q.Explain(), "prefix scan") {
+		t.Errorf("Expected a prefix scan, got\n%s", q.Explain())
+	}
+	keys = queryKeys(t, q)
+	if !reflect.DeepEqual(keys, []string{"o13", "o14", "o18", "o19"}) {
+		t.Errorf("Unexpected key prefix results %v", keys)
+	}
+
+	results, _ := orders.Query().Where("Status", "=", "closed").Select("Amount", "Customer.Name").Limit(1).Run()
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Value, map[string]interface{}{"Amount": 20.0, "Customer.Name": "cust2"}) {
+		t.Errorf("Unexpected projection %+v", results)
+	}
+
+	orders.SetStruct("o00", &OrderObj{Status: "closed", Amount: 1})
+	orders.Delete("o03")
+	keys = queryKeys(t, orders.Query().Where("Status", "=", "open").Limit(2))
+	if !reflect.DeepEqual(keys, []string{"o06", "o09"}) {
+		t.Errorf("The index didn't follow the writes %v", keys)
+	}
+
+	err := orders.DropIndex("Status")
+	if err != nil {
+		t.Fatalf("DropIndex failed %v", err)
+	}
+	if !reflect.DeepEqual(orders.Indexes(), []string{"Amount", "Customer.Name"}) {
+		t.Errorf("Unexpected indexes %v", orders.Indexes())
+	}
+	if strings.HasPrefix(open().Explain(), "index scan") {
+		t.Errorf("Dropped index still used\n%s", open().Explain())
+	}
+
+	_, err = orders.Query().Where("Status", "like", "x").Run()
+	if err == nil {
+		t.Errorf("Expected an error for an unknown operator")
+	}
+	allKeys, _ := s.Keys()
+	for _, k := range allKeys {
+		if k != "" && k[0] == 0xff {
+			t.Errorf("Index entries listed as keys")
+		}
+	}
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	gob.Register(&PlaceObj{})
+	dir := filepath.Join(t.TempDir(), "db")
+	createIndexes := func(places *sett.Sett) {
+		err := places.CreateIndex("Name")
+		if err == nil {
+			err = places.CreateTextIndex(sett.TextIndexOptions{Fields: []string{"Name"}})
+		}
+		if err == nil {
+			err = places.CreateGeoIndex(func(k string, v interface{}) (float64, float64, bool) {
+				p, ok := v.(*PlaceObj)
+				if !ok {
+					return 0, 0, false
+				}
+				return p.Lat, p.Lon, true
+			})
+		}
+		if err != nil {
+			t.Fatalf("Creating the indexes failed %v", err)
+		}
+	}
+	first, err := sett.Open(sett.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+	createIndexes(first.Table("places"))
+	first.Table("places").SetStruct("eiffel", &PlaceObj{Name: "Eiffel Tower", Lat: 48.8584, Lon: 2.2945})
+	first.Close()
+	// written by a process which didn't create the indexes
+	second, err := sett.Open(sett.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+	second.Table("places").SetStruct("louvre", &PlaceObj{Name: "Louvre", Lat: 48.8606, Lon: 2.3376})
+	second.Close()
+
+	s := setttest.OpenDir(t, dir)
+	places := s.Table("places")
+	err = places.RebuildIndex("Name")
+	if err == nil {
+		t.Errorf("Expected RebuildIndex to need the index")
+	}
+	createIndexes(places)
+	check := func(want int) {
+		t.Helper()
+		keys := queryKeys(t, places.Query().Where("Name", "=", "Louvre"))
+		if len(keys) != want {
+			t.Errorf("Expected %d values from the field index, got %v", want, keys)
+		}
+		results, err := places.Search("louvre", 0)
+		if err != nil || len(results) != want {
+			t.Errorf("Expected %d values from the text index, got %v %v", want, results, err)
+		}
+		near, err := places.Nearby(48.8606, 2.3376, 100, 0)
+		if keys := geoKeys(t, near, err); len(keys) != want {
+			t.Errorf("Expected %d values from the geo index, got %v", want, keys)
+		}
+	}
+	check(0)
+	for _, rebuild := range []func() error{
+		func() error { return places.RebuildIndex("Name") },
+		places.RebuildTextIndex,
+		places.RebuildGeoIndex,
+	} {
+		err = rebuild()
+		if err != nil {
+			t.Fatalf("Rebuild failed %v", err)
+		}
+	}
+	check(1)
+	results, _ := places.Search("tower", 0)
+	if len(results) != 1 || results[0].Key != "eiffel" {
+		t.Errorf("Expected the values indexed before to stay indexed, got %v", results)
+	}
+}
+
+type CounterObj struct {
+	ID uint64
+}
+
+func TestQueryLargeIntegers(t *testing.T) {
+	gob.Register(&CounterObj{})
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			counters := s.Table("counters")
+			counters.SetStruct("a", &CounterObj{ID: 1 << 53})
+			counters.SetStruct("b", &CounterObj{ID: 1<<53 + 1})
+			counters.SetStruct("c", &CounterObj{ID: 1<<63 + 1})
+			check := func(label string) {
+				for _, tc := range []struct {
+					op    string
+					value interface{}
+					want  []string
+				}{
+					{"=", int64(1<<53 + 1), []string{"b"}},
+					{"=", uint64(1 << 53), []string{"a"}},
+					{"<", int64(1<<53 + 1), []string{"a"}},
+					{">", float64(1 << 53), []string{"b", "c"}},
+					{"=", uint64(1<<63 + 1), []string{"c"}},
+					{">=", int64(-1), []string{"a", "b", "c"}},
+				} {
+					keys := queryKeys(t, counters.Query().Where("ID", tc.op, tc.value))
+					if !reflect.DeepEqual(keys, tc.want) {
+						t.Errorf("%s: ID %s %v: expected %v, got %v", label, tc.op, tc.value, tc.want, keys)
+					}
+				}
+			}
+			check("scan")
+			err := counters.CreateIndex("ID")
+			if err != nil {
+				t.Fatalf("CreateIndex failed %v", err)
+			}
+			check("index")
+		})
+	}
+}
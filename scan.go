@@ -0,0 +1,304 @@
+package sett
+
+import (
+	"context"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/dgraph-io/ristretto/z"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ScanFunc is called by ParallelScan for each item of the table
+type ScanFunc func(k string, v interface{}) error
+
+// ScanStats tells how far a scan got
+type ScanStats struct {
+	// Keys is the number of items passed to the ScanFunc
+	Keys int64
+	// Bytes is the size of their stored values
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+// ScanOption configures ParallelScan
+type ScanOption func(o *scanOptions)
+
+type scanOptions struct {
+	ordered  bool
+	progress func(ScanStats)
+	interval time.Duration
+}
+
+// ScanOrdered calls the ScanFunc in key order, from one goroutine at
+// a time. The values are still read and decoded by the workers
+func ScanOrdered() ScanOption {
+	return func(o *scanOptions) {
+		o.ordered = true
+	}
+}
+
+// ScanProgress calls fn every interval while the scan runs, and once
+// when it is done. fn is called from its own goroutine
+func ScanProgress(fn func(ScanStats), interval time.Duration) ScanOption {
+	return func(o *scanOptions) {
+		o.progress = fn
+		o.interval = interval
+	}
+}
+
+// ParallelScan calls fn for every item of the table, from workers
+// goroutines, runtime.NumCPU() if workers is 0. On badger, the
+// unordered scan runs on badger's Stream, which reads the key ranges
+// of the table in parallel. The scan stops at the first error of fn,
+// or when ctx is done, and returns that error. The items are read in
+// a single snapshot of the table.
+// Unless ScanOrdered is given, fn is called concurrently, in no
+// particular order
+func (s *Sett) ParallelScan(ctx context.Context, workers int, fn ScanFunc, opts ...ScanOption) error {
+	var o scanOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return s.do(ctx, OpScan, "", nil, func(c *opCall) error {
+		ctx, cancel := context.WithCancel(c.ctx)
+		defer cancel()
+		sc := &scanner{s: s, fn: fn, ctx: ctx, cancel: cancel, start: time.Now()}
+		if o.progress != nil && o.interval > 0 {
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sc.report(o.progress, o.interval, done)
+			}()
+			defer func() {
+				close(done)
+				wg.Wait()
+			}()
+		} else if o.progress != nil {
+			defer func() { o.progress(sc.stats()) }()
+		}
+		var err error
+		if db, dbErr := s.badger(); dbErr == nil && !o.ordered {
+			err = sc.stream(db, workers)
+		} else {
+			err = sc.pipeline(workers, o.ordered)
+		}
+		if first := sc.err(); first != nil {
+			return first
+		}
+		if err == nil {
+			err = c.ctx.Err()
+		}
+		return err
+	})
+}
+
+type scanner struct {
+	s      *Sett
+	fn     ScanFunc
+	ctx    context.Context
+	cancel context.CancelFunc
+	start  time.Time
+
+	keys  int64
+	bytes int64
+
+	mu       sync.Mutex
+	firstErr error
+}
+
+// fail records the first error and stops the scan
+func (sc *scanner) fail(err error) {
+	sc.mu.Lock()
+	if sc.firstErr == nil {
+		sc.firstErr = err
+	}
+	sc.mu.Unlock()
+	sc.cancel()
+}
+
+func (sc *scanner) err() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.firstErr
+}
+
+func (sc *scanner) stopped() bool {
+	return sc.ctx.Err() != nil
+}
+
+func (sc *scanner) stats() ScanStats {
+	return ScanStats{
+		Keys:    atomic.LoadInt64(&sc.keys),
+		Bytes:   atomic.LoadInt64(&sc.bytes),
+		Elapsed: time.Since(sc.start),
+	}
+}
+
+func (sc *scanner) report(progress func(ScanStats), interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			progress(sc.stats())
+		case <-done:
+			progress(sc.stats())
+			return
+		}
+	}
+}
+
+func (sc *scanner) decode(key string, meta byte, val []byte) (interface{}, error) {
+	return NewSettItem(sc.s, nil, key).decodeStored(meta, val)
+}
+
+// call passes the value to the ScanFunc, unless the scan is stopped
+func (sc *scanner) call(key string, v interface{}, size int) {
+	if sc.stopped() {
+		return
+	}
+	err := sc.fn(key, v)
+	if err != nil {
+		sc.fail(err)
+		return
+	}
+	atomic.AddInt64(&sc.keys, 1)
+	atomic.AddInt64(&sc.bytes, int64(size))
+}
+
+// stream scans the table with badger's Stream, calling the ScanFunc
+// from the goroutines reading the key ranges. Nothing is sent on
+func (sc *scanner) stream(db *badger.DB, workers int) error {
+	prefix := []byte(sc.s.makeKey(""))
+	st := db.NewStream()
+	st.NumGo = workers
+	st.Prefix = prefix
+	st.LogPrefix = "sett.ParallelScan"
+	st.ChooseKey = func(item *badger.Item) bool {
+		return !sc.stopped() && !isSystemKey(item.Key())
+	}
+	st.KeyToList = func(key []byte, itr *badger.Iterator) (*pb.KVList, error) {
+		item := itr.Item()
+		if item.IsDeletedOrExpired() {
+			return nil, nil
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			sc.fail(err)
+			return nil, nil
+		}
+		k := string(key[len(prefix):])
+		v, err := sc.decode(k, item.UserMeta(), val)
+		if err != nil {
+			sc.fail(err)
+			return nil, nil
+		}
+		sc.call(k, v, len(val))
+		return nil, nil
+	}
+	st.Send = func(buf *z.Buffer) error {
+		return nil
+	}
+	return st.Orchestrate(sc.ctx)
+}
+
+type scanJob struct {
+	seq  int
+	key  string
+	meta byte
+	val  []byte
+}
+
+type scanResult struct {
+	seq int
+	key string
+	v   interface{}
+	n   int
+}
+
+// pipeline reads the table with an iterator, and decodes the values
+// in the workers. Ordered, the results are passed to the ScanFunc in
+// the order they were read
+func (sc *scanner) pipeline(workers int, ordered bool) error {
+	jobs := make(chan scanJob, workers*4)
+	results := make(chan scanResult, workers*4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if sc.stopped() {
+					continue
+				}
+				v, err := sc.decode(job.key, job.meta, job.val)
+				if err != nil {
+					sc.fail(err)
+					continue
+				}
+				if ordered {
+					results <- scanResult{seq: job.seq, key: job.key, v: v, n: len(job.val)}
+				} else {
+					sc.call(job.key, v, len(job.val))
+				}
+			}
+		}()
+	}
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		pending := make(map[int]scanResult)
+		next := 0
+		for r := range results {
+			pending[r.seq] = r
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				sc.call(r.key, r.v, r.n)
+			}
+		}
+	}()
+
+	prefix := []byte(sc.s.makeKey(""))
+	err := sc.s.db.View(func(txn Txn) error {
+		defer close(jobs)
+		it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		seq := 0
+		for it.Seek(prefix); it.Valid(); it.Next() {
+			item := it.Item()
+			if isSystemKey(item.Key()) {
+				continue
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			job := scanJob{seq: seq, key: string(item.Key()[len(prefix):]), meta: item.UserMeta(), val: val}
+			select {
+			case jobs <- job:
+				seq++
+			case <-sc.ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+	wg.Wait()
+	close(results)
+	<-delivered
+	return err
+}
@@ -0,0 +1,91 @@
+package sett_test
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelScan(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testParallelScan(t, b.open(t))
+		})
+	}
+}
+
+func testParallelScan(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	tbl := s.Table("scan")
+	b := tbl.Batch()
+	for i := 0; i < 500; i++ {
+		b.Set(fmt.Sprintf("k%03d", i), &TaskObj{ID: uint64(i)})
+	}
+	b.Set("str", "plain")
+	b.Flush()
+	s.Table("scan2").SetStr("k000", "other table")
+
+	var mu sync.Mutex
+	var keys []string
+	var last sett.ScanStats
+	err := tbl.ParallelScan(context.Background(), 4, func(k string, v interface{}) error {
+		if k != "str" && v.(*TaskObj).ID == 0 && k != "k000" {
+			return fmt.Errorf("Unexpected value of %s %+v", k, v)
+		}
+		mu.Lock()
+		keys = append(keys, k)
+		mu.Unlock()
+		return nil
+	}, sett.ScanProgress(func(st sett.ScanStats) { last = st }, time.Millisecond))
+	if err != nil {
+		t.Fatalf("ParallelScan failed %v", err)
+	}
+	if len(keys) != 501 {
+		t.Errorf("Expected 501 items, got %d", len(keys))
+	}
+	if last.Keys != 501 || last.Bytes == 0 {
+		t.Errorf("Unexpected final progress %+v", last)
+	}
+
+	var ordered []string
+	err = tbl.ParallelScan(context.Background(), 4, func(k string, v interface{}) error {
+		ordered = append(ordered, k)
+		return nil
+	}, sett.ScanOrdered())
+	if err != nil {
+		t.Fatalf("Ordered ParallelScan failed %v", err)
+	}
+	if len(ordered) != 501 || !sort.StringsAreSorted(ordered) {
+		t.Errorf("Expected the 501 keys in order, got %d", len(ordered))
+	}
+
+	stop := errors.New("stop")
+	var calls int64
+	err = tbl.ParallelScan(context.Background(), 4, func(k string, v interface{}) error {
+		if atomic.AddInt64(&calls, 1) == 10 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected the error of the ScanFunc, got %v", err)
+	}
+	if atomic.LoadInt64(&calls) >= 501 {
+		t.Errorf("The scan didn't stop early")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = tbl.ParallelScan(ctx, 2, func(k string, v interface{}) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the scan to be canceled, got %v", err)
+	}
+}
@@ -1,8 +1,7 @@
 package sett
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dgraph-io/badger/v4"
@@ -15,34 +14,48 @@ var (
 )
 
 type Sett struct {
-	db        *badger.DB
+	db        Backend
 	table     string
 	ttl       time.Duration
 	keyLength int
+	shared    *shared
 }
 
 // Open is constructor function to create badger instance,
-// configure defaults and return struct instance
-func Open(opts badger.Options) (*Sett, error) {
+// configure defaults and return struct instance.
+// Pass WithMaintenance to run value log GC in the background
+func Open(opts badger.Options, options ...OpenOption) (*Sett, error) {
 	s := Sett{}
 
 	db, err := badger.Open(opts)
 	if err != nil {
 		return nil, fmt.Errorf("create or open db failed: %w", err)
 	}
-	s.db = db
+	s.db = NewBadgerBackend(db)
+	s.shared = newShared()
+	s.apply(options)
 	return &s, nil
 }
 
+// OpenInMemory creates a store that keeps all the data in memory.
+// It behaves the same as a store created with Open, but
+// the data is lost when the store is closed
+func OpenInMemory(options ...OpenOption) (*Sett, error) {
+	opts := DefaultOptions("").WithInMemory(true).WithLogger(nil)
+	return Open(opts, options...)
+}
+
 // Table selects the table, operations are to be performed
 // on. Used as a prefix on the keys passed to badger
 func (s *Sett) Table(table string) *Sett {
-	return &Sett{db: s.db, table: table}
+	return &Sett{db: s.db, table: table, shared: s.shared}
 }
 
 // WithTTL sets a (TTL) Time To Live value for values in this table
 // The TTL affects only the values added after the TTL is set.
 // Not applied to the values added before
+// The handle is changed, so every later write through it gets the TTL.
+// To set the expiry of a single write, pass ExpireIn or ExpireAt instead
 func (s *Sett) WithTTL(d time.Duration) *Sett {
 	s.ttl = d
 	return s
@@ -71,20 +84,35 @@ func (s *Sett) GetUniqueKey(len int) (string, error) {
 		if !s.HasKey(key) {
 			return key, nil
 		}
+		s.count(EventKeyRetry)
 	}
 	return "", errors.New("Couldn't generate a unique key ")
 }
 
-func (s *Sett) Insert(val interface{}) (string, error) {
+// newKey generates a key not used in the table yet
+func (s *Sett) newKey() (string, error) {
 	keylen := 22
 	if s.keyLength > 0 {
 		keylen = s.keyLength
 	}
-	key, err := s.GetUniqueKey(keylen)
-	if err != nil {
-		return "", err
-	}
-	err = s.SetStruct(key, val)
+	return s.GetUniqueKey(keylen)
+}
+
+func (s *Sett) Insert(val interface{}, opts ...SetOption) (string, error) {
+	return s.InsertCtx(context.Background(), val, opts...)
+}
+
+// InsertCtx is Insert with a context
+func (s *Sett) InsertCtx(ctx context.Context, val interface{}, opts ...SetOption) (key string, err error) {
+	err = s.do(ctx, OpInsert, "", val, func(c *opCall) error {
+		key, err = s.newKey()
+		if err != nil {
+			return err
+		}
+		c.key = key
+		c.result = key
+		return s.setStruct(c, key, c.value, opts...)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -92,9 +120,22 @@ func (s *Sett) Insert(val interface{}) (string, error) {
 }
 
 // SetStruct can be used to set the value as any struct type
-func (s *Sett) SetStruct(key string, val interface{}) error {
-	err := s.db.Update(func(txn *badger.Txn) error {
+func (s *Sett) SetStruct(key string, val interface{}, opts ...SetOption) error {
+	return s.SetStructCtx(context.Background(), key, val, opts...)
+}
+
+// SetStructCtx is SetStruct with a context
+func (s *Sett) SetStructCtx(ctx context.Context, key string, val interface{}, opts ...SetOption) error {
+	return s.do(ctx, OpSet, key, val, func(c *opCall) error {
+		return s.setStruct(c, c.key, c.value, opts...)
+	})
+}
+
+func (s *Sett) setStruct(c *opCall, key string, val interface{}, opts ...SetOption) error {
+	err := s.db.Update(func(txn Txn) error {
 		sit := NewSettItem(s, txn, key)
+		sit.call = c
+		sit.WithOptions(opts...)
 		return sit.SetStructValue(val)
 	})
 	return err
@@ -105,28 +146,24 @@ func (s *Sett) SetStruct(key string, val interface{}) error {
 // When you want to make sure there is only one owner to the
 // item, use Cut
 func (s *Sett) Cut(key string) (interface{}, error) {
-	var err error
+	return s.CutCtx(context.Background(), key)
+}
+
+// CutCtx is Cut with a context
+func (s *Sett) CutCtx(ctx context.Context, key string) (interface{}, error) {
 	var container genericContainer
-	err = s.db.Update(func(txn *badger.Txn) error {
-		bkey := []byte(s.makeKey(key))
-		item, err := txn.Get(bkey)
-		if err != nil {
-			return err
-		}
-		var val []byte
-		val, err = item.ValueCopy(nil)
-		if err != nil {
-			return err
-		}
-		err = gob.NewDecoder(bytes.NewBuffer(val)).Decode(&container)
-		if err != nil {
-			return err
-		}
-		err = txn.Delete(bkey)
-		if err != nil {
-			return err
-		}
-		return err
+	err := s.do(ctx, OpCut, key, nil, func(c *opCall) error {
+		return s.db.Update(func(txn Txn) error {
+			sit := NewSettItem(s, txn, c.key)
+			sit.call = c
+			sv, err := sit.GetStructValue()
+			if err != nil {
+				return err
+			}
+			container.V = sv.V
+			c.result = sv.V
+			return s.deleteEntry(c, txn, []byte(sit.fullKey))
+		})
 	})
 	if err != nil {
 		return nil, err
@@ -135,11 +172,26 @@ func (s *Sett) Cut(key string) (interface{}, error) {
 }
 
 func (s *Sett) GetStruct(key string) (interface{}, error) {
+	return s.GetStructCtx(context.Background(), key)
+}
+
+// GetStructCtx is GetStruct with a context
+func (s *Sett) GetStructCtx(ctx context.Context, key string) (v interface{}, err error) {
+	err = s.do(ctx, OpGet, key, nil, func(c *opCall) error {
+		v, err = s.getStruct(c, c.key)
+		c.result = v
+		return err
+	})
+	return v, err
+}
+
+func (s *Sett) getStruct(c *opCall, key string) (interface{}, error) {
 	var err error
 	var iv interface{}
-	err = s.db.View(func(txn *badger.Txn) error {
+	err = s.db.View(func(txn Txn) error {
 		si := NewSettItem(s, txn, key)
-		sv, err := si.GetStructValue()
+		si.call = c
+		sv, err := si.cachedStructValue()
 		if err != nil {
 			return err
 		}
@@ -154,9 +206,26 @@ func (s *Sett) GetStruct(key string) (interface{}, error) {
 
 // SetStr passes a key & value to badger. Expects string for both
 // key and value for convenience, unlike badger itself
-func (s *Sett) SetStr(key string, val string) error {
-	err := s.db.Update(func(txn *badger.Txn) error {
+func (s *Sett) SetStr(key string, val string, opts ...SetOption) error {
+	return s.SetStrCtx(context.Background(), key, val, opts...)
+}
+
+// SetStrCtx is SetStr with a context
+func (s *Sett) SetStrCtx(ctx context.Context, key string, val string, opts ...SetOption) error {
+	return s.do(ctx, OpSet, key, val, func(c *opCall) error {
+		val, ok := c.value.(string)
+		if !ok {
+			return fmt.Errorf("SetStr of %s needs a string value, got %T", c.key, c.value)
+		}
+		return s.setStr(c, c.key, val, opts...)
+	})
+}
+
+func (s *Sett) setStr(c *opCall, key string, val string, opts ...SetOption) error {
+	err := s.db.Update(func(txn Txn) error {
 		si := NewSettItem(s, txn, key)
+		si.call = c
+		si.WithOptions(opts...)
 		return si.SetStringValue(val)
 	})
 	return err
@@ -164,10 +233,25 @@ func (s *Sett) SetStr(key string, val string) error {
 
 // GetStr returns value of queried key from badger
 func (s *Sett) GetStr(key string) (string, error) {
+	return s.GetStrCtx(context.Background(), key)
+}
+
+// GetStrCtx is GetStr with a context
+func (s *Sett) GetStrCtx(ctx context.Context, key string) (v string, err error) {
+	err = s.do(ctx, OpGet, key, nil, func(c *opCall) error {
+		v, err = s.getStr(c, c.key)
+		c.result = v
+		return err
+	})
+	return v, err
+}
+
+func (s *Sett) getStr(c *opCall, key string) (string, error) {
 	var val string
 	var err error
-	err = s.db.View(func(txn *badger.Txn) error {
+	err = s.db.View(func(txn Txn) error {
 		si := NewSettItem(s, txn, key)
+		si.call = c
 		val, err = si.GetStringValue()
 		return err
 	})
@@ -177,26 +261,51 @@ func (s *Sett) GetStr(key string) (string, error) {
 	return val, nil
 }
 
-func (s *Sett) Set(key string, val interface{}) error {
+func (s *Sett) Set(key string, val interface{}, opts ...SetOption) error {
+	return s.SetCtx(context.Background(), key, val, opts...)
+}
+
+// SetCtx is Set with a context
+func (s *Sett) SetCtx(ctx context.Context, key string, val interface{}, opts ...SetOption) error {
+	return s.do(ctx, OpSet, key, val, func(c *opCall) error {
+		return s.set(c, c.key, c.value, opts...)
+	})
+}
+
+func (s *Sett) set(c *opCall, key string, val interface{}, opts ...SetOption) error {
 	switch val.(type) {
 	case string:
-		return s.SetStr(key, val.(string))
+		return s.setStr(c, key, val.(string), opts...)
 	default:
-		return s.SetStruct(key, val)
+		return s.setStruct(c, key, val, opts...)
 	}
 }
 
 func (s *Sett) Get(key string) (interface{}, error) {
-	ret, err := s.GetStruct(key)
+	return s.GetCtx(context.Background(), key)
+}
+
+// GetCtx is Get with a context
+func (s *Sett) GetCtx(ctx context.Context, key string) (v interface{}, err error) {
+	err = s.do(ctx, OpGet, key, nil, func(c *opCall) error {
+		v, err = s.get(c, c.key)
+		c.result = v
+		return err
+	})
+	return v, err
+}
+
+func (s *Sett) get(c *opCall, key string) (interface{}, error) {
+	ret, err := s.getStruct(c, key)
 	if err != nil {
-		return s.GetStr(key)
+		return s.getStr(c, key)
 	}
 	return ret, err
 }
 
 // HasKey checks the existence of a key
 func (s *Sett) HasKey(key string) bool {
-	_, err := s.Get(key)
+	_, err := s.get(nil, key)
 	return err == nil
 }
 
@@ -204,33 +313,44 @@ func (s *Sett) HasKey(key string) bool {
 // optional filter allows the table prefix on the key search
 // to be expanded
 func (s *Sett) Keys(filter ...string) ([]string, error) {
-	var result []string
-	var err error
-	err = s.db.View(func(txn *badger.Txn) error {
-		var fullFilter string
-		it := txn.NewIterator(DefaultIteratorOptions)
-		defer it.Close()
-
-		if len(filter) > 1 {
-			return errors.New("Can't accept more than one filters")
-		}
-		if len(s.table) > 0 {
-			fullFilter = s.table + ":"
-		}
+	return s.KeysCtx(context.Background(), filter...)
+}
 
-		if len(filter) == 1 {
-			fullFilter += filter[0]
-		}
-		tn := len(s.table + ":")
+// KeysCtx is Keys with a context. The scan stops when ctx is done
+func (s *Sett) KeysCtx(ctx context.Context, filter ...string) (result []string, err error) {
+	if len(filter) > 1 {
+		return nil, errors.New("Can't accept more than one filters")
+	}
+	err = s.do(ctx, OpKeys, "", nil, func(c *opCall) error {
+		defer func() { c.result = result }()
+		return s.db.View(func(txn Txn) error {
+			var fullFilter string
+			if len(s.table) > 0 {
+				fullFilter = s.table + ":"
+			}
 
-		for it.Seek([]byte(fullFilter)); it.ValidForPrefix([]byte(fullFilter)); it.Next() {
-			item := it.Item()
-			k := string(item.Key())
-			k = k[tn:]
+			if len(filter) == 1 {
+				fullFilter += filter[0]
+			}
+			it := txn.NewIterator(IteratorOptions{Prefix: []byte(fullFilter), KeysOnly: true})
+			defer it.Close()
+			tn := len(s.table + ":")
+
+			for it.Seek([]byte(fullFilter)); it.Valid(); it.Next() {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				item := it.Item()
+				if isSystemKey(item.Key()) {
+					continue
+				}
+				k := string(item.Key())
+				k = k[tn:]
 
-			result = append(result, k)
-		}
-		return err
+				result = append(result, k)
+			}
+			return nil
+		})
 	})
 	return result, err
 }
@@ -238,40 +358,45 @@ func (s *Sett) Keys(filter ...string) ([]string, error) {
 type FilterFunc func(k string, v interface{}) bool
 
 func (s *Sett) Filter(filter FilterFunc) ([]string, error) {
-	var result []string
-	var err error
-	err = s.db.View(func(txn *badger.Txn) error {
-		var fullFilter string
-		it := txn.NewIterator(DefaultIteratorOptions)
-		defer it.Close()
-
-		if len(s.table) > 0 {
-			fullFilter = s.table
-		}
-
-		tn := len(s.table + ":")
-
-		for it.Seek([]byte(fullFilter)); it.ValidForPrefix([]byte(fullFilter)); it.Next() {
-			item := it.Item()
-			k := string(item.Key())
-			k = k[tn:]
+	return s.FilterCtx(context.Background(), filter)
+}
 
-			var container genericContainer
-			var val []byte
-			val, err = item.ValueCopy(nil)
-			if err != nil {
-				return err
-			}
-			err = gob.NewDecoder(bytes.NewBuffer(val)).Decode(&container)
-			if err != nil {
-				return err
-			}
-			if filter(k, container.V) {
-				result = append(result, k)
+// FilterCtx is Filter with a context. The scan stops when ctx is done
+func (s *Sett) FilterCtx(ctx context.Context, filter FilterFunc) (result []string, err error) {
+	err = s.do(ctx, OpFilter, "", nil, func(c *opCall) error {
+		defer func() { c.result = result }()
+		return s.db.View(func(txn Txn) error {
+			var fullFilter string
+			if len(s.table) > 0 {
+				fullFilter = s.table
 			}
+			it := txn.NewIterator(IteratorOptions{Prefix: []byte(fullFilter)})
+			defer it.Close()
+
+			tn := len(s.table + ":")
+
+			for it.Seek([]byte(fullFilter)); it.Valid(); it.Next() {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				item := it.Item()
+				if isSystemKey(item.Key()) {
+					continue
+				}
+				k := string(item.Key())
+				k = k[tn:]
+
+				sv, err := NewSettItem(s, txn, k).structValue(item)
+				if err != nil {
+					return err
+				}
+				if filter(k, sv.V) {
+					result = append(result, k)
+				}
 
-		}
-		return err
+			}
+			return nil
+		})
 	})
 	return result, err
 }
@@ -280,11 +405,18 @@ func (s *Sett) Filter(filter FilterFunc) ([]string, error) {
 // the caller shouldn't do any updates. The lock was already taken.
 // This is used in concurrent access scenarios
 func (s *Sett) Lock(k string) error {
-	err := s.db.Update(func(txn *badger.Txn) error {
-		sit := NewSettItem(s, txn, k)
-		return sit.Lock()
+	return s.LockCtx(context.Background(), k)
+}
+
+// LockCtx is Lock with a context
+func (s *Sett) LockCtx(ctx context.Context, k string) error {
+	return s.do(ctx, OpLock, k, nil, func(c *opCall) error {
+		return s.db.Update(func(txn Txn) error {
+			sit := NewSettItem(s, txn, c.key)
+			sit.call = c
+			return sit.Lock()
+		})
 	})
-	return err
 }
 
 type UpdateFunc func(v interface{}) error
@@ -292,27 +424,38 @@ type UpdateFunc func(v interface{}) error
 // Update - update one item. This function gets the item by the key.
 // The caller is to update the item in the callback.
 // If the item was locked first, pass unlock= true
-func (s *Sett) Update(k string, updater UpdateFunc, unlock bool) (interface{}, error) {
-	var err error
-	var container genericContainer
-	err = s.db.Update(func(txn *badger.Txn) error {
+// The updated value gets the TTL of the handle, unless KeepTTL or
+// another expiry option is passed
+func (s *Sett) Update(k string, updater UpdateFunc, unlock bool, opts ...SetOption) (interface{}, error) {
+	return s.UpdateCtx(context.Background(), k, updater, unlock, opts...)
+}
 
-		sit := NewSettItem(s, txn, k)
-		sit.Unlock(unlock)
-		sv, err := sit.GetStructValue()
-		if err != nil {
-			return err
-		}
-		err = updater(sv.V)
-		if err != nil {
-			return err
-		}
-		err = sit.SetStructValue(sv.V)
-		if err != nil {
+// UpdateCtx is Update with a context
+func (s *Sett) UpdateCtx(ctx context.Context, k string, updater UpdateFunc, unlock bool, opts ...SetOption) (interface{}, error) {
+	var container genericContainer
+	err := s.do(ctx, OpUpdate, k, nil, func(c *opCall) error {
+		return s.db.Update(func(txn Txn) error {
+
+			sit := NewSettItem(s, txn, c.key)
+			sit.call = c
+			sit.Unlock(unlock)
+			sit.WithOptions(opts...)
+			sv, err := sit.GetStructValue()
+			if err != nil {
+				return err
+			}
+			err = updater(sv.V)
+			if err != nil {
+				return err
+			}
+			err = sit.SetStructValue(sv.V)
+			if err != nil {
+				return err
+			}
+			container.V = sv.V
+			c.result = sv.V
 			return err
-		}
-		container.V = sv.V
-		return err
+		})
 	})
 	if err != nil {
 		return nil, err
@@ -320,55 +463,90 @@ func (s *Sett) Update(k string, updater UpdateFunc, unlock bool) (interface{}, e
 	return container.V, nil
 }
 
-func (s *Sett) deleteItem(key string, unlock bool) error {
-	err := s.db.Update(func(txn *badger.Txn) error {
-		sit := NewSettItem(s, txn, key)
-		sit.Unlock(unlock)
-		return sit.Delete()
+func (s *Sett) deleteItem(ctx context.Context, key string, unlock bool) error {
+	return s.do(ctx, OpDelete, key, nil, func(c *opCall) error {
+		return s.db.Update(func(txn Txn) error {
+			sit := NewSettItem(s, txn, c.key)
+			sit.call = c
+			sit.Unlock(unlock)
+			return sit.Delete()
+		})
 	})
-	return err
 }
 
 // Delete removes a key and its value from badger instance
 func (s *Sett) Delete(key string) error {
-	return s.deleteItem(key, false)
+	return s.deleteItem(context.Background(), key, false)
+}
+
+// DeleteCtx is Delete with a context
+func (s *Sett) DeleteCtx(ctx context.Context, key string) error {
+	return s.deleteItem(ctx, key, false)
 }
 
 // UnlockAndDelete - Unlock and then delete the item.
 func (s *Sett) UnlockAndDelete(key string) error {
-	return s.deleteItem(key, true)
+	return s.deleteItem(context.Background(), key, true)
+}
+
+// UnlockAndDeleteCtx is UnlockAndDelete with a context
+func (s *Sett) UnlockAndDeleteCtx(ctx context.Context, key string) error {
+	return s.deleteItem(ctx, key, true)
 }
 
 // Drop removes all keys with table prefix from badger,
 // the effect is as if a table was deleted
 func (s *Sett) Drop() error {
-	var err error
-	var deleteKey []string
-	err = s.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(DefaultIteratorOptions)
-		prefix := []byte(s.table)
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := string(item.Key())
-			deleteKey = append(deleteKey, key)
-		}
-		it.Close()
-		return nil
-	})
-	err = s.db.Update(func(txn *badger.Txn) error {
-		for _, d := range deleteKey {
-			err = txn.Delete([]byte(d))
-			if err != nil {
-				break
+	return s.DropCtx(context.Background())
+}
+
+// DropCtx is Drop with a context. Nothing is removed if ctx is done
+// before the keys are deleted
+func (s *Sett) DropCtx(ctx context.Context) error {
+	return s.do(ctx, OpDrop, "", nil, func(c *opCall) error {
+		var deleteKey []string
+		err := s.db.View(func(txn Txn) error {
+			prefix := []byte(s.table)
+			it := txn.NewIterator(IteratorOptions{Prefix: prefix, KeysOnly: true})
+			defer it.Close()
+			for it.Seek(prefix); it.Valid(); it.Next() {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				item := it.Item()
+				if isSystemKey(item.Key()) {
+					continue
+				}
+				key := string(item.Key())
+				deleteKey = append(deleteKey, key)
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		return err
+		return s.db.Update(func(txn Txn) error {
+			for _, d := range deleteKey {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				err := s.deleteEntry(c, txn, []byte(d))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 	})
-	return err
 }
 
 // Close wraps badger Close method for defer
+// Background work started on the store is stopped first
 func (s *Sett) Close() error {
+	s.shared.close()
+	if sc, ok := s.metrics().(sizeCollector); ok {
+		sc.collectSizes(s.shared, nil)
+	}
 	return s.db.Close()
 }
 
@@ -3,36 +3,17 @@ package sett_test
 import (
 	"encoding/gob"
 	"errors"
-	"github.com/prasanthmj/sett/v2"
-	"go.uber.org/goleak"
-	"os"
+	"github.com/prasanthmj/sett/v2/setttest"
 	"sync"
 	"syreclabs.com/go/faker"
 	"testing"
 	"time"
 )
 
-func initSett() *sett.Sett {
-	os.RemoveAll("./data/jobsdb7")
-	opts := sett.DefaultOptions("./data/jobsdb7")
-	opts.Logger = nil
-	s, _ := sett.Open(opts)
-	return s
-}
-
-func closeSet(s *sett.Sett) {
-	s.Close()
-	// clean up
-	os.RemoveAll("./data/jobsdb7")
-}
-
 func TestSet(t *testing.T) {
-	defer goleak.VerifyNone(t,
-		goleak.IgnoreTopFunction("github.com/golang/glog.(*fileSink).flushDaemon"),
-	)
+	setttest.VerifyNoLeaks(t)
 
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	k := faker.RandomString(8)
 	v := faker.RandomString(8)
@@ -71,8 +52,7 @@ func TestSet(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 	k := faker.RandomString(8)
 	v := faker.RandomString(8)
 
@@ -116,8 +96,7 @@ func TestDelete(t *testing.T) {
 }
 
 func TestTableGet(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	k := faker.RandomString(8)
@@ -141,8 +120,7 @@ func TestTableGet(t *testing.T) {
 }
 
 func TestTableDelete(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	k := faker.RandomString(8)
@@ -165,8 +143,7 @@ func TestTableDelete(t *testing.T) {
 }
 
 func TestKeysFilter(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	//Add some random key values first
 	for i := 0; i < 15; i++ {
@@ -213,8 +190,7 @@ func TestKeysFilter(t *testing.T) {
 }
 
 func TestDrop(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	var keys [15]string
@@ -242,8 +218,7 @@ func TestDrop(t *testing.T) {
 }
 
 func TestTableNameShouldntPersist(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	k := faker.RandomString(8)
@@ -278,8 +253,7 @@ func TestTableNameShouldntPersist(t *testing.T) {
 }
 
 func TestTTL(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 
@@ -327,8 +301,7 @@ type Signup struct {
 }
 
 func TestSettingStruct(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	gob.Register(&Signup{})
 	var su Signup
@@ -357,8 +330,7 @@ func TestSettingStruct(t *testing.T) {
 }
 
 func TestSimpleSet(t *testing.T) {
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	k := faker.RandomString(12)
 	v := faker.RandomString(12)
@@ -385,8 +357,7 @@ type UserSession struct {
 
 func TestInsert(t *testing.T) {
 	gob.Register(&UserSession{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	session := UserSession{}
 	session.ID = faker.RandomString(12)
@@ -423,8 +394,7 @@ func TestInsert(t *testing.T) {
 
 func TestInsertWithExpiry(t *testing.T) {
 	gob.Register(&UserSession{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	session := UserSession{}
 	session.ID = faker.RandomString(12)
@@ -442,8 +412,7 @@ func TestInsertWithExpiry(t *testing.T) {
 
 func TestGetKeys(t *testing.T) {
 	gob.Register(&UserSession{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(12)
 
@@ -483,8 +452,7 @@ func TestGetKeys(t *testing.T) {
 
 func TestCutting(t *testing.T) {
 	gob.Register(&Signup{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	var su Signup
 	su.Name = faker.Name().Name()
@@ -518,8 +486,7 @@ func TestCutting(t *testing.T) {
 
 func TestCuttingWithInsert(t *testing.T) {
 	gob.Register(&Signup{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	var su Signup
@@ -558,8 +525,7 @@ type Item struct {
 
 func TestFilterFunc(t *testing.T) {
 	gob.Register(&Item{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	var itm1 Item
@@ -618,8 +584,7 @@ type ItemStatus struct {
 
 func TestUpdate(t *testing.T) {
 	gob.Register(&TaskObj{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	var task TaskObj
@@ -648,11 +613,8 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestConcurrentAccess(t *testing.T) {
-	defer goleak.VerifyNone(t,
-		goleak.IgnoreTopFunction("github.com/golang/glog.(*fileSink).flushDaemon"),
-	)
-	store := initSett()
-	defer closeSet(store)
+	setttest.VerifyNoLeaks(t)
+	store := setttest.New(t)
 
 	var maxItems uint64 = 100
 	var i uint64
@@ -679,8 +641,8 @@ func TestConcurrentAccess(t *testing.T) {
 
 	t.Logf("Creating goroutines to access items ...")
 	for m := 0; m < 10; m++ {
+		wg.Add(1)
 		go func() {
-			wg.Add(1)
 			defer wg.Done()
 			for {
 				select {
@@ -736,12 +698,9 @@ func TestConcurrentAccess(t *testing.T) {
 }
 
 func TestConcurrentUpdate(t *testing.T) {
-	defer goleak.VerifyNone(t,
-		goleak.IgnoreTopFunction("github.com/golang/glog.(*fileSink).flushDaemon"),
-	)
+	setttest.VerifyNoLeaks(t)
 
-	store := initSett()
-	defer closeSet(store)
+	store := setttest.New(t)
 
 	var maxItems uint64 = 100
 	var i uint64
@@ -768,8 +727,8 @@ func TestConcurrentUpdate(t *testing.T) {
 
 	t.Logf("Creating goroutines to access items ...")
 	for m := 0; m < 10; m++ {
+		wg.Add(1)
 		go func() {
-			wg.Add(1)
 			defer wg.Done()
 			for {
 				select {
@@ -840,8 +799,7 @@ func TestConcurrentUpdate(t *testing.T) {
 
 func TestLock(t *testing.T) {
 	gob.Register(&TaskObj{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	var task TaskObj
@@ -899,8 +857,7 @@ func TestLock(t *testing.T) {
 
 func TestLockAndDelete(t *testing.T) {
 	gob.Register(&TaskObj{})
-	s := initSett()
-	defer closeSet(s)
+	s := setttest.New(t)
 
 	table := faker.RandomString(8)
 	var task TaskObj
@@ -0,0 +1,90 @@
+// Package setttest provides isolated sett stores for tests.
+//
+// Each call creates a new store which is closed when the test completes,
+// so tests using it can run in parallel.
+//
+//	func TestSomething(t *testing.T) {
+//		setttest.VerifyNoLeaks(t)
+//		s := setttest.New(t)
+//		...
+//	}
+package setttest
+
+import (
+	"github.com/prasanthmj/sett/v2"
+	"go.uber.org/goleak"
+	"path/filepath"
+	"testing"
+)
+
+// New returns an in-memory store that is closed when the test completes
+func New(t testing.TB) *sett.Sett {
+	t.Helper()
+	s, err := sett.OpenInMemory()
+	if err != nil {
+		t.Fatalf("setttest: couldn't open in-memory store: %v", err)
+	}
+	t.Cleanup(func() {
+		closeStore(t, s)
+	})
+	return s
+}
+
+// NewOnDisk returns a store in a temporary directory of the test.
+// The store is closed and the directory is removed when the test completes
+func NewOnDisk(t testing.TB) *sett.Sett {
+	t.Helper()
+	return OpenDir(t, filepath.Join(t.TempDir(), "db"))
+}
+
+// OpenDir opens the store in dir and closes it when the test completes
+func OpenDir(t testing.TB, dir string) *sett.Sett {
+	t.Helper()
+	opts := sett.DefaultOptions(dir).WithLogger(nil)
+	s, err := sett.Open(opts)
+	if err != nil {
+		t.Fatalf("setttest: couldn't open store in %s: %v", dir, err)
+	}
+	t.Cleanup(func() {
+		closeStore(t, s)
+	})
+	return s
+}
+
+// NewBolt returns a store on the bbolt backend in a temporary directory
+// of the test. The store is closed when the test completes
+func NewBolt(t testing.TB) *sett.Sett {
+	t.Helper()
+	s, err := sett.OpenBolt(filepath.Join(t.TempDir(), "bolt.db"), nil)
+	if err != nil {
+		t.Fatalf("setttest: couldn't open bolt store: %v", err)
+	}
+	t.Cleanup(func() {
+		closeStore(t, s)
+	})
+	return s
+}
+
+func closeStore(t testing.TB, s *sett.Sett) {
+	err := s.Close()
+	if err != nil {
+		t.Errorf("setttest: error closing store: %v", err)
+	}
+}
+
+// LeakOptions are the goleak options ignoring the goroutines
+// that outlive a store, started by badger's dependencies
+func LeakOptions() []goleak.Option {
+	return []goleak.Option{
+		goleak.IgnoreTopFunction("github.com/golang/glog.(*fileSink).flushDaemon"),
+	}
+}
+
+// VerifyNoLeaks checks for leaked goroutines when the test completes.
+// Call it before New, so that the check runs after the stores are closed
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+	t.Cleanup(func() {
+		goleak.VerifyNone(t, LeakOptions()...)
+	})
+}
@@ -0,0 +1,24 @@
+package setttest_test
+
+import (
+	"github.com/prasanthmj/sett/v2/setttest"
+	"testing"
+)
+
+func TestStoresAreIsolated(t *testing.T) {
+	setttest.VerifyNoLeaks(t)
+	s1 := setttest.New(t)
+	s2 := setttest.New(t)
+
+	err := s1.Table("t").SetStr("k", "v")
+	if err != nil {
+		t.Fatalf("Set failed %v", err)
+	}
+	if s2.Table("t").HasKey("k") {
+		t.Errorf("Key set in one store is visible in the other")
+	}
+	d := setttest.NewOnDisk(t)
+	if d.Table("t").HasKey("k") {
+		t.Errorf("Key set in one store is visible in the on disk store")
+	}
+}
@@ -0,0 +1,322 @@
+package sett
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DefaultStopWords are the English words left out of the text indexes
+var DefaultStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+	"into", "is", "it", "no", "not", "of", "on", "or", "such", "that", "the",
+	"their", "then", "there", "these", "they", "this", "to", "was", "will", "with",
+}
+
+// token is a word of a text, at its position among the words
+type token struct {
+	term string
+	pos  int
+}
+
+// analyzer splits texts into the terms of a text index
+type analyzer struct {
+	stopWords map[string]bool
+	stem      bool
+}
+
+func newAnalyzer(stopWords []string, stem bool) *analyzer {
+	a := &analyzer{stopWords: make(map[string]bool, len(stopWords)), stem: stem}
+	for _, w := range stopWords {
+		a.stopWords[strings.ToLower(w)] = true
+	}
+	return a
+}
+
+// tokens returns the terms of the text: the lowercased words, stemmed,
+// without the stop words. The positions count the stop words, so that
+// phrases match only the words next to each other. base is the
+// position of the first word
+func (a *analyzer) tokens(text string, base int) []token {
+	var tokens []token
+	pos := base
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		w = strings.ToLower(w)
+		if !a.stopWords[w] {
+			if a.stem {
+				w = porterStem(w)
+			}
+			tokens = append(tokens, token{term: w, pos: pos})
+		}
+		pos++
+	}
+	return tokens
+}
+
+// porterStem returns the stem of the lowercase English word, following
+// the algorithm of M.F. Porter, "An algorithm for suffix stripping", 1980
+func porterStem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return word
+		}
+	}
+	st := &stemmer{b: []byte(word), k: len(word) - 1}
+	st.step1ab()
+	if st.k > 0 {
+		st.step1c()
+		st.step2()
+		st.step3()
+		st.step4()
+		st.step5()
+	}
+	return string(st.b[:st.k+1])
+}
+
+// stemmer holds the word being stemmed in b[0..k],
+// j is the end of the stem when a suffix matches
+type stemmer struct {
+	b []byte
+	k int
+	j int
+}
+
+// cons tells if b[i] is a consonant
+func (st *stemmer) cons(i int) bool {
+	switch st.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !st.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of consonant sequences in b[0..j]. With c a
+// consonant sequence and v a vowel sequence, and [] optional,
+// [c](vc){m}[v] gives m
+func (st *stemmer) m() int {
+	n, i := 0, 0
+	for {
+		if i > st.j {
+			return n
+		}
+		if !st.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+	for {
+		for {
+			if i > st.j {
+				return n
+			}
+			if st.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+		for {
+			if i > st.j {
+				return n
+			}
+			if !st.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+// vowelInStem tells if b[0..j] has a vowel
+func (st *stemmer) vowelInStem() bool {
+	for i := 0; i <= st.j; i++ {
+		if !st.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doublec tells if b[j-1..j] is a double consonant
+func (st *stemmer) doublec(j int) bool {
+	return j >= 1 && st.b[j] == st.b[j-1] && st.cons(j)
+}
+
+// cvc tells if b[i-2..i] is consonant, vowel, consonant and the second
+// consonant is not w, x or y. Restores an e at the end of short words
+// like cav(e), lov(e), hop(e)
+func (st *stemmer) cvc(i int) bool {
+	if i < 2 || !st.cons(i) || st.cons(i-1) || !st.cons(i-2) {
+		return false
+	}
+	switch st.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends tells if b[0..k] ends with s, setting j to the end of the stem
+func (st *stemmer) ends(s string) bool {
+	l := len(s)
+	if l > st.k+1 || string(st.b[st.k-l+1:st.k+1]) != s {
+		return false
+	}
+	st.j = st.k - l
+	return true
+}
+
+// setTo replaces b[j+1..k] by s
+func (st *stemmer) setTo(s string) {
+	st.b = append(st.b[:st.j+1], s...)
+	st.k = st.j + len(s)
+}
+
+func (st *stemmer) r(s string) {
+	if st.m() > 0 {
+		st.setTo(s)
+	}
+}
+
+// step1ab removes plurals and -ed or -ing
+func (st *stemmer) step1ab() {
+	if st.b[st.k] == 's' {
+		switch {
+		case st.ends("sses"):
+			st.k -= 2
+		case st.ends("ies"):
+			st.setTo("i")
+		case st.b[st.k-1] != 's':
+			st.k--
+		}
+	}
+	if st.ends("eed") {
+		if st.m() > 0 {
+			st.k--
+		}
+		return
+	}
+	if (st.ends("ed") || st.ends("ing")) && st.vowelInStem() {
+		st.k = st.j
+		switch {
+		case st.ends("at"):
+			st.setTo("ate")
+		case st.ends("bl"):
+			st.setTo("ble")
+		case st.ends("iz"):
+			st.setTo("ize")
+		case st.doublec(st.k):
+			st.k--
+			switch st.b[st.k] {
+			case 'l', 's', 'z':
+				st.k++
+			}
+		default:
+			st.j = st.k
+			if st.m() == 1 && st.cvc(st.k) {
+				st.setTo("e")
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y to i when there is another vowel in the stem
+func (st *stemmer) step1c() {
+	if st.ends("y") && st.vowelInStem() {
+		st.b[st.k] = 'i'
+	}
+}
+
+type suffixRule struct {
+	suffix      string
+	replacement string
+}
+
+// applyFirst replaces the first of the suffixes the word ends with,
+// if the stem is long enough
+func (st *stemmer) applyFirst(rules []suffixRule) {
+	for _, rule := range rules {
+		if st.ends(rule.suffix) {
+			st.r(rule.replacement)
+			return
+		}
+	}
+}
+
+var step2Rules = []suffixRule{
+	{"ational", "ate"}, {"tional", "tion"},
+	{"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"},
+	{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"},
+	{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"},
+	{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"},
+	{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+	{"logi", "log"},
+}
+
+// step2 maps double suffixes to single ones, -ization to -ize and so on
+func (st *stemmer) step2() {
+	if st.k >= 1 {
+		st.applyFirst(step2Rules)
+	}
+}
+
+var step3Rules = []suffixRule{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"},
+	{"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""},
+	{"ness", ""},
+}
+
+// step3 deals with -ic-, -full, -ness and the like
+func (st *stemmer) step3() {
+	st.applyFirst(step3Rules)
+}
+
+var step4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+	"ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+// step4 removes -ant, -ence and the like from words with a long stem
+func (st *stemmer) step4() {
+	if st.k < 1 {
+		return
+	}
+	for _, suffix := range step4Suffixes {
+		if !st.ends(suffix) {
+			continue
+		}
+		if suffix == "ion" && (st.j < 0 || (st.b[st.j] != 's' && st.b[st.j] != 't')) {
+			continue
+		}
+		if st.m() > 1 {
+			st.k = st.j
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and turns -ll to -l on words with a long stem
+func (st *stemmer) step5() {
+	st.j = st.k
+	if st.b[st.k] == 'e' {
+		a := st.m()
+		if a > 1 || (a == 1 && !st.cvc(st.k-1)) {
+			st.k--
+		}
+	}
+	if st.b[st.k] == 'l' && st.doublec(st.k) && st.m() > 1 {
+		st.k--
+	}
+}
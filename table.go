@@ -0,0 +1,258 @@
+package sett
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// shared is the state of an open store,
+// common to all the table handles of the store
+type shared struct {
+	tables *tableRegistry
+
+	flights *flightGroup
+	// metrics holds the *metricsHolder set by WithMetrics
+	metrics atomic.Value
+	// tracer holds the *tracerHolder set by WithTracer
+	tracer atomic.Value
+
+	mu      sync.Mutex
+	closed  bool
+	closers []func()
+	expiry  *expiryTracker
+	// middleware is replaced, never changed in place, by Use
+	middleware []Middleware
+	// background counts the goroutines started by goBackground
+	background sync.WaitGroup
+}
+
+func newShared() *shared {
+	return &shared{tables: newTableRegistry(), flights: newFlightGroup()}
+}
+
+// onClose registers fn to be run when the store is closed,
+// before the backend is closed
+func (sh *shared) onClose(fn func()) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.closers = append(sh.closers, fn)
+}
+
+// goBackground runs fn in a goroutine the store waits for when closed.
+// Returns false, without running fn, if the store is closed
+func (sh *shared) goBackground(fn func()) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.closed {
+		return false
+	}
+	sh.background.Add(1)
+	go func() {
+		defer sh.background.Done()
+		fn()
+	}()
+	return true
+}
+
+func (sh *shared) close() {
+	sh.mu.Lock()
+	sh.closed = true
+	closers := sh.closers
+	sh.closers = nil
+	sh.mu.Unlock()
+	for i := len(closers) - 1; i >= 0; i-- {
+		closers[i]()
+	}
+	sh.background.Wait()
+}
+
+// tableOptions holds the settings registered for a table.
+// Unlike TTL or key length, these are shared by all the handles
+// returned by Sett.Table() for the same table
+type tableOptions struct {
+	keys    KeyProvider
+	expiry  *expiryOptions
+	cache   *valueCache
+	audit   *AuditOptions
+	history *HistoryOptions
+	// indexes is replaced, never changed in place, by addIndex and removeIndex
+	indexes map[string]tableIndex
+}
+
+type tableRegistry struct {
+	mu     sync.RWMutex
+	tables map[string]*tableOptions
+}
+
+func newTableRegistry() *tableRegistry {
+	return &tableRegistry{tables: make(map[string]*tableOptions)}
+}
+
+// options returns a copy of the settings of the table
+func (r *tableRegistry) options(table string) tableOptions {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if o, ok := r.tables[table]; ok {
+		return *o
+	}
+	return tableOptions{}
+}
+
+// update changes the settings of the table in place
+func (r *tableRegistry) update(table string, fn func(o *tableOptions)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.tables[table]
+	if !ok {
+		o = &tableOptions{}
+		r.tables[table] = o
+	}
+	fn(o)
+}
+
+// names returns the tables with settings, but the store itself,
+// longest first
+func (r *tableRegistry) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tables))
+	for name := range r.tables {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func (s *Sett) options() tableOptions {
+	return s.shared.tables.options(s.table)
+}
+
+// Keys starting with systemPrefix hold the data Sett keeps
+// for itself, like the expiry index. They are never part of a table
+const systemPrefix = "\xffsett:"
+
+func systemKey(parts ...[]byte) []byte {
+	k := []byte(systemPrefix)
+	for _, p := range parts {
+		k = append(k, p...)
+	}
+	return k
+}
+
+func isSystemKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(systemPrefix))
+}
+
+// putEntry is the single path every value written to a table
+// goes through, keeping the data Sett maintains for the table in step.
+// c is the operation writing the value, nil for the internal writes
+func (s *Sett) putEntry(c *opCall, txn Txn, e *Entry) error {
+	s.invalidate(e.Key)
+	old, err := currentItem(txn, e.Key)
+	if err != nil {
+		return err
+	}
+	err = untrackExpiry(txn, e.Key, old)
+	if err != nil {
+		return err
+	}
+	e.UserMeta = e.UserMeta &^ TRACKED_FLAG
+	o := s.options()
+	if e.ExpiresAt > 0 && o.expiry != nil {
+		err = s.trackExpiry(txn, e)
+		if err != nil {
+			return err
+		}
+	}
+	if c != nil && o.audit != nil {
+		err = s.audit(c, txn, e.Key, old, e)
+		if err != nil {
+			return err
+		}
+	}
+	if o.history != nil {
+		err = s.recordHistory(txn, e.Key, old, e)
+		if err != nil {
+			return err
+		}
+	}
+	if len(o.indexes) > 0 {
+		err = s.updateIndexes(txn, e.Key, old, e, o.indexes)
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Set(e)
+}
+
+// deleteEntry is the single path every key removed from a table goes through
+func (s *Sett) deleteEntry(c *opCall, txn Txn, key []byte) error {
+	s.invalidate(key)
+	old, err := currentItem(txn, key)
+	if err != nil {
+		return err
+	}
+	err = untrackExpiry(txn, key, old)
+	if err != nil {
+		return err
+	}
+	return s.removeItem(c, txn, key, old)
+}
+
+// removeItem removes the key, recording the change of its item old,
+// nil if there is none, in the audit, history and indexes of the table.
+// The expired keys purged from bolt stores come here directly, staying
+// in the expiry index until the OnExpire hooks are called
+func (s *Sett) removeItem(c *opCall, txn Txn, key []byte, old Item) error {
+	o := s.options()
+	if c != nil && old != nil && o.audit != nil {
+		err := s.audit(c, txn, key, old, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if old != nil && o.history != nil {
+		err := s.recordHistory(txn, key, old, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if old != nil && len(o.indexes) > 0 {
+		err := s.updateIndexes(txn, key, old, nil, o.indexes)
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Delete(key)
+}
+
+// currentItem returns the item of the key, nil if there is none
+func currentItem(txn Txn, key []byte) (Item, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// invalidate drops the key from the cache of the table. A reader could
+// still cache the value being replaced until the write commits, which
+// is harmless as cached values are checked against the key's version
+func (s *Sett) invalidate(key []byte) {
+	if c := s.options().cache; c != nil {
+		c.remove(string(key))
+	}
+}
@@ -0,0 +1,509 @@
+package sett
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeSeries stores the points of series, numbers at given times, in a
+// table. The key of a point is the name of its series, a 0 byte and the
+// time in nanoseconds, big endian with the sign bit flipped, so that the
+// points of a series are in time order and Range reads only the points
+// asked for. The points expire with the TTL of the table handle:
+//
+//	metrics := s.Table("metrics").WithTTL(7 * 24 * time.Hour).TimeSeries()
+//	err := metrics.Add("device1.temp", time.Now(), 21.5)
+//
+// The table should hold nothing but the points
+type TimeSeries struct {
+	s *Sett
+}
+
+// Point is a value of a series at a time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Rollup summarizes the points of a series in the interval starting at Time
+type Rollup struct {
+	Time time.Time
+	FieldStats
+}
+
+// RollupOptions configures the rollup job started by StartRollups
+type RollupOptions struct {
+	// Intervals are the lengths of the buckets the points are rolled up
+	// into, one table for each. Defaults to a minute and an hour
+	Intervals []time.Duration
+	// Every is the interval between the runs of the job.
+	// Defaults to the shortest of the Intervals
+	Every time.Duration
+	// Delay is how late points may be written. A bucket is rolled up
+	// once it ended Delay ago, the points written later are left out
+	Delay time.Duration
+	// TTL is the retention of the rollups, which don't expire if 0
+	TTL time.Duration
+	// OnError is called when a run fails
+	OnError func(err error)
+}
+
+var rollupProgressPrefix = []byte("tsr:")
+
+// TimeSeries returns the table of the handle as a time series
+func (s *Sett) TimeSeries() *TimeSeries {
+	return &TimeSeries{s: s}
+}
+
+// pointKey returns the key of the point of the series at ns
+func pointKey(series string, ns int64) string {
+	b := make([]byte, len(series)+9)
+	copy(b, series)
+	binary.BigEndian.PutUint64(b[len(series)+1:], uint64(ns)^(1<<63))
+	return string(b)
+}
+
+// parsePointKey returns the series and the time of a point key
+func parsePointKey(key []byte) (string, int64, bool) {
+	i := bytes.IndexByte(key, 0)
+	if i <= 0 || len(key) != i+9 {
+		return "", 0, false
+	}
+	return string(key[:i]), int64(binary.BigEndian.Uint64(key[i+1:]) ^ (1 << 63)), true
+}
+
+func checkSeries(series string) error {
+	if len(series) == 0 || strings.IndexByte(series, 0) >= 0 {
+		return fmt.Errorf("Invalid series name %q", series)
+	}
+	return nil
+}
+
+func encodePoint(v float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	return b
+}
+
+func decodePoint(val []byte) (float64, error) {
+	if len(val) != 8 {
+		return 0, errors.New("Invalid point value")
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(val)), nil
+}
+
+func encodeRollup(fs FieldStats) []byte {
+	b := make([]byte, 32)
+	binary.BigEndian.PutUint64(b, uint64(fs.Count))
+	for i, f := range []float64{fs.Sum, fs.Min, fs.Max} {
+		binary.BigEndian.PutUint64(b[8*(i+1):], math.Float64bits(f))
+	}
+	return b
+}
+
+func decodeRollup(val []byte) (FieldStats, error) {
+	if len(val) != 32 {
+		return FieldStats{}, errors.New("Invalid rollup value")
+	}
+	f := func(i int) float64 {
+		return math.Float64frombits(binary.BigEndian.Uint64(val[8*i:]))
+	}
+	return FieldStats{Count: int(binary.BigEndian.Uint64(val)), Sum: f(1), Min: f(2), Max: f(3)}, nil
+}
+
+// Add writes the value of the series at t, replacing
+// the value the series had at the same time
+func (ts *TimeSeries) Add(series string, t time.Time, value float64, opts ...SetOption) error {
+	return ts.AddPointsCtx(context.Background(), series, []Point{{Time: t, Value: value}}, opts...)
+}
+
+// AddCtx is Add with a context
+func (ts *TimeSeries) AddCtx(ctx context.Context, series string, t time.Time, value float64, opts ...SetOption) error {
+	return ts.AddPointsCtx(ctx, series, []Point{{Time: t, Value: value}}, opts...)
+}
+
+// AddPoints writes the points of the series, in transactions of
+// up to 1000 points
+func (ts *TimeSeries) AddPoints(series string, points []Point, opts ...SetOption) error {
+	return ts.AddPointsCtx(context.Background(), series, points, opts...)
+}
+
+// AddPointsCtx is AddPoints with a context
+func (ts *TimeSeries) AddPointsCtx(ctx context.Context, series string, points []Point, opts ...SetOption) error {
+	err := checkSeries(series)
+	if err != nil {
+		return err
+	}
+	return ts.s.do(ctx, OpAddPoints, series, points, func(c *opCall) error {
+		points, ok := c.value.([]Point)
+		if !ok {
+			return fmt.Errorf("AddPoints needs the points as []Point, got %T", c.value)
+		}
+		for start := 0; start < len(points); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(points) {
+				end = len(points)
+			}
+			err := ts.s.db.Update(func(txn Txn) error {
+				for _, p := range points[start:end] {
+					if err := c.canceled(); err != nil {
+						return err
+					}
+					si := NewSettItem(ts.s, txn, pointKey(series, p.Time.UnixNano()))
+					si.WithOptions(opts...)
+					err := si.writeValue(encodePoint(p.Value), POINT_TYPE)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Range returns the points of the series from from, included,
+// to to, excluded, in time order
+func (ts *TimeSeries) Range(series string, from, to time.Time) ([]Point, error) {
+	return ts.RangeCtx(context.Background(), series, from, to)
+}
+
+// RangeCtx is Range with a context
+func (ts *TimeSeries) RangeCtx(ctx context.Context, series string, from, to time.Time) (points []Point, err error) {
+	err = ts.s.do(ctx, OpRange, series, nil, func(c *opCall) error {
+		defer func() { c.result = points }()
+		return ts.scanRange(c, series, from.UnixNano(), to.UnixNano(), POINT_TYPE, func(ns int64, val []byte) error {
+			v, err := decodePoint(val)
+			points = append(points, Point{Time: time.Unix(0, ns), Value: v})
+			return err
+		})
+	})
+	return points, err
+}
+
+// scanRange passes the plain values of the series in [from, to) to fn
+func (ts *TimeSeries) scanRange(c *opCall, series string, from, to int64, vtype byte, fn func(ns int64, val []byte) error) error {
+	err := checkSeries(series)
+	if err != nil {
+		return err
+	}
+	return ts.s.db.View(func(txn Txn) error {
+		prefix := []byte(ts.s.makeKey(series + "\x00"))
+		end := []byte(ts.s.makeKey(pointKey(series, to)))
+		it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Seek([]byte(ts.s.makeKey(pointKey(series, from)))); it.Valid(); it.Next() {
+			if err := c.canceled(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if bytes.Compare(item.Key(), end) >= 0 {
+				break
+			}
+			if item.UserMeta()&0x0F != vtype {
+				return fmt.Errorf("%s is not a time series", ts.s.table)
+			}
+			key := item.Key()[len(ts.s.makeKey("")):]
+			_, ns, ok := parsePointKey(key)
+			if !ok {
+				continue
+			}
+			// the key is the associated data of encrypted values
+			val, err := NewSettItem(ts.s, txn, string(key)).readValue(item)
+			if err != nil {
+				return err
+			}
+			err = fn(ns, val)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Series returns the names of the series of the table
+func (ts *TimeSeries) Series() ([]string, error) {
+	return ts.SeriesCtx(context.Background())
+}
+
+// SeriesCtx is Series with a context
+func (ts *TimeSeries) SeriesCtx(ctx context.Context) (series []string, err error) {
+	err = ts.s.do(ctx, OpKeys, "", nil, func(c *opCall) error {
+		defer func() { c.result = series }()
+		return ts.s.db.View(func(txn Txn) error {
+			return ts.series(c, txn, &series)
+		})
+	})
+	return series, err
+}
+
+func (ts *TimeSeries) series(c *opCall, txn Txn, series *[]string) error {
+	prefix := []byte(ts.s.makeKey(""))
+	it := txn.NewIterator(IteratorOptions{Prefix: prefix, KeysOnly: true})
+	defer it.Close()
+	it.Seek(prefix)
+	for it.Valid() {
+		if err := c.canceled(); err != nil {
+			return err
+		}
+		name, _, ok := parsePointKey(it.Item().Key()[len(prefix):])
+		if !ok || isSystemKey(it.Item().Key()) {
+			it.Next()
+			continue
+		}
+		*series = append(*series, name)
+		// past the last point of the series
+		it.Seek([]byte(ts.s.makeKey(name + "\x01")))
+	}
+	return nil
+}
+
+// rollupTable returns the table holding the rollups of the interval,
+// named after the table and the interval, like metrics_1m. The rollups
+// of an encrypted table are encrypted with the same keys
+func (ts *TimeSeries) rollupTable(interval time.Duration) *TimeSeries {
+	label := interval.String()
+	if strings.HasSuffix(label, "m0s") {
+		label = label[:len(label)-2]
+	}
+	if strings.HasSuffix(label, "h0m") {
+		label = label[:len(label)-2]
+	}
+	rt := ts.s.Table(ts.s.table + "_" + label)
+	if keys := ts.s.options().keys; keys != nil && rt.options().keys == nil {
+		rt.WithEncryption(keys)
+	}
+	return rt.TimeSeries()
+}
+
+// Rollups returns the rollups of the series in the interval
+// starting from from, included, to to, excluded
+func (ts *TimeSeries) Rollups(series string, interval time.Duration, from, to time.Time) ([]Rollup, error) {
+	return ts.RollupsCtx(context.Background(), series, interval, from, to)
+}
+
+// RollupsCtx is Rollups with a context
+func (ts *TimeSeries) RollupsCtx(ctx context.Context, series string, interval time.Duration, from, to time.Time) (rollups []Rollup, err error) {
+	rt := ts.rollupTable(interval)
+	err = rt.s.do(ctx, OpRange, series, nil, func(c *opCall) error {
+		defer func() { c.result = rollups }()
+		return rt.scanRange(c, series, from.UnixNano(), to.UnixNano(), ROLLUP_TYPE, func(ns int64, val []byte) error {
+			fs, err := decodeRollup(val)
+			rollups = append(rollups, Rollup{Time: time.Unix(0, ns), FieldStats: fs})
+			return err
+		})
+	})
+	return rollups, err
+}
+
+// Rollup computes the count, sum, min and max of the points of each
+// series in the buckets of the interval starting from from to to, both
+// truncated to the interval, and writes them to the rollup table of the
+// interval. Returns the number of rollups written. The rollups already
+// there for these buckets are replaced, so it can be run again when
+// late points were added
+func (ts *TimeSeries) Rollup(interval time.Duration, from, to time.Time, opts ...SetOption) (int, error) {
+	return ts.RollupCtx(context.Background(), interval, from, to, opts...)
+}
+
+// RollupCtx is Rollup with a context
+func (ts *TimeSeries) RollupCtx(ctx context.Context, interval time.Duration, from, to time.Time, opts ...SetOption) (n int, err error) {
+	if interval <= 0 {
+		return 0, fmt.Errorf("Rollup needs a positive interval")
+	}
+	err = ts.s.do(ctx, OpRollup, "", nil, func(c *opCall) error {
+		var err error
+		n, err = ts.rollup(c, interval, from.Truncate(interval).UnixNano(), to.Truncate(interval).UnixNano(), opts)
+		c.result = n
+		return err
+	})
+	return n, err
+}
+
+type rollupBucket struct {
+	series string
+	start  int64
+	stats  FieldStats
+}
+
+func (ts *TimeSeries) rollup(c *opCall, interval time.Duration, from, to int64, opts []SetOption) (int, error) {
+	var buckets []rollupBucket
+	err := ts.s.db.View(func(txn Txn) error {
+		prefix := []byte(ts.s.makeKey(""))
+		it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		it.Seek(prefix)
+		for it.Valid() {
+			series, _, ok := parsePointKey(it.Item().Key()[len(prefix):])
+			if !ok || isSystemKey(it.Item().Key()) {
+				it.Next()
+				continue
+			}
+			it.Seek([]byte(ts.s.makeKey(pointKey(series, from))))
+			for ; it.Valid(); it.Next() {
+				if err := c.canceled(); err != nil {
+					return err
+				}
+				item := it.Item()
+				key := item.Key()[len(prefix):]
+				name, ns, ok := parsePointKey(key)
+				if !ok || name != series || ns >= to {
+					break
+				}
+				if item.UserMeta()&0x0F != POINT_TYPE {
+					return fmt.Errorf("%s is not a time series", ts.s.table)
+				}
+				val, err := NewSettItem(ts.s, txn, string(key)).readValue(item)
+				if err != nil {
+					return err
+				}
+				v, err := decodePoint(val)
+				if err != nil {
+					return err
+				}
+				start := time.Unix(0, ns).Truncate(interval).UnixNano()
+				if len(buckets) == 0 || buckets[len(buckets)-1].series != series || buckets[len(buckets)-1].start != start {
+					buckets = append(buckets, rollupBucket{series: series, start: start})
+				}
+				buckets[len(buckets)-1].stats.add(v)
+			}
+			// past the last point of the series
+			it.Seek([]byte(ts.s.makeKey(series + "\x01")))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	rt := ts.rollupTable(interval)
+	for start := 0; start < len(buckets); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(buckets) {
+			end = len(buckets)
+		}
+		err = ts.s.db.Update(func(txn Txn) error {
+			for _, b := range buckets[start:end] {
+				si := NewSettItem(rt.s, txn, pointKey(b.series, b.start))
+				si.call = c
+				si.WithOptions(opts...)
+				err := si.writeValue(encodeRollup(b.stats), ROLLUP_TYPE)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return start, err
+		}
+	}
+	return len(buckets), nil
+}
+
+// StartRollups starts a goroutine rolling up the points of the table
+// into the rollup tables of the intervals, read with Rollups, until
+// stop is called or the store is closed. Each run rolls up the buckets
+// ended since the last one; the first run rolls up all the points
+func (ts *TimeSeries) StartRollups(o RollupOptions) (stop func()) {
+	if len(o.Intervals) == 0 {
+		o.Intervals = []time.Duration{time.Minute, time.Hour}
+	}
+	if o.Every <= 0 {
+		o.Every = o.Intervals[0]
+		for _, i := range o.Intervals {
+			if i < o.Every {
+				o.Every = i
+			}
+		}
+	}
+	closed := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+	stop = func() {
+		once.Do(func() { close(closed) })
+		wg.Wait()
+	}
+	ts.s.shared.onClose(stop)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(o.Every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := ts.rollupPending(o, time.Now())
+				if err != nil && o.OnError != nil {
+					o.OnError(err)
+				}
+			case <-closed:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// rollupPending rolls up the buckets ended since the last run, keeping
+// the end of the last bucket rolled up for each interval
+func (ts *TimeSeries) rollupPending(o RollupOptions, now time.Time) error {
+	var opts []SetOption
+	if o.TTL > 0 {
+		opts = append(opts, ExpireIn(o.TTL))
+	}
+	for _, interval := range o.Intervals {
+		if interval <= 0 {
+			continue
+		}
+		progressKey := systemKey(rollupProgressPrefix, lengthPrefixed([]byte(ts.s.table)), lengthPrefixed([]byte(interval.String())))
+		from := int64(math.MinInt64)
+		err := ts.s.db.View(func(txn Txn) error {
+			item, err := currentItem(txn, progressKey)
+			if err != nil || item == nil {
+				return err
+			}
+			return item.Value(func(val []byte) error {
+				if len(val) == 8 {
+					from = int64(binary.BigEndian.Uint64(val))
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		to := now.Add(-o.Delay).Truncate(interval).UnixNano()
+		if to <= from {
+			continue
+		}
+		err = ts.s.do(context.Background(), OpRollup, "", nil, func(c *opCall) error {
+			n, err := ts.rollup(c, interval, from, to, opts)
+			c.result = n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		progress := make([]byte, 8)
+		binary.BigEndian.PutUint64(progress, uint64(to))
+		err = ts.s.db.Update(func(txn Txn) error {
+			return txn.Set(&Entry{Key: progressKey, Value: progress})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package sett_test
+
+import (
+	"github.com/prasanthmj/sett/v2"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeSeries(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testTimeSeries(t, b.open(t))
+		})
+	}
+}
+
+func testTimeSeries(t *testing.T, s *sett.Sett) {
+	metrics := s.Table("metrics").TimeSeries()
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	var points []sett.Point
+	// a point every 20 seconds for 2 hours
+	for i := 0; i < 360; i++ {
+		points = append(points, sett.Point{Time: base.Add(time.Duration(i) * 20 * time.Second), Value: float64(i % 3)})
+	}
+	err := metrics.AddPoints("dev1.temp", points)
+	if err != nil {
+		t.Fatalf("AddPoints failed %v", err)
+	}
+	metrics.Add("dev2.temp", base.Add(-time.Hour), -5)
+	metrics.Add("dev1", base, 100)
+	metrics.Add("dev1.temp", time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC), 7)
+
+	series, err := metrics.Series()
+	if err != nil || !reflect.DeepEqual(series, []string{"dev1", "dev1.temp", "dev2.temp"}) {
+		t.Errorf("Unexpected series %v %v", series, err)
+	}
+	got, err := metrics.Range("dev1.temp", base.Add(time.Minute), base.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Range failed %v", err)
+	}
+	want := []sett.Point{
+		{Time: base.Add(60 * time.Second), Value: 0},
+		{Time: base.Add(80 * time.Second), Value: 1},
+		{Time: base.Add(100 * time.Second), Value: 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Value != want[i].Value {
+			t.Errorf("Expected %v, got %v", want[i], got[i])
+		}
+	}
+	got, _ = metrics.Range("dev1.temp", time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), base)
+	if len(got) != 1 || got[0].Value != 7 {
+		t.Errorf("Expected the point before 1970, got %v", got)
+	}
+	got, _ = metrics.Range("dev1", base.Add(-time.Hour), base.Add(time.Hour))
+	if len(got) != 1 || got[0].Value != 100 {
+		t.Errorf("Expected only the point of dev1, got %v", got)
+	}
+	err = metrics.Add("bad\x00name", base, 1)
+	if err == nil {
+		t.Errorf("Expected an error for a series name with a 0 byte")
+	}
+
+	n, err := metrics.Rollup(time.Minute, base, base.Add(2*time.Hour))
+	if err != nil || n != 121 {
+		t.Fatalf("Expected 120 minutes of dev1.temp and 1 of dev1, got %d %v", n, err)
+	}
+	rollups, err := metrics.Rollups("dev1.temp", time.Minute, base, base.Add(2*time.Minute))
+	if err != nil || len(rollups) != 2 {
+		t.Fatalf("Expected 2 rollups, got %v %v", rollups, err)
+	}
+	if r := rollups[1]; !r.Time.Equal(base.Add(time.Minute)) || r.Count != 3 || r.Min != 0 || r.Max != 2 || r.Mean() != 1 {
+		t.Errorf("Unexpected rollup %+v", r)
+	}
+
+	// the job rolls up everything on its first run
+	errs := make(chan error, 10)
+	stop := metrics.StartRollups(sett.RollupOptions{
+		Intervals: []time.Duration{time.Hour},
+		Every:     10 * time.Millisecond,
+		TTL:       time.Hour,
+		OnError:   func(err error) { errs <- err },
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rollups, err = metrics.Rollups("dev1.temp", time.Hour, base.Add(-time.Hour), base.Add(3*time.Hour))
+		if err != nil {
+			t.Fatalf("Rollups failed %v", err)
+		}
+		if len(rollups) == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	select {
+	case err := <-errs:
+		t.Errorf("Rollup job failed %v", err)
+	default:
+	}
+	if len(rollups) != 2 || rollups[0].Count != 180 || rollups[0].Sum != 180 {
+		t.Errorf("Unexpected hourly rollups %+v", rollups)
+	}
+	hourly := s.Table("metrics_1h")
+	keys, _ := hourly.Keys()
+	if len(keys) == 0 {
+		t.Fatalf("Expected the rollups in metrics_1h")
+	}
+	if ttl, _ := hourly.TTL(keys[0]); ttl <= 0 {
+		t.Errorf("Expected the rollups to expire")
+	}
+
+	// retention with the TTL of the handle
+	recent := s.Table("recent").WithTTL(time.Hour).TimeSeries()
+	recent.Add("cpu", time.Now(), 0.5)
+	recent.Add("cpu", time.Now().Add(-time.Minute), 0.2, sett.ExpireAt(time.Now().Add(-time.Second)))
+	got, _ = recent.Range("cpu", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if len(got) != 1 || got[0].Value != 0.5 {
+		t.Errorf("Expected the expired point to be gone, got %v", got)
+	}
+	keys, _ = s.Table("recent").Keys()
+	if ttl, _ := s.Table("recent").TTL(keys[0]); ttl <= 0 {
+		t.Errorf("Expected the points to get the TTL of the handle")
+	}
+	v, _, err := s.Table("recent").GetWithVersion(keys[0])
+	if err != nil || v != 0.5 {
+		t.Errorf("Expected the value of the point, got %v %v", v, err)
+	}
+
+	_, err = s.Table("notes").TimeSeries().Range("x", base, base.Add(time.Hour))
+	if err != nil {
+		t.Errorf("Range of a missing series failed %v", err)
+	}
+}
+
+func TestEncryptedTimeSeries(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			keyFile := filepath.Join(t.TempDir(), "keys")
+			writeKeyFile(t, keyFile, "k1 "+testKey1+"\n")
+			kp, err := sett.NewFileKeyProvider(keyFile)
+			if err != nil {
+				t.Fatalf("Couldn't load keys %v", err)
+			}
+			metrics := s.Table("secret").WithEncryption(kp).TimeSeries()
+			base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+			for i := 0; i < 4; i++ {
+				err = metrics.Add("dev1", base.Add(time.Duration(i)*30*time.Second), float64(i))
+				if err != nil {
+					t.Fatalf("Add failed %v", err)
+				}
+			}
+			points, err := metrics.Range("dev1", base, base.Add(time.Hour))
+			if err != nil || len(points) != 4 || points[3].Value != 3 {
+				t.Fatalf("Expected the 4 points decrypted, got %v %v", points, err)
+			}
+			n, err := metrics.Rollup(time.Minute, base, base.Add(time.Hour))
+			if err != nil || n != 2 {
+				t.Fatalf("Expected 2 rollups, got %d %v", n, err)
+			}
+			rollups, err := metrics.Rollups("dev1", time.Minute, base, base.Add(time.Hour))
+			if err != nil || len(rollups) != 2 || rollups[1].Sum != 5 {
+				t.Errorf("Unexpected rollups %+v %v", rollups, err)
+			}
+			// the rollups are encrypted as well
+			minutes := s.Table("secret_1m").WithEncryption(nil)
+			keys, _ := minutes.Keys()
+			if len(keys) != 2 {
+				t.Fatalf("Expected the rollups in secret_1m, got %q", keys)
+			}
+			_, _, err = minutes.GetWithVersion(keys[0])
+			if err == nil {
+				t.Errorf("Expected the rollups to need the keys")
+			}
+		})
+	}
+}
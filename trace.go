@@ -0,0 +1,110 @@
+package sett
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Tracer creates a span for each operation on the store,
+// for example to adapt an OpenTelemetry tracer
+type Tracer interface {
+	// Start is called before the operation. The returned context is
+	// passed on to the operation, table is the table of the handle
+	// and key is empty for the operations on the whole table
+	Start(ctx context.Context, op OpKind, table string, key string) (context.Context, Span)
+}
+
+// Span is the trace of an operation
+type Span interface {
+	// End is called when the operation completes, with the size in
+	// bytes of the value read or written, and the error if it failed
+	End(key string, valueSize int, err error)
+}
+
+type tracerHolder struct {
+	t Tracer
+}
+
+// WithTracer traces the operations on every table of the store with t
+func (s *Sett) WithTracer(t Tracer) *Sett {
+	s.shared.tracer.Store(&tracerHolder{t: t})
+	return s
+}
+
+func (s *Sett) tracer() Tracer {
+	h, _ := s.shared.tracer.Load().(*tracerHolder)
+	if h == nil {
+		return nil
+	}
+	return h.t
+}
+
+// opCall is an operation in progress
+type opCall struct {
+	ctx   context.Context
+	op    OpKind
+	key   string
+	value interface{}
+	// result is the value read, or the key generated by Insert
+	result interface{}
+	size   int
+}
+
+// do runs fn as the operation through the middleware, reporting
+// it to the metrics and the tracer. value is the value written, if any
+func (s *Sett) do(ctx context.Context, op OpKind, key string, value interface{}, fn func(c *opCall) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	start := time.Now()
+	var span Span
+	if t := s.tracer(); t != nil {
+		ctx, span = t.Start(ctx, op, s.table, key)
+	}
+	c := &opCall{ctx: ctx, op: op, key: key, value: value}
+	handler := func(o Op) error {
+		c.ctx, c.key, c.value = o.Ctx, o.Key, o.Value
+		if c.ctx == nil {
+			c.ctx = context.Background()
+		}
+		err := c.ctx.Err()
+		if err != nil {
+			return err
+		}
+		return fn(c)
+	}
+	err := s.chain(handler)(Op{Ctx: ctx, Kind: op, Table: s.table, Key: key, Value: value, call: c})
+	if span != nil {
+		span.End(c.key, c.size, err)
+	}
+	if m := s.metrics(); m != nil {
+		m.ObserveOp(s.table, op, time.Since(start), err)
+		if errors.Is(err, ErrLocked) {
+			m.Count(s.table, EventLockContention)
+		}
+		if errors.Is(err, ErrConflict) {
+			m.Count(s.table, EventConflict)
+		}
+		if errors.Is(err, ErrVersionConflict) {
+			m.Count(s.table, EventVersionConflict)
+		}
+	}
+	return err
+}
+
+// canceled returns the error of the context once it is done
+func (c *opCall) canceled() error {
+	if c == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
+// setSize records the size of the value read or written,
+// the size of the written value for the operations doing both
+func (c *opCall) setSize(n int) {
+	if c != nil {
+		c.size = n
+	}
+}
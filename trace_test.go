@@ -0,0 +1,149 @@
+package sett_test
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"github.com/prasanthmj/sett/v2/setttest"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanRecord struct {
+	op    sett.OpKind
+	table string
+	key   string
+	size  int
+	err   error
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []spanRecord
+}
+
+type ctxKey struct{}
+
+type testSpan struct {
+	t   *testTracer
+	rec spanRecord
+}
+
+func (tt *testTracer) Start(ctx context.Context, op sett.OpKind, table string, key string) (context.Context, sett.Span) {
+	return context.WithValue(ctx, ctxKey{}, op), &testSpan{t: tt, rec: spanRecord{op: op, table: table, key: key}}
+}
+
+func (ts *testSpan) End(key string, size int, err error) {
+	ts.rec.key, ts.rec.size, ts.rec.err = key, size, err
+	ts.t.mu.Lock()
+	defer ts.t.mu.Unlock()
+	ts.t.spans = append(ts.t.spans, ts.rec)
+}
+
+func TestTracer(t *testing.T) {
+	s := setttest.New(t)
+	tt := &testTracer{}
+	s.WithTracer(tt)
+
+	ctx := context.Background()
+	tbl := s.Table("t")
+	tbl.SetStrCtx(ctx, "k", "value")
+	tbl.GetStrCtx(ctx, "k")
+	tbl.GetCtx(ctx, "missing")
+	tbl.KeysCtx(ctx)
+
+	want := []spanRecord{
+		{op: sett.OpSet, table: "t", key: "k", size: 5},
+		{op: sett.OpGet, table: "t", key: "k", size: 5},
+		{op: sett.OpGet, table: "t", key: "missing", err: sett.ErrKeyNotFound},
+		{op: sett.OpKeys, table: "t"},
+	}
+	if len(tt.spans) != len(want) {
+		t.Fatalf("Expected %d spans, got %v", len(want), tt.spans)
+	}
+	for i, w := range want {
+		got := tt.spans[i]
+		if got.op != w.op || got.table != w.table || got.key != w.key || got.size != w.size || !errors.Is(got.err, w.err) {
+			t.Errorf("Expected span %v, got %v", w, got)
+		}
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	gob.Register(&TaskObj{})
+	s := setttest.New(t)
+	tbl := s.Table("t")
+	for i := 0; i < 100; i++ {
+		tbl.SetStruct(fmt.Sprintf("k%03d", i), &TaskObj{ID: uint64(i)})
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := tbl.GetCtx(canceled, "k001")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	_, err = tbl.KeysCtx(canceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Keys, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	seen := 0
+	_, err = tbl.FilterCtx(ctx, func(k string, v interface{}) bool {
+		seen++
+		if seen == 10 {
+			cancel()
+		}
+		return true
+	})
+	if !errors.Is(err, context.Canceled) || seen != 10 {
+		t.Errorf("Filter didn't stop when canceled, saw %d items %v", seen, err)
+	}
+
+	err = tbl.DropCtx(canceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Drop, got %v", err)
+	}
+	keys, _ := tbl.Keys()
+	if len(keys) != 100 {
+		t.Errorf("Canceled Drop removed keys, %d left", len(keys))
+	}
+
+	compute := func() (interface{}, error) { return "v", nil }
+	for name, call := range map[string]func() error{
+		"TTL":     func() error { _, err := tbl.TTLCtx(canceled, "k001"); return err },
+		"Touch":   func() error { return tbl.TouchCtx(canceled, "k001", time.Hour) },
+		"Persist": func() error { return tbl.PersistCtx(canceled, "k001") },
+		"GetOrCompute": func() error {
+			_, err := tbl.GetOrComputeCtx(canceled, "missing", time.Hour, compute)
+			return err
+		},
+		"Export": func() error { _, err := s.ExportTableCtx(canceled, io.Discard, "t"); return err },
+		"Import": func() error {
+			_, err := s.ImportTableCtx(canceled, strings.NewReader(""), sett.ImportSkip)
+			return err
+		},
+		"AuditByKey": func() error { _, err := tbl.AuditByKeyCtx(canceled, "k001", 0); return err },
+		"Series":     func() error { _, err := tbl.TimeSeries().SeriesCtx(canceled); return err },
+		"Backup":     func() error { _, err := s.BackupCtx(canceled, io.Discard, 0); return err },
+		"Restore":    func() error { return s.RestoreCtx(canceled, strings.NewReader("")) },
+		"RotateKeys": func() error { _, err := tbl.RotateKeysCtx(canceled, 10); return err },
+		"RebuildIndex": func() error {
+			tbl.CreateIndex("ID")
+			return tbl.RebuildIndexCtx(canceled, "ID")
+		},
+	} {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled from %s, got %v", name, err)
+		}
+	}
+	if tbl.HasKey("missing") {
+		t.Errorf("Canceled GetOrCompute stored the value")
+	}
+}
@@ -0,0 +1,123 @@
+package sett
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SetOption changes how a single write is made.
+// Options given to a call take precedence over the TTL of the table handle
+type SetOption func(o *setOptions)
+
+type setOptions struct {
+	// expire is true when expiresAt overrides the TTL of the handle
+	expire    bool
+	expiresAt uint64
+	keepTTL   bool
+}
+
+// ExpireIn sets the value to expire after d.
+// A d of 0 or less stores the value without expiry
+func ExpireIn(d time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.expire = true
+		o.expiresAt = 0
+		if d > 0 {
+			o.expiresAt = uint64(time.Now().Add(d).Unix())
+		}
+	}
+}
+
+// ExpireAt sets the value to expire at t
+func ExpireAt(t time.Time) SetOption {
+	return func(o *setOptions) {
+		o.expire = true
+		o.expiresAt = uint64(t.Unix())
+	}
+}
+
+// KeepTTL keeps the expiry the item already has. Useful with Update,
+// which otherwise applies the TTL of the handle to the updated value
+func KeepTTL() SetOption {
+	return func(o *setOptions) {
+		o.keepTTL = true
+	}
+}
+
+// TTL returns the time left before the key expires.
+// Returns 0 if the key doesn't expire
+func (s *Sett) TTL(key string) (time.Duration, error) {
+	return s.TTLCtx(context.Background(), key)
+}
+
+// TTLCtx is TTL with a context
+func (s *Sett) TTLCtx(ctx context.Context, key string) (ttl time.Duration, err error) {
+	err = s.do(ctx, OpTTL, key, nil, func(c *opCall) error {
+		return s.db.View(func(txn Txn) error {
+			item, err := txn.Get([]byte(s.makeKey(c.key)))
+			if err != nil {
+				return err
+			}
+			ttl = remainingTTL(item.ExpiresAt())
+			c.result = ttl
+			return nil
+		})
+	})
+	return ttl, err
+}
+
+func remainingTTL(expiresAt uint64) time.Duration {
+	if expiresAt == 0 {
+		return 0
+	}
+	ttl := time.Until(time.Unix(int64(expiresAt), 0))
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// Touch sets the key to expire after d from now, without
+// changing the value. It works on locked items as well
+func (s *Sett) Touch(key string, d time.Duration) error {
+	return s.TouchCtx(context.Background(), key, d)
+}
+
+// TouchCtx is Touch with a context
+func (s *Sett) TouchCtx(ctx context.Context, key string, d time.Duration) error {
+	if d <= 0 {
+		return errors.New("Touch needs a positive duration. Use Persist to remove the expiry")
+	}
+	return s.do(ctx, OpExpire, key, nil, func(c *opCall) error {
+		return s.setExpiry(c, uint64(time.Now().Add(d).Unix()))
+	})
+}
+
+// Persist removes the expiry of the key
+func (s *Sett) Persist(key string) error {
+	return s.PersistCtx(context.Background(), key)
+}
+
+// PersistCtx is Persist with a context
+func (s *Sett) PersistCtx(ctx context.Context, key string) error {
+	return s.do(ctx, OpExpire, key, nil, func(c *opCall) error {
+		return s.setExpiry(c, 0)
+	})
+}
+
+func (s *Sett) setExpiry(c *opCall, expiresAt uint64) error {
+	return s.db.Update(func(txn Txn) error {
+		k := []byte(s.makeKey(c.key))
+		item, err := txn.Get(k)
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		// TTL changes are not audited
+		return s.putEntry(nil, txn, &Entry{Key: k, Value: val, UserMeta: item.UserMeta(), ExpiresAt: expiresAt})
+	})
+}
@@ -0,0 +1,972 @@
+package sett
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// VectorMetric is how the distance between two vectors is measured
+type VectorMetric string
+
+const (
+	// Cosine is 1 minus the cosine similarity of the vectors
+	Cosine VectorMetric = "cosine"
+	// Euclidean is the straight line distance
+	Euclidean VectorMetric = "euclidean"
+	// DotProduct is the negated dot product, for normalized vectors
+	DotProduct VectorMetric = "dot"
+)
+
+// VectorResult is a value found by Nearest
+type VectorResult struct {
+	Key string
+	// Distance is from the searched vector, in the metric of the index
+	Distance float64
+	Value    interface{}
+}
+
+// VectorOption configures a VectorIndex
+type VectorOption func(o *vectorOptions)
+
+type vectorOptions struct {
+	hnsw           bool
+	m              int
+	efConstruction int
+	efSearch       int
+}
+
+// VectorHNSW makes Nearest approximate, walking a HNSW graph (Malkov and
+// Yashunin, "Efficient and robust approximate nearest neighbor search
+// using Hierarchical Navigable Small World graphs") instead of comparing
+// the vector with all the others. m is the number of neighbors of each
+// vector in the graph, 16 if 0. efConstruction and efSearch are the
+// number of candidates considered when adding a vector and when searching,
+// 200 and 50 if 0. Higher values find the nearest vectors more reliably,
+// but are slower
+func VectorHNSW(m, efConstruction, efSearch int) VectorOption {
+	return func(o *vectorOptions) {
+		o.hnsw = true
+		if m > 0 {
+			o.m = m
+		}
+		if efConstruction > 0 {
+			o.efConstruction = efConstruction
+		}
+		if efSearch > 0 {
+			o.efSearch = efSearch
+		}
+	}
+}
+
+var (
+	vectorPrefix     = []byte("vec:")
+	vectorMarker     = []byte("vecm:")
+	hnswNodePrefix   = []byte("hnsw:")
+	hnswEntryPrefix  = []byte("hnswe:")
+	errVectorDropped = errors.New("The vector index was dropped")
+)
+
+// maxHNSWLevel caps the levels of the graph
+const maxHNSWLevel = 16
+
+// VectorIndex finds the values of a table with the vectors in a field
+// closest to a vector. The vectors are under
+// vectorPrefix | len(table) (2 bytes) | table | len(field) (2 bytes) | field | key
+// as big endian float32s. With VectorHNSW, the neighbors of each vector
+// in the graph are under hnswNodePrefix followed by the same, and the
+// entry point of the graph under hnswEntryPrefix
+type VectorIndex struct {
+	s      *Sett
+	field  string
+	dims   int
+	metric VectorMetric
+	opts   vectorOptions
+}
+
+// VectorIndex indexes the vectors in the field of the struct values of the
+// table, a []float32 or []float64 of dims numbers, or a path like
+// "Meta.Embedding". The index is kept up to date on every write, and
+// writing a vector with other dimensions fails. Values without a vector
+// are not in the index.
+// The index is built from the values already in the table the first time.
+// As with WithEncryption, call it each time the store is opened; changing
+// dims, metric or the HNSW mode of an existing index needs Drop first.
+// With VectorHNSW, each write updates the neighbors of the vector in the
+// graph, so concurrent writes to the table conflict more often
+func (s *Sett) VectorIndex(field string, dims int, metric VectorMetric, opts ...VectorOption) (*VectorIndex, error) {
+	if len(field) == 0 || dims <= 0 {
+		return nil, fmt.Errorf("VectorIndex needs a field and the dimensions of the vectors")
+	}
+	switch metric {
+	case Cosine, Euclidean, DotProduct:
+	default:
+		return nil, fmt.Errorf("Unknown vector metric %q", metric)
+	}
+	o := vectorOptions{m: 16, efConstruction: 200, efSearch: 50}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	vi := &VectorIndex{s: s, field: field, dims: dims, metric: metric, opts: o}
+	var built []byte
+	err := s.db.View(func(txn Txn) error {
+		item, err := txn.Get(vi.marker())
+		if err != nil {
+			return err
+		}
+		built, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	if len(built) > 0 && !bytes.Equal(built, vi.settings()) {
+		return nil, fmt.Errorf("The vector index of %s was created with other settings, Drop it first", field)
+	}
+	s.addIndex(vi.name(), vi)
+	if len(built) > 0 {
+		return vi, nil
+	}
+	return vi, vi.build(nil)
+}
+
+func (vi *VectorIndex) name() string {
+	return "vector:" + vi.field
+}
+
+func (vi *VectorIndex) base(prefix []byte) []byte {
+	return systemKey(prefix, lengthPrefixed([]byte(vi.s.table)), lengthPrefixed([]byte(vi.field)))
+}
+
+func (vi *VectorIndex) vectorsPrefix() []byte {
+	return vi.base(vectorPrefix)
+}
+
+func (vi *VectorIndex) nodesPrefix() []byte {
+	return vi.base(hnswNodePrefix)
+}
+
+func (vi *VectorIndex) entryKey() []byte {
+	return vi.base(hnswEntryPrefix)
+}
+
+func (vi *VectorIndex) marker() []byte {
+	return vi.base(vectorMarker)
+}
+
+// settings are kept in the marker, to tell if the index
+// was built with the same ones
+func (vi *VectorIndex) settings() []byte {
+	hnsw := 0
+	if vi.opts.hnsw {
+		hnsw = vi.opts.m
+	}
+	return []byte(fmt.Sprintf("%d %s %d", vi.dims, vi.metric, hnsw))
+}
+
+// build indexes the values of the table and records the settings
+func (vi *VectorIndex) build(c *opCall) error {
+	err := vi.s.buildIndex(c, vi, vi.marker())
+	if err != nil {
+		return err
+	}
+	return vi.s.db.Update(func(txn Txn) error {
+		return txn.Set(&Entry{Key: vi.marker(), Value: vi.settings()})
+	})
+}
+
+// clear removes the entries of the index
+func (vi *VectorIndex) clear() error {
+	for _, prefix := range [][]byte{vi.vectorsPrefix(), vi.nodesPrefix()} {
+		err := vi.s.deleteKeys(prefix)
+		if err != nil {
+			return err
+		}
+	}
+	return vi.s.db.Update(func(txn Txn) error {
+		err := txn.Delete(vi.entryKey())
+		if err != nil {
+			return err
+		}
+		return txn.Delete(vi.marker())
+	})
+}
+
+// Rebuild builds the index again from the values of the table, dropping
+// the graph entries left by expired values
+func (vi *VectorIndex) Rebuild() error {
+	return vi.RebuildCtx(context.Background())
+}
+
+// RebuildCtx is Rebuild with a context
+func (vi *VectorIndex) RebuildCtx(ctx context.Context) error {
+	if vi.s.options().indexes[vi.name()] != vi {
+		return errVectorDropped
+	}
+	return vi.s.do(ctx, OpRebuild, vi.field, nil, func(c *opCall) error {
+		err := vi.clear()
+		if err != nil {
+			return err
+		}
+		return vi.build(c)
+	})
+}
+
+// Drop stops indexing the vectors of the field and removes the index
+func (vi *VectorIndex) Drop() error {
+	return vi.s.DropVectorIndex(vi.field)
+}
+
+// DropVectorIndex removes the vector index of the field as Drop does,
+// without the settings of the index which VectorIndex needs
+func (s *Sett) DropVectorIndex(field string) error {
+	vi := &VectorIndex{s: s, field: field}
+	s.removeIndex(vi.name())
+	return vi.clear()
+}
+
+// vector returns the vector of the value, nil if it has none
+func (vi *VectorIndex) vector(v interface{}) ([]float32, error) {
+	fv, ok := fieldValue(v, vi.field)
+	if !ok {
+		return nil, nil
+	}
+	var vec []float32
+	switch x := fv.(type) {
+	case []float32:
+		vec = x
+	case []float64:
+		vec = make([]float32, len(x))
+		for i, f := range x {
+			vec[i] = float32(f)
+		}
+	default:
+		return nil, nil
+	}
+	if len(vec) == 0 {
+		return nil, nil
+	}
+	if len(vec) != vi.dims {
+		return nil, fmt.Errorf("The vector in %s has %d dimensions, the index %d", vi.field, len(vec), vi.dims)
+	}
+	return vec, nil
+}
+
+func (vi *VectorIndex) update(txn Txn, key string, old interface{}, val interface{}, expiresAt uint64) error {
+	var oldVec, newVec []float32
+	if old != nil {
+		oldVec, _ = vi.vector(old)
+	}
+	if val != nil {
+		var err error
+		newVec, err = vi.vector(val)
+		if err != nil {
+			return err
+		}
+	}
+	vecKey := append(vi.vectorsPrefix(), key...)
+	if newVec == nil {
+		if oldVec == nil {
+			return nil
+		}
+		err := txn.Delete(vecKey)
+		if err != nil || !vi.opts.hnsw {
+			return err
+		}
+		g, err := vi.graph(txn)
+		if err != nil {
+			return err
+		}
+		err = g.remove(key)
+		if err != nil {
+			return err
+		}
+		return g.flush()
+	}
+	err := txn.Set(&Entry{Key: vecKey, Value: encodeVector(newVec), ExpiresAt: expiresAt})
+	if err != nil || !vi.opts.hnsw {
+		return err
+	}
+	if equalVectors(oldVec, newVec) {
+		return vi.expireNode(txn, key, expiresAt)
+	}
+	g, err := vi.graph(txn)
+	if err != nil {
+		return err
+	}
+	if oldVec != nil {
+		err = g.remove(key)
+		if err != nil {
+			return err
+		}
+	}
+	err = g.insert(key, newVec, expiresAt)
+	if err != nil {
+		return err
+	}
+	return g.flush()
+}
+
+// expireNode gives the node of the key the expiry of its vector,
+// when the value is written again with another one
+func (vi *VectorIndex) expireNode(txn Txn, key string, expiresAt uint64) error {
+	nodeKey := append(vi.nodesPrefix(), key...)
+	item, err := currentItem(txn, nodeKey)
+	if err != nil || item == nil || item.ExpiresAt() == expiresAt {
+		return err
+	}
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	return txn.Set(&Entry{Key: nodeKey, Value: val, ExpiresAt: expiresAt})
+}
+
+func encodeVector(vec []float32) []byte {
+	b := make([]byte, 4*len(vec))
+	for i, f := range vec {
+		binary.BigEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	vec := make([]float32, len(b)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.BigEndian.Uint32(b[4*i:]))
+	}
+	return vec
+}
+
+func equalVectors(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// distance returns the distance between the vectors in the metric of the index
+func (vi *VectorIndex) distance(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		switch vi.metric {
+		case Euclidean:
+			dot += (x - y) * (x - y)
+		default:
+			dot += x * y
+			na += x * x
+			nb += y * y
+		}
+	}
+	switch vi.metric {
+	case Euclidean:
+		return math.Sqrt(dot)
+	case DotProduct:
+		return -dot
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return 1 - dot/math.Sqrt(na*nb)
+}
+
+// Nearest returns the k values of the table with the vectors closest to
+// vec, the closest first, among the values filter accepts if it is not
+// nil. Without VectorHNSW, vec is compared with all the vectors of the
+// index. With it, the search is approximate and may miss some of them
+func (vi *VectorIndex) Nearest(vec []float32, k int, filter FilterFunc) ([]VectorResult, error) {
+	return vi.NearestCtx(context.Background(), vec, k, filter)
+}
+
+// NearestCtx is Nearest with a context
+func (vi *VectorIndex) NearestCtx(ctx context.Context, vec []float32, k int, filter FilterFunc) (results []VectorResult, err error) {
+	if len(vec) != vi.dims || k <= 0 {
+		return nil, fmt.Errorf("Nearest needs a vector of %d dimensions and k > 0", vi.dims)
+	}
+	if vi.s.options().indexes[vi.name()] != vi {
+		return nil, errVectorDropped
+	}
+	err = vi.s.do(ctx, OpVector, "", vec, func(c *opCall) error {
+		defer func() { c.result = results }()
+		return vi.s.db.View(func(txn Txn) error {
+			var err error
+			if vi.opts.hnsw {
+				results, err = vi.nearestHNSW(c, txn, vec, k, filter)
+			} else {
+				results, err = vi.nearestExact(c, txn, vec, k, filter)
+			}
+			return err
+		})
+	})
+	return results, err
+}
+
+func (vi *VectorIndex) nearestExact(c *opCall, txn Txn, vec []float32, k int, filter FilterFunc) ([]VectorResult, error) {
+	prefix := vi.vectorsPrefix()
+	var cands hnswCands
+	it := txn.NewIterator(IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		if err := c.canceled(); err != nil {
+			return nil, err
+		}
+		item := it.Item()
+		key := string(item.Key()[len(prefix):])
+		err := item.Value(func(val []byte) error {
+			cands = append(cands, hnswCand{key: key, dist: vi.distance(vec, decodeVector(val))})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		return cands.less(cands[i], cands[j])
+	})
+	return vi.collect(c, txn, cands, k, filter)
+}
+
+// nearestHNSW searches the graph, considering more candidates
+// while the filter leaves fewer than k of them
+func (vi *VectorIndex) nearestHNSW(c *opCall, txn Txn, vec []float32, k int, filter FilterFunc) ([]VectorResult, error) {
+	g, err := vi.graph(txn)
+	if err != nil {
+		return nil, err
+	}
+	ef := vi.opts.efSearch
+	if ef < k {
+		ef = k
+	}
+	for {
+		if err := c.canceled(); err != nil {
+			return nil, err
+		}
+		cands, err := g.search(vec, ef)
+		if err != nil {
+			return nil, err
+		}
+		results, err := vi.collect(c, txn, cands, k, filter)
+		if err != nil || len(results) >= k || len(cands) < ef {
+			return results, err
+		}
+		ef *= 2
+	}
+}
+
+// collect returns the values of the first k candidates the filter accepts
+func (vi *VectorIndex) collect(c *opCall, txn Txn, cands hnswCands, k int, filter FilterFunc) ([]VectorResult, error) {
+	var results []VectorResult
+	for _, cand := range cands {
+		if len(results) == k {
+			break
+		}
+		v, ok, err := vi.s.indexedValue(c, txn, cand.key)
+		if err != nil {
+			return nil, err
+		}
+		if ok && (filter == nil || filter(cand.key, v)) {
+			results = append(results, VectorResult{Key: cand.key, Distance: cand.dist, Value: v})
+		}
+	}
+	return results, nil
+}
+
+type hnswCand struct {
+	key  string
+	dist float64
+}
+
+// hnswCands are kept sorted by distance, then key
+type hnswCands []hnswCand
+
+func (hc hnswCands) less(a, b hnswCand) bool {
+	if a.dist != b.dist {
+		return a.dist < b.dist
+	}
+	return a.key < b.key
+}
+
+func (hc hnswCands) insert(c hnswCand) hnswCands {
+	i := sort.Search(len(hc), func(i int) bool { return hc.less(c, hc[i]) })
+	hc = append(hc, hnswCand{})
+	copy(hc[i+1:], hc[i:])
+	hc[i] = c
+	return hc
+}
+
+// hnswNode has the neighbors of a vector at each of its levels
+type hnswNode struct {
+	links [][]string
+}
+
+func (n *hnswNode) encode() []byte {
+	b := []byte{byte(len(n.links) - 1)}
+	for _, links := range n.links {
+		b = binary.AppendUvarint(b, uint64(len(links)))
+		for _, l := range links {
+			b = append(b, lengthPrefixed([]byte(l))...)
+		}
+	}
+	return b
+}
+
+func decodeNode(b []byte) (*hnswNode, error) {
+	invalid := errors.New("Invalid vector index entry")
+	if len(b) == 0 {
+		return nil, invalid
+	}
+	n := &hnswNode{links: make([][]string, int(b[0])+1)}
+	b = b[1:]
+	for l := range n.links {
+		count, read := binary.Uvarint(b)
+		if read <= 0 {
+			return nil, invalid
+		}
+		b = b[read:]
+		links := make([]string, 0, count)
+		for i := uint64(0); i < count; i++ {
+			if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+				return nil, invalid
+			}
+			size := int(binary.BigEndian.Uint16(b))
+			links = append(links, string(b[2:2+size]))
+			b = b[2+size:]
+		}
+		n.links[l] = links
+	}
+	return n, nil
+}
+
+// hnswGraph reads and changes the graph of the index in a transaction.
+// The nodes changed are written by flush, expiring with their vectors
+type hnswGraph struct {
+	vi      *VectorIndex
+	txn     Txn
+	vectors map[string][]float32
+	expires map[string]uint64
+	nodes   map[string]*hnswNode
+	dirty   map[string]bool
+
+	entry      string
+	entryLevel int
+	hasEntry   bool
+	entryDirty bool
+}
+
+func (vi *VectorIndex) graph(txn Txn) (*hnswGraph, error) {
+	g := &hnswGraph{
+		vi:      vi,
+		txn:     txn,
+		vectors: make(map[string][]float32),
+		expires: make(map[string]uint64),
+		nodes:   make(map[string]*hnswNode),
+		dirty:   make(map[string]bool),
+	}
+	item, err := currentItem(txn, vi.entryKey())
+	if err != nil || item == nil {
+		return g, err
+	}
+	err = item.Value(func(val []byte) error {
+		if len(val) == 0 {
+			return errors.New("Invalid vector index entry point")
+		}
+		g.entryLevel, g.entry, g.hasEntry = int(val[0]), string(val[1:]), true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	n, err := g.node(g.entry)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		// the entry point expired
+		err = g.newEntry()
+	}
+	return g, err
+}
+
+func (g *hnswGraph) setEntry(key string, level int) {
+	g.entry, g.entryLevel, g.hasEntry, g.entryDirty = key, level, true, true
+}
+
+// vector returns the vector of the key, nil if it is gone
+func (g *hnswGraph) vector(key string) ([]float32, error) {
+	if vec, ok := g.vectors[key]; ok {
+		return vec, nil
+	}
+	var vec []float32
+	item, err := currentItem(g.txn, append(g.vi.vectorsPrefix(), key...))
+	if err != nil {
+		return nil, err
+	}
+	if item != nil {
+		err = item.Value(func(val []byte) error {
+			vec = decodeVector(val)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		g.expires[key] = item.ExpiresAt()
+	}
+	g.vectors[key] = vec
+	return vec, nil
+}
+
+// node returns the node of the key, nil if there is none
+func (g *hnswGraph) node(key string) (*hnswNode, error) {
+	if n, ok := g.nodes[key]; ok {
+		return n, nil
+	}
+	var n *hnswNode
+	item, err := currentItem(g.txn, append(g.vi.nodesPrefix(), key...))
+	if err != nil {
+		return nil, err
+	}
+	if item != nil {
+		err = item.Value(func(val []byte) error {
+			var err error
+			n, err = decodeNode(val)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	g.nodes[key] = n
+	return n, nil
+}
+
+func (g *hnswGraph) setNode(key string, n *hnswNode) {
+	g.nodes[key] = n
+	g.dirty[key] = true
+}
+
+// distanceTo returns the distance of the vector of the key
+// to vec, false when the vector is gone
+func (g *hnswGraph) distanceTo(vec []float32, key string) (float64, bool, error) {
+	other, err := g.vector(key)
+	if err != nil || other == nil {
+		return 0, false, err
+	}
+	return g.vi.distance(vec, other), true, nil
+}
+
+// level picks the top level of the key in the graph, from a hash of
+// the key so that rebuilding the graph gives the same levels
+func (g *hnswGraph) level(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+	level := int(-math.Log(u) / math.Log(float64(g.vi.opts.m)))
+	if level > maxHNSWLevel {
+		level = maxHNSWLevel
+	}
+	return level
+}
+
+func (g *hnswGraph) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * g.vi.opts.m
+	}
+	return g.vi.opts.m
+}
+
+// searchLayer returns the ef vectors closest to vec found from the entry points
+// following the links of the level
+func (g *hnswGraph) searchLayer(vec []float32, eps []string, ef int, level int) (hnswCands, error) {
+	visited := make(map[string]bool)
+	var cands, found hnswCands
+	for _, ep := range eps {
+		visited[ep] = true
+		d, ok, err := g.distanceTo(vec, ep)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// still walk its links
+			d = math.Inf(1)
+		} else {
+			found = found.insert(hnswCand{key: ep, dist: d})
+		}
+		cands = cands.insert(hnswCand{key: ep, dist: d})
+	}
+	for len(cands) > 0 {
+		c := cands[0]
+		cands = cands[1:]
+		if len(found) >= ef && c.dist > found[len(found)-1].dist {
+			break
+		}
+		n, err := g.node(c.key)
+		if err != nil {
+			return nil, err
+		}
+		if n == nil || level >= len(n.links) {
+			continue
+		}
+		for _, nb := range n.links[level] {
+			if visited[nb] {
+				continue
+			}
+			visited[nb] = true
+			d, ok, err := g.distanceTo(vec, nb)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if len(found) < ef || d < found[len(found)-1].dist {
+				cands = cands.insert(hnswCand{key: nb, dist: d})
+				found = found.insert(hnswCand{key: nb, dist: d})
+				if len(found) > ef {
+					found = found[:ef]
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// search returns the ef vectors of the graph closest to vec
+func (g *hnswGraph) search(vec []float32, ef int) (hnswCands, error) {
+	if !g.hasEntry {
+		return nil, nil
+	}
+	eps := []string{g.entry}
+	for level := g.entryLevel; level > 0; level-- {
+		found, err := g.searchLayer(vec, eps, 1, level)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) > 0 {
+			eps = []string{found[0].key}
+		}
+	}
+	return g.searchLayer(vec, eps, ef, 0)
+}
+
+// insert adds the vector of the key to the graph, linking it
+// to its nearest vectors at each of its levels
+func (g *hnswGraph) insert(key string, vec []float32, expiresAt uint64) error {
+	g.vectors[key] = vec
+	g.expires[key] = expiresAt
+	level := g.level(key)
+	n := &hnswNode{links: make([][]string, level+1)}
+	g.setNode(key, n)
+	if !g.hasEntry {
+		g.setEntry(key, level)
+		return nil
+	}
+	eps := []string{g.entry}
+	for l := g.entryLevel; l > level; l-- {
+		found, err := g.searchLayer(vec, eps, 1, l)
+		if err != nil {
+			return err
+		}
+		if len(found) > 0 {
+			eps = []string{found[0].key}
+		}
+	}
+	top := level
+	if top > g.entryLevel {
+		top = g.entryLevel
+	}
+	for l := top; l >= 0; l-- {
+		found, err := g.searchLayer(vec, eps, g.vi.opts.efConstruction, l)
+		if err != nil {
+			return err
+		}
+		var links []string
+		for _, c := range found {
+			if c.key != key && len(links) < g.vi.opts.m {
+				links = append(links, c.key)
+			}
+		}
+		n.links[l] = links
+		for _, nb := range links {
+			err = g.link(nb, key, l)
+			if err != nil {
+				return err
+			}
+		}
+		if len(found) > 0 {
+			eps = eps[:0]
+			for _, c := range found {
+				eps = append(eps, c.key)
+			}
+		}
+	}
+	if level > g.entryLevel {
+		g.setEntry(key, level)
+	}
+	return nil
+}
+
+// link adds key to the neighbors of nb at the level,
+// keeping the nearest ones when nb has too many
+func (g *hnswGraph) link(nb string, key string, level int) error {
+	n, err := g.node(nb)
+	if err != nil || n == nil || level >= len(n.links) {
+		return err
+	}
+	for _, l := range n.links[level] {
+		if l == key {
+			return nil
+		}
+	}
+	links := append(append([]string{}, n.links[level]...), key)
+	if len(links) > g.maxLinks(level) {
+		links, err = g.nearest(nb, links, g.maxLinks(level))
+		if err != nil {
+			return err
+		}
+	}
+	n.links[level] = links
+	g.setNode(nb, n)
+	return nil
+}
+
+// nearest returns up to max of the keys closest to the vector of key,
+// leaving out the keys whose vector is gone
+func (g *hnswGraph) nearest(key string, keys []string, max int) ([]string, error) {
+	vec, err := g.vector(key)
+	if err != nil || vec == nil {
+		return nil, err
+	}
+	var cands hnswCands
+	for _, k := range keys {
+		d, ok, err := g.distanceTo(vec, k)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cands = cands.insert(hnswCand{key: k, dist: d})
+		}
+	}
+	var nearest []string
+	for _, c := range cands {
+		if len(nearest) == max {
+			break
+		}
+		nearest = append(nearest, c.key)
+	}
+	return nearest, nil
+}
+
+// remove takes the key out of the graph, linking each of its neighbors
+// to the nearest of their neighbors and the key's
+func (g *hnswGraph) remove(key string) error {
+	n, err := g.node(key)
+	if err != nil || n == nil {
+		return err
+	}
+	// the vector is deleted already
+	g.vectors[key] = nil
+	for level, links := range n.links {
+		for _, nb := range links {
+			nn, err := g.node(nb)
+			if err != nil {
+				return err
+			}
+			if nn == nil || level >= len(nn.links) {
+				continue
+			}
+			seen := map[string]bool{key: true, nb: true}
+			var keys []string
+			for _, l := range append(append([]string{}, nn.links[level]...), links...) {
+				if !seen[l] {
+					seen[l] = true
+					keys = append(keys, l)
+				}
+			}
+			nn.links[level], err = g.nearest(nb, keys, g.maxLinks(level))
+			if err != nil {
+				return err
+			}
+			g.setNode(nb, nn)
+		}
+	}
+	g.setNode(key, nil)
+	if g.hasEntry && g.entry == key {
+		return g.newEntry()
+	}
+	return nil
+}
+
+// newEntry makes the node with the highest level the entry point
+func (g *hnswGraph) newEntry() error {
+	g.hasEntry, g.entryDirty = false, true
+	prefix := g.vi.nodesPrefix()
+	it := g.txn.NewIterator(IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key()[len(prefix):])
+		level := -1
+		if n, ok := g.nodes[key]; ok {
+			if n != nil {
+				level = len(n.links) - 1
+			}
+		} else {
+			err := item.Value(func(val []byte) error {
+				if len(val) > 0 {
+					level = int(val[0])
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if level >= 0 && (!g.hasEntry || level > g.entryLevel) {
+			g.setEntry(key, level)
+		}
+	}
+	return nil
+}
+
+// flush writes the nodes changed
+func (g *hnswGraph) flush() error {
+	for key := range g.dirty {
+		var err error
+		nodeKey := append(g.vi.nodesPrefix(), key...)
+		if n := g.nodes[key]; n != nil {
+			if _, ok := g.expires[key]; !ok {
+				_, err = g.vector(key)
+				if err != nil {
+					return err
+				}
+			}
+			err = g.txn.Set(&Entry{Key: nodeKey, Value: n.encode(), ExpiresAt: g.expires[key]})
+		} else {
+			err = g.txn.Delete(nodeKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	g.dirty = make(map[string]bool)
+	if !g.entryDirty {
+		return nil
+	}
+	g.entryDirty = false
+	if !g.hasEntry {
+		return g.txn.Delete(g.vi.entryKey())
+	}
+	entry := append([]byte{byte(g.entryLevel)}, g.entry...)
+	return g.txn.Set(&Entry{Key: g.vi.entryKey(), Value: entry})
+}
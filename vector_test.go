@@ -0,0 +1,273 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type DocObj struct {
+	Title string
+	Lang  string
+	Emb   []float32
+}
+
+func TestVectorIndex(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testVectorIndex(t, b.open(t))
+		})
+	}
+}
+
+func vectorKeys(t *testing.T, results []sett.VectorResult, err error) []string {
+	if err != nil {
+		t.Fatalf("Nearest failed %v", err)
+	}
+	keys := []string{}
+	for _, r := range results {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+func randomVector(r *rand.Rand, dims int) []float32 {
+	vec := make([]float32, dims)
+	for i := range vec {
+		vec[i] = r.Float32()*2 - 1
+	}
+	return vec
+}
+
+func testVectorIndex(t *testing.T, s *sett.Sett) {
+	gob.Register(&DocObj{})
+	docs := s.Table("docs")
+	docs.SetStruct("x", &DocObj{Title: "x", Lang: "en", Emb: []float32{1, 0, 0}})
+	docs.SetStruct("y", &DocObj{Title: "y", Lang: "fr", Emb: []float32{0, 1, 0}})
+	docs.SetStruct("xy", &DocObj{Title: "xy", Lang: "en", Emb: []float32{1, 1, 0}})
+	docs.SetStruct("none", &DocObj{Title: "no embedding"})
+
+	_, err := docs.VectorIndex("Emb", 3, "manhattan")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown metric")
+	}
+	exact, err := docs.VectorIndex("Emb", 3, sett.Cosine)
+	if err != nil {
+		t.Fatalf("VectorIndex failed %v", err)
+	}
+	results, err := exact.Nearest([]float32{1, 0.1, 0}, 2, nil)
+	if keys := vectorKeys(t, results, err); !reflect.DeepEqual(keys, []string{"x", "xy"}) {
+		t.Errorf("Expected x then xy, got %v", keys)
+	}
+	if results[0].Distance > 0.01 || results[0].Value.(*DocObj).Title != "x" {
+		t.Errorf("Unexpected nearest result %+v", results[0])
+	}
+	results, err = exact.Nearest([]float32{1, 0.1, 0}, 5, func(k string, v interface{}) bool {
+		return v.(*DocObj).Lang == "fr"
+	})
+	if keys := vectorKeys(t, results, err); !reflect.DeepEqual(keys, []string{"y"}) {
+		t.Errorf("Expected only y with the filter, got %v", keys)
+	}
+	_, err = exact.Nearest([]float32{1, 0}, 2, nil)
+	if err == nil {
+		t.Errorf("Expected an error for a vector with other dimensions")
+	}
+	err = docs.SetStruct("bad", &DocObj{Emb: []float32{1, 2}})
+	if err == nil {
+		t.Errorf("Expected writing a vector with other dimensions to fail")
+	}
+	_, err = docs.VectorIndex("Emb", 3, sett.Euclidean)
+	if err == nil {
+		t.Errorf("Expected an error for other settings of the index")
+	}
+	err = exact.Drop()
+	if err != nil {
+		t.Fatalf("Drop failed %v", err)
+	}
+	_, err = exact.Nearest([]float32{1, 0, 0}, 2, nil)
+	if err == nil {
+		t.Errorf("Expected an error for a dropped index")
+	}
+
+	// dropped by its field, with other settings
+	_, err = docs.VectorIndex("Emb", 3, sett.DotProduct, sett.VectorHNSW(4, 0, 0))
+	if err != nil {
+		t.Fatalf("VectorIndex failed %v", err)
+	}
+	err = docs.DropVectorIndex("Emb")
+	if err != nil {
+		t.Fatalf("DropVectorIndex failed %v", err)
+	}
+	_, err = docs.VectorIndex("Emb", 3, sett.Euclidean)
+	if err != nil {
+		t.Errorf("Expected the index dropped with its settings, got %v", err)
+	}
+	docs.DropVectorIndex("Emb")
+
+	// HNSW against the exact results
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		docs.SetStruct(fmt.Sprintf("d%03d", i), &DocObj{Title: fmt.Sprint(i), Lang: []string{"en", "fr"}[i%2], Emb: randomVector(r, 8)})
+	}
+	docs.Delete("x")
+	docs.Delete("y")
+	docs.Delete("xy")
+	exact, err = docs.VectorIndex("Emb", 8, sett.Euclidean)
+	if err != nil {
+		t.Fatalf("VectorIndex failed %v", err)
+	}
+	items, err := s.Table("hnsw").VectorIndex("Emb", 8, sett.Euclidean, sett.VectorHNSW(8, 100, 40))
+	if err != nil {
+		t.Fatalf("VectorIndex with HNSW failed %v", err)
+	}
+	hnswTable := s.Table("hnsw")
+	r = rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		err = hnswTable.SetStruct(fmt.Sprintf("d%03d", i), &DocObj{Title: fmt.Sprint(i), Lang: []string{"en", "fr"}[i%2], Emb: randomVector(r, 8)})
+		if err != nil {
+			t.Fatalf("SetStruct with HNSW failed %v", err)
+		}
+	}
+	recall := func(label string, filter sett.FilterFunc) {
+		found, total := 0, 0
+		q := rand.New(rand.NewSource(2))
+		for i := 0; i < 20; i++ {
+			vec := randomVector(q, 8)
+			results, err := exact.Nearest(vec, 5, filter)
+			want := vectorKeys(t, results, err)
+			results, err = items.Nearest(vec, 5, filter)
+			got := vectorKeys(t, results, err)
+			if len(got) != 5 {
+				t.Fatalf("%s: expected 5 results, got %v", label, got)
+			}
+			for _, k := range want {
+				total++
+				for _, g := range got {
+					if g == k {
+						found++
+					}
+				}
+			}
+		}
+		if float64(found) < 0.9*float64(total) {
+			t.Errorf("%s: HNSW found %d of the %d nearest vectors", label, found, total)
+		}
+	}
+	recall("all", nil)
+	recall("filtered", func(k string, v interface{}) bool {
+		return v.(*DocObj).Lang == "fr"
+	})
+
+	// the graph follows updates and deletes
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			hnswTable.Delete(fmt.Sprintf("d%03d", i))
+			docs.Delete(fmt.Sprintf("d%03d", i))
+		} else {
+			vec := randomVector(r, 8)
+			hnswTable.SetStruct(fmt.Sprintf("d%03d", i), &DocObj{Lang: "fr", Emb: vec})
+			docs.SetStruct(fmt.Sprintf("d%03d", i), &DocObj{Lang: "fr", Emb: vec})
+		}
+	}
+	recall("updated", nil)
+	results, err = items.Nearest(randomVector(r, 8), 300, nil)
+	if keys := vectorKeys(t, results, err); len(keys) != 250 {
+		t.Errorf("Expected the 250 vectors left, got %d", len(keys))
+	}
+
+	err = items.Rebuild()
+	if err != nil {
+		t.Fatalf("Rebuild failed %v", err)
+	}
+	recall("rebuilt", nil)
+
+	allKeys, _ := s.Keys()
+	for _, k := range allKeys {
+		if k != "" && k[0] == 0xff {
+			t.Errorf("Vector index entries listed as keys")
+		}
+	}
+}
+
+func TestVectorExpiry(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testVectorExpiry(t, b.open(t))
+		})
+	}
+}
+
+func testVectorExpiry(t *testing.T, s *sett.Sett) {
+	gob.Register(&DocObj{})
+	items, err := s.Table("hnsw").VectorIndex("Emb", 8, sett.Euclidean, sett.VectorHNSW(8, 100, 40))
+	if err != nil {
+		t.Fatalf("VectorIndex with HNSW failed %v", err)
+	}
+	exact, err := s.Table("exact").VectorIndex("Emb", 8, sett.Euclidean)
+	if err != nil {
+		t.Fatalf("VectorIndex failed %v", err)
+	}
+	hnswTable := s.Table("hnsw")
+	r := rand.New(rand.NewSource(1))
+	expiry := time.Now().Add(3 * time.Second)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("d%03d", i)
+		doc := &DocObj{Emb: randomVector(r, 8)}
+		err = hnswTable.SetStruct(key, doc, sett.ExpireAt(expiry))
+		if err != nil {
+			t.Fatalf("SetStruct failed %v", err)
+		}
+		if i%2 == 0 {
+			s.Table("exact").SetStruct(key, doc)
+		}
+	}
+	// the graph keeps the vectors which don't expire any more
+	for i := 0; i < 200; i += 2 {
+		err = hnswTable.Persist(fmt.Sprintf("d%03d", i))
+		if err != nil {
+			t.Fatalf("Persist failed %v", err)
+		}
+	}
+	time.Sleep(time.Until(expiry) + 1100*time.Millisecond)
+
+	found, total := 0, 0
+	q := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		vec := randomVector(q, 8)
+		results, err := exact.Nearest(vec, 5, nil)
+		want := vectorKeys(t, results, err)
+		results, err = items.Nearest(vec, 5, nil)
+		got := vectorKeys(t, results, err)
+		for _, k := range want {
+			total++
+			for _, g := range got {
+				if g == k {
+					found++
+				}
+			}
+		}
+	}
+	if float64(found) < 0.9*float64(total) {
+		t.Errorf("HNSW found %d of the %d nearest vectors left", found, total)
+	}
+	// the links to the expired vectors are gone as well, which
+	// can leave a few vectors out until the index is rebuilt
+	results, err := items.Nearest(randomVector(q, 8), 200, nil)
+	if keys := vectorKeys(t, results, err); len(keys) < 90 || len(keys) > 100 {
+		t.Errorf("Expected the 100 vectors left, got %d", len(keys))
+	}
+	err = items.Rebuild()
+	if err != nil {
+		t.Fatalf("Rebuild failed %v", err)
+	}
+	results, err = items.Nearest(randomVector(q, 8), 200, nil)
+	if keys := vectorKeys(t, results, err); len(keys) != 100 {
+		t.Errorf("Expected the 100 vectors left after Rebuild, got %d", len(keys))
+	}
+}
@@ -0,0 +1,204 @@
+package sett
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrVersionConflict is matched by the errors of the conditional writes
+// refused because the value changed since it was read
+var ErrVersionConflict = errors.New("The item was changed")
+
+// VersionConflictError is returned by SetIfVersion, SetIfAbsent and
+// CompareAndSwap when the condition doesn't hold
+type VersionConflictError struct {
+	Key string
+	// Expected is the version the write expected
+	Expected uint64
+	// Actual is the version of the item, 0 if there is none
+	Actual uint64
+}
+
+func (e *VersionConflictError) Error() string {
+	switch {
+	case e.Actual == 0:
+		return fmt.Sprintf("The item with key %s doesn't exist", e.Key)
+	case e.Expected == 0:
+		return fmt.Sprintf("The item with key %s was changed, it is at version %d", e.Key, e.Actual)
+	}
+	return fmt.Sprintf("The item with key %s is at version %d, expected %d", e.Key, e.Actual, e.Expected)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// GetWithVersion returns the value of the key and its version.
+// The version changes on every write of the key and can be passed to
+// SetIfVersion, for example as the ETag of a HTTP resource
+func (s *Sett) GetWithVersion(key string) (interface{}, uint64, error) {
+	return s.GetWithVersionCtx(context.Background(), key)
+}
+
+// GetWithVersionCtx is GetWithVersion with a context
+func (s *Sett) GetWithVersionCtx(ctx context.Context, key string) (v interface{}, version uint64, err error) {
+	err = s.do(ctx, OpGet, key, nil, func(c *opCall) error {
+		return s.db.View(func(txn Txn) error {
+			si := NewSettItem(s, txn, c.key)
+			si.call = c
+			item, err := txn.Get([]byte(si.fullKey))
+			if err != nil {
+				return err
+			}
+			version = item.Version()
+			v, err = si.value(item)
+			c.result = v
+			return err
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, version, nil
+}
+
+// value returns the string, struct or time series value of the item
+func (si *SettItem) value(item Item) (interface{}, error) {
+	switch item.UserMeta() & 0x0F {
+	case STRING_TYPE:
+		return si.stringValue(item)
+	case POINT_TYPE, ROLLUP_TYPE:
+		val, err := si.readValue(item)
+		if err != nil {
+			return nil, err
+		}
+		return decodeValue(item.UserMeta(), val)
+	}
+	var sv *SettValueItem
+	var err error
+	if c := si.s.options().cache; c != nil {
+		sv, err = si.cachedStruct(c, item)
+	} else {
+		sv, err = si.structValue(item)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sv.V, nil
+}
+
+// SetIfVersion sets the value only if the key is still at the version
+// returned by GetWithVersion. A version of 0 sets the value only if the
+// key doesn't exist. Fails with a *VersionConflictError otherwise
+func (s *Sett) SetIfVersion(key string, val interface{}, version uint64, opts ...SetOption) error {
+	return s.SetIfVersionCtx(context.Background(), key, val, version, opts...)
+}
+
+// SetIfVersionCtx is SetIfVersion with a context
+func (s *Sett) SetIfVersionCtx(ctx context.Context, key string, val interface{}, version uint64, opts ...SetOption) error {
+	return s.do(ctx, OpSet, key, val, func(c *opCall) error {
+		return s.setIf(c, opts, func(si *SettItem, item Item) error {
+			var actual uint64
+			if item != nil {
+				actual = item.Version()
+			}
+			if actual != version {
+				return &VersionConflictError{Key: c.key, Expected: version, Actual: actual}
+			}
+			return nil
+		})
+	})
+}
+
+// SetIfAbsent sets the value only if the key doesn't exist.
+// Fails with a *VersionConflictError otherwise
+func (s *Sett) SetIfAbsent(key string, val interface{}, opts ...SetOption) error {
+	return s.SetIfVersion(key, val, 0, opts...)
+}
+
+// SetIfAbsentCtx is SetIfAbsent with a context
+func (s *Sett) SetIfAbsentCtx(ctx context.Context, key string, val interface{}, opts ...SetOption) error {
+	return s.SetIfVersionCtx(ctx, key, val, 0, opts...)
+}
+
+// CompareAndSwap sets the value to newVal only if the current value is
+// equal to oldVal, as compared by reflect.DeepEqual. A nil oldVal swaps only
+// if the key doesn't exist. Fails with a *VersionConflictError otherwise
+func (s *Sett) CompareAndSwap(key string, oldVal interface{}, newVal interface{}, opts ...SetOption) error {
+	return s.CompareAndSwapCtx(context.Background(), key, oldVal, newVal, opts...)
+}
+
+// CompareAndSwapCtx is CompareAndSwap with a context
+func (s *Sett) CompareAndSwapCtx(ctx context.Context, key string, oldVal interface{}, newVal interface{}, opts ...SetOption) error {
+	return s.do(ctx, OpSet, key, newVal, func(c *opCall) error {
+		return s.setIf(c, opts, func(si *SettItem, item Item) error {
+			if item == nil {
+				if oldVal == nil {
+					return nil
+				}
+				return &VersionConflictError{Key: c.key}
+			}
+			cur, err := si.value(item)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(cur, oldVal) {
+				return &VersionConflictError{Key: c.key, Actual: item.Version()}
+			}
+			return nil
+		})
+	})
+}
+
+// setIf writes c.value in the transaction checking the condition,
+// so that the key can't change in between. item is nil if there is none.
+// A transaction conflict fails with a *VersionConflictError when the key
+// changed after the condition held. Otherwise the conflict was on the
+// data kept with the key, like its indexes, and the write is tried again
+func (s *Sett) setIf(c *opCall, opts []SetOption, cond func(si *SettItem, item Item) error) error {
+	var err error
+	for t := 0; t < 10; t++ {
+		var seen uint64
+		err = s.db.Update(func(txn Txn) error {
+			si := NewSettItem(s, txn, c.key)
+			si.call = c
+			si.WithOptions(opts...)
+			item, err := currentItem(txn, []byte(si.fullKey))
+			if err != nil {
+				return err
+			}
+			if item != nil {
+				seen = item.Version()
+			}
+			err = cond(si, item)
+			if err != nil {
+				return err
+			}
+			if val, ok := c.value.(string); ok {
+				return si.SetStringValue(val)
+			}
+			return si.SetStructValue(c.value)
+		})
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+		var actual uint64
+		readErr := s.db.View(func(txn Txn) error {
+			item, err := currentItem(txn, []byte(s.makeKey(c.key)))
+			if err == nil && item != nil {
+				actual = item.Version()
+			}
+			return err
+		})
+		if readErr != nil {
+			return readErr
+		}
+		if actual != seen {
+			return &VersionConflictError{Key: c.key, Expected: seen, Actual: actual}
+		}
+		s.count(EventConflictRetry)
+	}
+	return err
+}
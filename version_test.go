@@ -0,0 +1,150 @@
+package sett_test
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/prasanthmj/sett/v2"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			testConditionalWrites(t, b.open(t))
+		})
+	}
+}
+
+func testConditionalWrites(t *testing.T, s *sett.Sett) {
+	gob.Register(&TaskObj{})
+	tbl := s.Table("etag")
+
+	err := tbl.SetIfAbsent("t1", &TaskObj{ID: 1, Status: "open"})
+	if err != nil {
+		t.Fatalf("SetIfAbsent failed %v", err)
+	}
+	err = tbl.SetIfAbsent("t1", &TaskObj{ID: 1, Status: "other"})
+	if !errors.Is(err, sett.ErrVersionConflict) {
+		t.Errorf("Expected a conflict setting an existing key, got %v", err)
+	}
+
+	v, version, err := tbl.GetWithVersion("t1")
+	if err != nil || version == 0 || v.(*TaskObj).Status != "open" {
+		t.Fatalf("GetWithVersion returned %v %d %v", v, version, err)
+	}
+	err = tbl.SetIfVersion("t1", &TaskObj{ID: 1, Status: "closed"}, version)
+	if err != nil {
+		t.Fatalf("SetIfVersion failed %v", err)
+	}
+	err = tbl.SetIfVersion("t1", &TaskObj{ID: 1, Status: "reopened"}, version)
+	var conflict *sett.VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Expected != version || conflict.Actual == version {
+		t.Errorf("Expected a conflict with a stale version, got %v", err)
+	}
+	v, _ = tbl.GetStruct("t1")
+	if v.(*TaskObj).Status != "closed" {
+		t.Errorf("The stale write changed the value %+v", v)
+	}
+
+	tbl.SetStr("s1", "a")
+	err = tbl.CompareAndSwap("s1", "b", "c")
+	if !errors.Is(err, sett.ErrVersionConflict) {
+		t.Errorf("Expected a conflict swapping a different value, got %v", err)
+	}
+	err = tbl.CompareAndSwap("s1", "a", "c")
+	if err != nil {
+		t.Errorf("CompareAndSwap failed %v", err)
+	}
+	err = tbl.CompareAndSwap("t1", &TaskObj{ID: 1, Status: "closed"}, &TaskObj{ID: 1, Status: "done"})
+	if err != nil {
+		t.Errorf("CompareAndSwap of a struct failed %v", err)
+	}
+	err = tbl.CompareAndSwap("missing", nil, "new")
+	if err != nil {
+		t.Errorf("CompareAndSwap of an absent key failed %v", err)
+	}
+	v, _ = tbl.Get("s1")
+	if v != "c" {
+		t.Errorf("Expected the swapped value, got %v", v)
+	}
+
+	tbl.Lock("s1")
+	_, version, _ = tbl.GetWithVersion("s1")
+	err = tbl.SetIfVersion("s1", "d", version)
+	if !errors.Is(err, sett.ErrLocked) {
+		t.Errorf("Expected a locked item to refuse the write, got %v", err)
+	}
+}
+
+func TestConcurrentCompareAndSwap(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			tbl := b.open(t).Table("swap")
+			tbl.SetStr("n", "a")
+			errs := make(chan error, 50)
+			var wg sync.WaitGroup
+			for w := 0; w < 50; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						// racing transactions conflict on badger
+						err := tbl.CompareAndSwap("n", "a", "a")
+						var conflict *sett.VersionConflictError
+						if err != nil && (!errors.As(err, &conflict) || conflict.Key != "n") {
+							errs <- err
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("Expected only version conflicts, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSetIfAbsentIndexConflicts(t *testing.T) {
+	gob.Register(&DocObj{})
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			docs := b.open(t).Table("docs")
+			_, err := docs.VectorIndex("Emb", 4, sett.Euclidean, sett.VectorHNSW(4, 20, 20))
+			if err != nil {
+				t.Fatalf("VectorIndex failed %v", err)
+			}
+			errs := make(chan error, 20)
+			var wg sync.WaitGroup
+			for w := 0; w < 20; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					r := rand.New(rand.NewSource(int64(w)))
+					for i := 0; i < 10; i++ {
+						// the writes of other keys conflict on the graph, not on the key
+						err := docs.SetIfAbsent(fmt.Sprintf("d%d-%d", w, i), &DocObj{Emb: randomVector(r, 4)})
+						var conflict *sett.VersionConflictError
+						if errors.As(err, &conflict) {
+							errs <- err
+							return
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("Expected no version conflict, got %v", err)
+			}
+		})
+	}
+}